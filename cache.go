@@ -0,0 +1,238 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// instrumentationCache records the packages that need no instrumentation, so that subsequent runs skip them without
+// parsing and type-checking. An entry is keyed by the names and the content of package files, the export data of the
+// imported packages and the go-instana configuration hash, so any change to either of them results in a cache miss.
+// A nil cache is valid and disabled. The entries that have not been used for cacheTrimLimit are removed.
+type instrumentationCache struct {
+	dir    string
+	config string
+
+	mu           sync.Mutex
+	exportHashes map[string]string
+}
+
+// The cache is trimmed at most once per cacheTrimInterval and the entries unused for cacheTrimLimit are removed. The
+// modification time of used entries is updated at most once per cacheMtimeInterval.
+const (
+	cacheTrimInterval  = 24 * time.Hour
+	cacheTrimLimit     = 5 * 24 * time.Hour
+	cacheMtimeInterval = time.Hour
+)
+
+// openInstrumentationCache returns the cache stored inside the go-instana cache dir. It returns nil if the cache
+// is disabled with the -no-cache flag or cannot be used.
+func openInstrumentationCache() *instrumentationCache {
+	if args.NoCache {
+		return nil
+	}
+
+	cacheDir, err := goInstanaCacheDir()
+	if err != nil {
+		log.Debug().Msgf("instrumentation cache is disabled: %s", err)
+		return nil
+	}
+
+	dir := filepath.Join(cacheDir, "unchanged")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Debug().Msgf("instrumentation cache is disabled: %s", err)
+		return nil
+	}
+
+	c := &instrumentationCache{dir: dir, config: configHash()}
+	c.Trim(time.Now())
+
+	return c
+}
+
+// Key returns the cache key of the package consisting of `files` type-checked with `deps`. It returns an empty
+// string if the cache is disabled or any of files cannot be read.
+func (c *instrumentationCache) Key(files []string, deps exportData) string {
+	if c == nil {
+		return ""
+	}
+
+	fNames := append([]string(nil), files...)
+	sort.Strings(fNames)
+
+	imports := make(map[string]struct{})
+
+	h := sha256.New()
+	fmt.Fprintf(h, "config=%s\n", c.config)
+	for _, fName := range fNames {
+		data, err := ioutil.ReadFile(fName)
+		if err != nil {
+			return ""
+		}
+
+		// the result only depends on the base names of files, which allows to reuse the entries of packages
+		// compiled from temporary directories
+		fmt.Fprintf(h, "file=%s %x\n", filepath.Base(fName), sha256.Sum256(data))
+
+		f, err := parser.ParseFile(token.NewFileSet(), fName, data, parser.ImportsOnly)
+		if err != nil {
+			continue
+		}
+
+		for _, imp := range f.Imports {
+			if path, err := strconv.Unquote(imp.Path.Value); err == nil {
+				imports[path] = struct{}{}
+			}
+		}
+	}
+
+	// the export data files of packages compiled with -toolexec are written to $WORK, that changes on every build,
+	// so the content of the file is used instead of its name
+	for _, path := range sortedKeys(imports) {
+		sum, err := c.exportDataHash(deps[path])
+		if err != nil {
+			return ""
+		}
+
+		fmt.Fprintf(h, "import=%s %s\n", path, sum)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// exportDataHash returns the hash of the export data file content. The hashes are memoized, since the same
+// dependencies are imported by many packages. The hash of an empty file name, i.e. of a package without export
+// data, is empty.
+func (c *instrumentationCache) exportDataHash(fName string) (string, error) {
+	if fName == "" {
+		return "", nil
+	}
+
+	c.mu.Lock()
+	sum, ok := c.exportHashes[fName]
+	c.mu.Unlock()
+
+	if ok {
+		return sum, nil
+	}
+
+	data, err := ioutil.ReadFile(fName)
+	if err != nil {
+		return "", err
+	}
+
+	sum = fmt.Sprintf("%x", sha256.Sum256(data))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.exportHashes == nil {
+		c.exportHashes = make(map[string]string)
+	}
+	c.exportHashes[fName] = sum
+
+	return sum, nil
+}
+
+// Contains returns whether the package with provided key is known to need no instrumentation
+func (c *instrumentationCache) Contains(key string) bool {
+	if c == nil || key == "" {
+		return false
+	}
+
+	fName := c.entryPath(key)
+
+	fi, err := os.Stat(fName)
+	if err != nil {
+		return false
+	}
+
+	// the entry is marked as used, so that it's not removed by Trim
+	if now := time.Now(); now.Sub(fi.ModTime()) > cacheMtimeInterval {
+		os.Chtimes(fName, now, now)
+	}
+
+	return true
+}
+
+// Add records that the package with provided key needs no instrumentation
+func (c *instrumentationCache) Add(key string) {
+	if c == nil || key == "" {
+		return
+	}
+
+	fName := c.entryPath(key)
+	if err := os.MkdirAll(filepath.Dir(fName), 0755); err != nil {
+		log.Debug().Msgf("failed to add cache entry %s: %s", key, err)
+		return
+	}
+
+	// an entry is an empty file, so concurrent writers of the same entry never conflict
+	if err := ioutil.WriteFile(fName, nil, 0644); err != nil {
+		log.Debug().Msgf("failed to add cache entry %s: %s", key, err)
+	}
+}
+
+// Trim removes the entries that have not been used for cacheTrimLimit. The cache is only scanned if it hasn't
+// been trimmed for cacheTrimInterval, so that the check is cheap enough to be made on every run.
+func (c *instrumentationCache) Trim(now time.Time) {
+	if c == nil {
+		return
+	}
+
+	marker := filepath.Join(c.dir, "trim.txt")
+	if fi, err := os.Stat(marker); err == nil && now.Sub(fi.ModTime()) < cacheTrimInterval {
+		return
+	}
+
+	subdirs, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		log.Debug().Msgf("failed to trim instrumentation cache: %s", err)
+		return
+	}
+
+	for _, subdir := range subdirs {
+		if !subdir.IsDir() {
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(filepath.Join(c.dir, subdir.Name()))
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			if now.Sub(entry.ModTime()) > cacheTrimLimit {
+				os.Remove(filepath.Join(c.dir, subdir.Name(), entry.Name()))
+			}
+		}
+	}
+
+	if err := ioutil.WriteFile(marker, []byte(now.Format(time.RFC3339)+"\n"), 0644); err != nil {
+		log.Debug().Msgf("failed to trim instrumentation cache: %s", err)
+		return
+	}
+
+	// the time of the last trim is tracked with the modification time of the marker file
+	os.Chtimes(marker, now, now)
+}
+
+// entryPath returns the name of the file storing the cache entry. Entries are spread across subdirectories
+// named after the first two characters of the key.
+func (c *instrumentationCache) entryPath(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
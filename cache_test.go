@@ -0,0 +1,197 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrumentationCache_Key(t *testing.T) {
+	cache := &instrumentationCache{dir: t.TempDir(), config: "test"}
+
+	writeFile := func(dir, code string) string {
+		fName := filepath.Join(dir, "main.go")
+		require.NoError(t, os.WriteFile(fName, []byte(code), 0644))
+
+		return fName
+	}
+
+	exportDir := t.TempDir()
+	writeExportFile := func(name, content string) string {
+		fName := filepath.Join(exportDir, name)
+		require.NoError(t, os.WriteFile(fName, []byte(content), 0644))
+
+		return fName
+	}
+
+	code := "package main\n\nimport \"net/http\"\n\nvar _ = http.DefaultClient\n"
+	deps := exportData{"net/http": writeExportFile("http-d", "http"), "fmt": writeExportFile("fmt-d", "fmt")}
+
+	key := cache.Key([]string{writeFile(t.TempDir(), code)}, deps)
+	require.NotEmpty(t, key)
+
+	t.Run("same content in another dir", func(t *testing.T) {
+		assert.Equal(t, key, cache.Key([]string{writeFile(t.TempDir(), code)}, deps))
+	})
+
+	t.Run("changed content", func(t *testing.T) {
+		assert.NotEqual(t, key, cache.Key([]string{writeFile(t.TempDir(), code+"\nvar x int\n")}, deps))
+	})
+
+	t.Run("changed export data of an imported package", func(t *testing.T) {
+		assert.NotEqual(t, key, cache.Key([]string{writeFile(t.TempDir(), code)}, exportData{"net/http": writeExportFile("http2-d", "http2"), "fmt": deps["fmt"]}))
+	})
+
+	t.Run("changed export data of another package", func(t *testing.T) {
+		assert.Equal(t, key, cache.Key([]string{writeFile(t.TempDir(), code)}, exportData{"net/http": deps["net/http"], "fmt": writeExportFile("fmt2-d", "fmt2")}))
+	})
+
+	t.Run("missing export data file", func(t *testing.T) {
+		assert.Empty(t, cache.Key([]string{writeFile(t.TempDir(), code)}, exportData{"net/http": filepath.Join(exportDir, "missing")}))
+	})
+
+	t.Run("changed config", func(t *testing.T) {
+		other := &instrumentationCache{dir: cache.dir, config: "other"}
+		assert.NotEqual(t, key, other.Key([]string{writeFile(t.TempDir(), code)}, deps))
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		assert.Empty(t, cache.Key([]string{filepath.Join(t.TempDir(), "missing.go")}, deps))
+	})
+}
+
+func TestInstrumentationCache_Key_Toolexec(t *testing.T) {
+	cache := &instrumentationCache{dir: t.TempDir(), config: "test"}
+
+	// the compiler receives the same inputs located in different $WORK dirs on each build
+	compileFiles := func() ([]string, exportData) {
+		workDir := t.TempDir()
+
+		for dir, content := range map[string]string{
+			"b001": "package main\n\nimport \"example.com/lib\"\n\nvar _ = lib.X\n",
+			"b002": "lib export data",
+		} {
+			require.NoError(t, os.MkdirAll(filepath.Join(workDir, dir), 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(workDir, dir, "_pkg_.a"), []byte(content), 0644))
+		}
+
+		fName := filepath.Join(workDir, "b001", "main.cgo1.go")
+		require.NoError(t, os.WriteFile(fName, []byte("package main\n\nimport \"example.com/lib\"\n\nvar _ = lib.X\n"), 0644))
+
+		return []string{fName}, exportData{"example.com/lib": filepath.Join(workDir, "b002", "_pkg_.a")}
+	}
+
+	key := cache.Key(compileFiles())
+	require.NotEmpty(t, key)
+	cache.Add(key)
+
+	other := cache.Key(compileFiles())
+	assert.Equal(t, key, other)
+	assert.True(t, cache.Contains(other))
+}
+
+func TestInstrumentationCache_Trim(t *testing.T) {
+	cache := &instrumentationCache{dir: t.TempDir(), config: "test"}
+
+	now := time.Now()
+
+	cache.Add("0123456789abcdef")
+	cache.Add("fedcba9876543210")
+
+	// the entry is not used for longer than the limit, while the other one has been used recently
+	old := now.Add(-cacheTrimLimit - time.Hour)
+	require.NoError(t, os.Chtimes(cache.entryPath("0123456789abcdef"), old, old))
+
+	cache.Trim(now)
+	assert.False(t, cache.Contains("0123456789abcdef"))
+	assert.True(t, cache.Contains("fedcba9876543210"))
+
+	// the cache has been trimmed recently, so the outdated entries are kept
+	cache.Add("0123456789abcdef")
+	require.NoError(t, os.Chtimes(cache.entryPath("0123456789abcdef"), old, old))
+
+	cache.Trim(now.Add(time.Hour))
+	assert.FileExists(t, cache.entryPath("0123456789abcdef"))
+
+	cache.Trim(now.Add(cacheTrimInterval + time.Hour))
+	assert.NoFileExists(t, cache.entryPath("0123456789abcdef"))
+}
+
+func TestInstrumentationCache_Contains_UpdatesMtime(t *testing.T) {
+	cache := &instrumentationCache{dir: t.TempDir(), config: "test"}
+	cache.Add("0123456789abcdef")
+
+	old := time.Now().Add(-cacheTrimLimit)
+	require.NoError(t, os.Chtimes(cache.entryPath("0123456789abcdef"), old, old))
+
+	require.True(t, cache.Contains("0123456789abcdef"))
+
+	fi, err := os.Stat(cache.entryPath("0123456789abcdef"))
+	require.NoError(t, err)
+	assert.True(t, fi.ModTime().After(old.Add(cacheMtimeInterval)))
+}
+
+func TestInstrumentationCache_Add(t *testing.T) {
+	cache := &instrumentationCache{dir: t.TempDir(), config: "test"}
+
+	assert.False(t, cache.Contains("0123456789abcdef"))
+	cache.Add("0123456789abcdef")
+	assert.True(t, cache.Contains("0123456789abcdef"))
+
+	assert.False(t, cache.Contains(""))
+
+	var disabled *instrumentationCache
+	assert.Empty(t, disabled.Key(nil, nil))
+	disabled.Add("0123456789abcdef")
+	assert.False(t, disabled.Contains("0123456789abcdef"))
+}
+
+func TestInstrumentCode_Cache(t *testing.T) {
+	cache := &instrumentationCache{dir: t.TempDir(), config: "test"}
+
+	srcDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "main.go"), []byte(`package main
+
+import "net/http"
+
+func main() {
+	http.HandleFunc("/", http.NotFound)
+}
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, instanaGoFileName), []byte(`// Code generated by go-instana, DO NOT EDIT.
+
+package main
+
+import instana "github.com/instana/go-sensor"
+
+var __instanaSensor = instana.NewSensor("")
+`), 0644))
+
+	files, err := packageFiles(srcDir)
+	require.NoError(t, err)
+
+	// the package is instrumented, so it's not recorded
+	require.NoError(t, instrumentCode(log.Logger, srcDir, nil, cache, nil))
+	assert.False(t, cache.Contains(cache.Key(files, nil)))
+
+	data, err := os.ReadFile(filepath.Join(srcDir, "main.go"))
+	require.NoError(t, err)
+	require.Contains(t, string(data), "instana.TracingHandlerFunc")
+
+	// the instrumented code needs no changes anymore
+	require.NoError(t, instrumentCode(log.Logger, srcDir, nil, cache, nil))
+	assert.True(t, cache.Contains(cache.Key(files, nil)))
+
+	require.NoError(t, instrumentCode(log.Logger, srcDir, nil, cache, nil))
+
+	instrumented, err := os.ReadFile(filepath.Join(srcDir, "main.go"))
+	require.NoError(t, err)
+	assert.Equal(t, string(data), string(instrumented))
+}
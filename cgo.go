@@ -0,0 +1,113 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cgoGeneratedFileSuffix is the suffix of files produced by cgo out of the package source files
+// containing `import "C"`
+const cgoGeneratedFileSuffix = ".cgo1.go"
+
+// cgoTypesFileName is the name of the file generated by cgo, that declares the Go counterparts of `C.*` references
+// used by the generated package files
+const cgoTypesFileName = "_cgo_gotypes.go"
+
+// isCgoTypesFile returns whether the file declares the types and functions generated by cgo. Such files are only
+// needed to type-check the package and are never instrumented.
+func isCgoTypesFile(fName string) bool {
+	return filepath.Base(fName) == cgoTypesFileName
+}
+
+// cgoSourceFile returns the name of the original source file for a Go file generated by cgo. For the
+// packages that use cgo the compiler receives the files generated in $WORK instead of the original
+// ones. The generated code keeps the original one intact except `C.*` references, and starts with
+// a //line directive pointing to the source file. The relative paths written by cgo invoked with
+// -trimpath are resolved using the rewrites of the same flag passed to the compiler.
+func cgoSourceFile(fName, trimPath string) (string, bool) {
+	if !strings.HasSuffix(fName, cgoGeneratedFileSuffix) {
+		return "", false
+	}
+
+	fd, err := os.Open(fName)
+	if err != nil {
+		return "", false
+	}
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "//line ") {
+			src, ok := parseLineDirectiveFile(strings.TrimPrefix(line, "//line "))
+			if !ok || filepath.IsAbs(src) {
+				return src, ok
+			}
+
+			return untrimPath(src, trimPath)
+		}
+
+		// the directive is expected to precede the package clause
+		if line != "" && !strings.HasPrefix(line, "//") {
+			break
+		}
+	}
+
+	return "", false
+}
+
+// parseLineDirectiveFile extracts the file name from a //line directive value in form of
+// filename:line[:col]
+func parseLineDirectiveFile(s string) (string, bool) {
+	for i := 0; i < 2; i++ {
+		idx := strings.LastIndex(s, ":")
+		if idx < 0 || !isDecimal(s[idx+1:]) {
+			break
+		}
+
+		s = s[:idx]
+	}
+
+	if s == "" {
+		return "", false
+	}
+
+	return s, true
+}
+
+// untrimPath reverts the rewrites of -trimpath compiler flag, provided as a list of from=>to rules separated by
+// semicolons, to restore the absolute path of a source file. The go command rewrites the package dir into its import
+// path, e.g. /src/app=>example.com/app.
+func untrimPath(fName, trimPath string) (string, bool) {
+	for _, rule := range strings.Split(trimPath, ";") {
+		from, to, ok := strings.Cut(rule, "=>")
+		if !ok || to == "" || !filepath.IsAbs(from) {
+			continue
+		}
+
+		if rel := strings.TrimPrefix(fName, to+"/"); rel != fName {
+			return filepath.Join(from, filepath.FromSlash(rel)), true
+		}
+	}
+
+	return "", false
+}
+
+func isDecimal(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,206 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCgoSourceFile(t *testing.T) {
+	workDir := t.TempDir()
+
+	fName := filepath.Join(workDir, "main.cgo1.go")
+	require.NoError(t, os.WriteFile(fName, []byte(`// Code generated by cmd/cgo; DO NOT EDIT.
+
+//line /src/app/main.go:1:1
+package main
+`), 0644))
+
+	src, ok := cgoSourceFile(fName, "")
+	require.True(t, ok)
+	assert.Equal(t, "/src/app/main.go", src)
+
+	t.Run("not a cgo file", func(t *testing.T) {
+		fName := filepath.Join(workDir, "_cgo_gotypes.go")
+		require.NoError(t, os.WriteFile(fName, []byte("// Code generated by cmd/cgo; DO NOT EDIT.\n\npackage main\n"), 0644))
+
+		_, ok := cgoSourceFile(fName, "")
+		assert.False(t, ok)
+	})
+
+	t.Run("no line directive", func(t *testing.T) {
+		fName := filepath.Join(workDir, "other.cgo1.go")
+		require.NoError(t, os.WriteFile(fName, []byte("package main\n\n//line /src/app/other.go:1:1\n"), 0644))
+
+		_, ok := cgoSourceFile(fName, "")
+		assert.False(t, ok)
+	})
+
+	t.Run("trimmed path", func(t *testing.T) {
+		fName := filepath.Join(workDir, "trimmed.cgo1.go")
+		require.NoError(t, os.WriteFile(fName, []byte("// Code generated by cmd/cgo; DO NOT EDIT.\n\n//line example.com/app/cmd/main.go:1:1\npackage main\n"), 0644))
+
+		src, ok := cgoSourceFile(fName, "/src/app/cmd=>example.com/app/cmd;"+workDir+"=>")
+		require.True(t, ok)
+		assert.Equal(t, filepath.FromSlash("/src/app/cmd/main.go"), src)
+
+		_, ok = cgoSourceFile(fName, workDir+"=>")
+		assert.False(t, ok)
+	})
+}
+
+func TestParseLineDirectiveFile(t *testing.T) {
+	examples := map[string]struct {
+		Value    string
+		Expected string
+		OK       bool
+	}{
+		"line and column": {"/src/app/main.go:1:1", "/src/app/main.go", true},
+		"line only":       {"/src/app/main.go:10", "/src/app/main.go", true},
+		"relative path":   {"main.go:1:1", "main.go", true},
+		"no file name":    {":14:14", "", false},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			fName, ok := parseLineDirectiveFile(example.Value)
+			assert.Equal(t, example.OK, ok)
+			assert.Equal(t, example.Expected, fName)
+		})
+	}
+}
+
+func TestUntrimPath(t *testing.T) {
+	trimPath := "/src/app=>example.com/app;/src/app/internal/lib=>example.com/app/internal/lib;/tmp/b001=>"
+
+	examples := map[string]struct {
+		FName    string
+		Expected string
+		OK       bool
+	}{
+		"package file":     {"example.com/app/main.go", "/src/app/main.go", true},
+		"nested package":   {"example.com/app/internal/lib/lib.go", "/src/app/internal/lib/lib.go", true},
+		"other package":    {"example.com/other/main.go", "", false},
+		"prefix of a name": {"example.com/application/main.go", "", false},
+		"removed prefix":   {"main.go", "", false},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			fName, ok := untrimPath(example.FName, trimPath)
+			assert.Equal(t, example.OK, ok)
+			assert.Equal(t, filepath.FromSlash(example.Expected), fName)
+		})
+	}
+}
+
+func TestInstrumentCompileFiles_Cgo(t *testing.T) {
+	srcDir := t.TempDir()
+	workDir := t.TempDir()
+	outDir := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, instanaGoFileName), []byte(`// Code generated by go-instana, DO NOT EDIT.
+
+package main
+
+import instana "github.com/instana/go-sensor"
+
+var __instanaSensor = instana.NewSensor("")
+`), 0644))
+
+	cgoFile := filepath.Join(workDir, "main.cgo1.go")
+	require.NoError(t, os.WriteFile(cgoFile, []byte(`// Code generated by cmd/cgo; DO NOT EDIT.
+
+//line `+filepath.Join(srcDir, "main.go")+`:1:1
+package main
+
+import _ "unsafe"
+
+import "net/http"
+
+func main() {
+	_ = ( /*line :10:6*/_Cfunc_answer /*line :10:13*/)()
+	http.HandleFunc("/", http.NotFound)
+}
+`), 0644))
+
+	typesFile := filepath.Join(workDir, "_cgo_gotypes.go")
+	require.NoError(t, os.WriteFile(typesFile, []byte("// Code generated by cmd/cgo; DO NOT EDIT.\n\npackage main\n\nfunc _Cfunc_answer() int32 { return 42 }\n"), 0644))
+
+	replacements, err := instrumentCompileFiles([]string{srcDir}, outDir, toolchainCompileArgs{Files: []string{
+		filepath.Join(srcDir, instanaGoFileName),
+		typesFile,
+		cgoFile,
+	}}, nil, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{cgoFile: filepath.Join(outDir, "main.cgo1.go")}, replacements)
+
+	data, err := os.ReadFile(filepath.Join(outDir, "main.cgo1.go"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `instana.TracingHandlerFunc(__instanaSensor, "/", http.NotFound)`)
+}
+
+func TestPackageCompileFiles_Cgo(t *testing.T) {
+	srcDir := t.TempDir()
+	workDir := t.TempDir()
+
+	cgoFile := filepath.Join(workDir, "main.cgo1.go")
+	require.NoError(t, os.WriteFile(cgoFile, []byte(`// Code generated by cmd/cgo; DO NOT EDIT.
+
+//line `+filepath.Join(srcDir, "main.go")+`:1:1
+package main
+
+import _ "unsafe"
+
+func main() {
+	_ = ( /*line :10:6*/_Cfunc_answer /*line :10:13*/)()
+}
+`), 0644))
+
+	typesFile := filepath.Join(workDir, "_cgo_gotypes.go")
+	require.NoError(t, os.WriteFile(typesFile, []byte("// Code generated by cmd/cgo; DO NOT EDIT.\n\npackage main\n\nfunc _Cfunc_answer() int32 { return 42 }\n"), 0644))
+
+	importFile := filepath.Join(workDir, "_cgo_import.go")
+	require.NoError(t, os.WriteFile(importFile, []byte("package main\n"), 0644))
+
+	t.Run("cgo package", func(t *testing.T) {
+		files := packageCompileFiles([]string{srcDir}, toolchainCompileArgs{Files: []string{typesFile, cgoFile, importFile}})
+		assert.Equal(t, []string{cgoFile, typesFile}, files)
+
+		// the generated files are expected to be type-checked along with the declarations of C.* references
+		fset := token.NewFileSet()
+
+		pkg, err := parseFiles(log.Logger, fset, workDir, files)
+		require.NoError(t, err)
+
+		_, errs := typeCheckPackage(log.Logger, fset, pkg, exportData(nil).Importer(log.Logger, fset))
+		assert.Empty(t, errs)
+	})
+
+	t.Run("trimmed paths", func(t *testing.T) {
+		trimmedFile := filepath.Join(workDir, "trimmed.cgo1.go")
+		require.NoError(t, os.WriteFile(trimmedFile, []byte("// Code generated by cmd/cgo; DO NOT EDIT.\n\n//line example.com/app/trimmed.go:1:1\npackage main\n"), 0644))
+
+		files := packageCompileFiles([]string{srcDir}, toolchainCompileArgs{
+			Package:  "example.com/app",
+			TrimPath: srcDir + "=>example.com/app;" + workDir + "=>",
+			Files:    []string{typesFile, trimmedFile},
+		})
+		assert.Equal(t, []string{trimmedFile, typesFile}, files)
+
+		// the source file can't be found without the rewrites
+		assert.Empty(t, packageCompileFiles([]string{srcDir}, toolchainCompileArgs{Files: []string{typesFile, trimmedFile}}))
+	})
+
+	t.Run("no package files", func(t *testing.T) {
+		assert.Empty(t, packageCompileFiles([]string{srcDir}, toolchainCompileArgs{Files: []string{typesFile, importFile}}))
+	})
+}
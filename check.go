@@ -0,0 +1,366 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// The problems found by the check command are reported with the exit code combining the following flags. The exit
+// code 1 is reserved for failures, so that it is never mistaken for a check result.
+const (
+	// checkMissingSensor is set if there are packages without the instanaGoFileName file
+	checkMissingSensor = 1 << (iota + 1)
+	// checkChangedFiles is set if there are files that would be changed by `go-instana add` or `go-instana instrument`
+	checkChangedFiles
+	// checkMissingModules is set if the instrumentation modules used by the packages are missing from go.mod
+	checkMissingModules
+	// checkVendoredModules is set if the vendored modules selected with -vendor are not instrumented or have been
+	// changed since the last instrumentation
+	checkVendoredModules
+)
+
+// checkCommand handles the `go-instana check` execution. It applies both `add` and `instrument` steps in memory
+// and reports the packages that are not fully instrumented without changing any files. It returns the exit code.
+func checkCommand() int {
+	log.Info().Msg(`start "check" command`)
+	defer log.Info().Msg(`finish "check" command`)
+
+	if err := checkModuleRoot("."); err != nil {
+		log.Fatal().Msg(err.Error())
+	}
+
+	report := newCheckReport()
+	requirements := newModuleRequirements()
+
+	for _, root := range projectRootDirs(".") {
+		paths, err := collectPackageDirs(root)
+		if err != nil {
+			log.Fatal().Msgf("can't collect paths error: %s", err.Error())
+		}
+
+		deps := loadExportData(root, "./...")
+
+		err = processPackages(paths, args.Jobs, func(logger zerolog.Logger, path string) error {
+			return checkPackage(logger, path, deps, requirements, report)
+		})
+		if err != nil {
+			log.Fatal().Msgf("check error: %s", err.Error())
+		}
+	}
+
+	if len(args.VendoredModules) > 0 {
+		if err := reportVendoredModules(".", args.VendoredModules, report); err != nil {
+			log.Fatal().Msgf("vendored modules check error: %s", err.Error())
+		}
+	}
+
+	if _, err := report.WriteTo(os.Stdout); err != nil {
+		log.Fatal().Msgf("failed to write report: %s", err)
+	}
+
+	return report.ExitCode()
+}
+
+// checkPackage applies both `add` and `instrument` steps to the package located in `path` in memory and adds
+// the problems found to the report
+func checkPackage(logger zerolog.Logger, path string, deps exportData, requirements *moduleRequirements, report *checkReport) error {
+	logger.Info().Msgf("processing path %s", path)
+
+	changes, err := overlayPackage(logger, path, deps)
+	if isNoGoError(err) {
+		logger.Info().Msgf("skip path %s : %s", path, err)
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to check package in %s: %w", path, err)
+	}
+
+	filePath := filepath.Join(path, instanaGoFileName)
+	for _, fName := range sortedKeys(changes) {
+		if fName == filePath && !fileExists(fName) {
+			report.AddMissingSensor(path)
+			continue
+		}
+
+		report.AddChanged(fName)
+	}
+
+	// the instrumentation modules are imported by the instanaGoFileName file, either the existing or the generated one
+	data, ok := changes[filePath]
+	if !ok {
+		if data, err = ioutil.ReadFile(filePath); err != nil {
+			return nil
+		}
+	}
+
+	f, err := parser.ParseFile(token.NewFileSet(), filePath, data, parser.ImportsOnly)
+	if err != nil {
+		logger.Warn().Msgf("failed to parse %s: %s", filePath, err)
+		return nil
+	}
+
+	required, err := requirements.Lookup(path)
+	if err != nil {
+		logger.Warn().Msgf("%s: failed to read module requirements: %s", path, err)
+		return nil
+	}
+
+	missing := make(map[string]bool)
+	for _, imp := range f.Imports {
+		impPath, err := strconv.Unquote(imp.Path.Value)
+		if err != nil || !isInstanaModule(impPath) {
+			continue
+		}
+
+		if modPath := instanaModulePath(impPath); !required[modPath] && !missing[modPath] {
+			missing[modPath] = true
+			report.AddMissingModule(modPath, path)
+		}
+	}
+
+	return nil
+}
+
+// reportVendoredModules adds the vendored modules, that have not been instrumented or have been changed since
+// the last instrumentation, to the report
+func reportVendoredModules(cwd string, modPaths []string, report *checkReport) error {
+	mainModule, err := mainModuleForDir(cwd)
+	if err != nil {
+		return err
+	}
+
+	vendorDir := filepath.Join(mainModule.Dir, "vendor")
+
+	modules, err := readVendoredModules(vendorDir)
+	if err != nil {
+		return err
+	}
+
+	for _, modPath := range modPaths {
+		if isInstanaModule(modPath) {
+			continue
+		}
+
+		mod, ok := findVendoredModule(modules, modPath)
+		if !ok {
+			log.Warn().Msgf("%s: module is not vendored, skipping", modPath)
+			continue
+		}
+
+		if mod.Instrumentation == nil {
+			report.AddVendoredModule(mod.Path, "has not been instrumented")
+			continue
+		}
+
+		changed, err := vendoredModuleChanged(vendorDir, mod)
+		if err != nil {
+			return err
+		}
+
+		if changed {
+			report.AddVendoredModule(mod.Path, "has been changed since the last instrumentation")
+		}
+	}
+
+	return nil
+}
+
+// instanaModulePath returns the path of the Instana module providing the package. Each instrumentation package is
+// a separate module, so it's never provided by the sensor module.
+func instanaModulePath(pkgPath string) string {
+	const instrumentationPrefix = SensorPackage + "/instrumentation/"
+
+	if !strings.HasPrefix(pkgPath, instrumentationPrefix) {
+		return SensorPackage
+	}
+
+	name, _, _ := strings.Cut(strings.TrimPrefix(pkgPath, instrumentationPrefix), "/")
+
+	return instrumentationPrefix + name
+}
+
+// checkReport collects the problems found by the check command. It is safe for concurrent use.
+type checkReport struct {
+	mu             sync.Mutex
+	missingSensor  []string
+	changed        []string
+	missingModules map[string][]string
+	vendored       []string
+}
+
+// newCheckReport returns an empty report
+func newCheckReport() *checkReport {
+	return &checkReport{
+		missingModules: make(map[string][]string),
+	}
+}
+
+// AddMissingSensor reports the package located in `path`, that has no instanaGoFileName file
+func (r *checkReport) AddMissingSensor(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.missingSensor = append(r.missingSensor, patchPath(path))
+}
+
+// AddChanged reports the file that would be changed or added
+func (r *checkReport) AddChanged(fName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.changed = append(r.changed, patchPath(fName))
+}
+
+// AddMissingModule reports the module missing from go.mod, that is used by the package located in `path`
+func (r *checkReport) AddMissingModule(modPath, path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.missingModules[modPath] = append(r.missingModules[modPath], patchPath(path))
+}
+
+// AddVendoredModule reports the vendored module with the reason
+func (r *checkReport) AddVendoredModule(modPath, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.vendored = append(r.vendored, modPath+" "+reason)
+}
+
+// ExitCode returns the combination of check flags for the problems found, or 0 if there are none
+func (r *checkReport) ExitCode() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var code int
+	if len(r.missingSensor) > 0 {
+		code |= checkMissingSensor
+	}
+
+	if len(r.changed) > 0 {
+		code |= checkChangedFiles
+	}
+
+	if len(r.missingModules) > 0 {
+		code |= checkMissingModules
+	}
+
+	if len(r.vendored) > 0 {
+		code |= checkVendoredModules
+	}
+
+	return code
+}
+
+// WriteTo writes the report grouped by the kind of problem, one line per package, file or module
+func (r *checkReport) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var lines []string
+	for _, path := range sortedStrings(r.missingSensor) {
+		lines = append(lines, fmt.Sprintf("missing %s: %s (run `go-instana add`)", instanaGoFileName, path))
+	}
+
+	for _, fName := range sortedStrings(r.changed) {
+		lines = append(lines, fmt.Sprintf("not instrumented: %s (run `go-instana add` and `go-instana instrument`)", fName))
+	}
+
+	for _, modPath := range sortedKeys(r.missingModules) {
+		lines = append(lines, fmt.Sprintf("missing module: %s required by %s (run `go get %s`)", modPath, strings.Join(sortedStrings(r.missingModules[modPath]), ", "), modPath))
+	}
+
+	for _, msg := range sortedStrings(r.vendored) {
+		lines = append(lines, "vendored module: "+msg)
+	}
+
+	if len(lines) == 0 {
+		return 0, nil
+	}
+
+	n, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+
+	return int64(n), err
+}
+
+// sortedStrings returns a sorted copy of the list
+func sortedStrings(list []string) []string {
+	res := append([]string(nil), list...)
+	sort.Strings(res)
+
+	return res
+}
+
+// moduleRequirements looks up the modules required by go.mod files. It is safe for concurrent use.
+type moduleRequirements struct {
+	mu       sync.Mutex
+	requires map[string]map[string]bool
+}
+
+// newModuleRequirements returns an empty moduleRequirements
+func newModuleRequirements() *moduleRequirements {
+	return &moduleRequirements{
+		requires: make(map[string]map[string]bool),
+	}
+}
+
+// Lookup returns the set of modules required by the module containing `dir`, including the module itself
+func (mr *moduleRequirements) Lookup(dir string) (map[string]bool, error) {
+	goMod, err := findGoMod(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if required, ok := mr.requires[goMod]; ok {
+		return required, nil
+	}
+
+	var mod goModJSON
+	if err := goJSON(filepath.Dir(goMod), &mod, "mod", "edit", "-json", goMod); err != nil {
+		return nil, err
+	}
+
+	required := map[string]bool{mod.Module.Path: true}
+	for _, req := range mod.Require {
+		required[req.Path] = true
+	}
+	mr.requires[goMod] = required
+
+	return required, nil
+}
+
+// findGoMod returns the go.mod file of the module containing `dir`
+func findGoMod(dir string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for d := absDir; ; d = filepath.Dir(d) {
+		if fName := filepath.Join(d, "go.mod"); fileExists(fName) {
+			return fName, nil
+		}
+
+		if filepath.Dir(d) == d {
+			return "", fmt.Errorf("%s does not belong to any module", dir)
+		}
+	}
+}
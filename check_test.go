@@ -0,0 +1,115 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPackage(t *testing.T) {
+	chdir(t, t.TempDir())
+
+	writeFile(t, "go.mod", "module app\n\ngo 1.18\n")
+	writeFile(t, "main.go", `package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func main() {
+	http.Handle("/", gin.Default())
+}
+`)
+
+	report := newCheckReport()
+	require.NoError(t, checkPackage(log.Logger, ".", nil, newModuleRequirements(), report))
+
+	assert.Equal(t, checkMissingSensor|checkChangedFiles|checkMissingModules, report.ExitCode())
+
+	buf := bytes.NewBuffer(nil)
+	_, err := report.WriteTo(buf)
+	require.NoError(t, err)
+
+	assert.Equal(t, "missing instana_go_dependency.go: . (run `go-instana add`)\n"+
+		"not instrumented: main.go (run `go-instana add` and `go-instana instrument`)\n"+
+		"missing module: github.com/instana/go-sensor required by . (run `go get github.com/instana/go-sensor`)\n"+
+		"missing module: github.com/instana/go-sensor/instrumentation/instagin required by . (run `go get github.com/instana/go-sensor/instrumentation/instagin`)\n",
+		buf.String())
+
+	assert.NoFileExists(t, instanaGoFileName, "no files are expected to be changed by check")
+}
+
+func TestCheckPackage_Instrumented(t *testing.T) {
+	chdir(t, t.TempDir())
+
+	writeFile(t, "go.mod", "module app\n\ngo 1.18\n\nrequire github.com/instana/go-sensor v1.41.0\n")
+	writeFile(t, "main.go", `package main
+
+import "net/http"
+
+func main() {
+	http.HandleFunc("/", http.NotFound)
+}
+`)
+
+	require.NoError(t, addPackage(log.Logger, ".", nil))
+	require.NoError(t, instrumentCode(log.Logger, ".", nil, nil, nil))
+
+	report := newCheckReport()
+	require.NoError(t, checkPackage(log.Logger, ".", nil, newModuleRequirements(), report))
+
+	assert.Equal(t, 0, report.ExitCode())
+
+	buf := bytes.NewBuffer(nil)
+	_, err := report.WriteTo(buf)
+	require.NoError(t, err)
+	assert.Empty(t, buf.String())
+}
+
+func TestCheckPackage_NotInstrumented(t *testing.T) {
+	chdir(t, t.TempDir())
+
+	writeFile(t, "go.mod", "module app\n\ngo 1.18\n\nrequire github.com/instana/go-sensor v1.41.0\n")
+	writeFile(t, "main.go", `package main
+
+import "net/http"
+
+func main() {
+	http.HandleFunc("/", http.NotFound)
+}
+`)
+
+	require.NoError(t, addPackage(log.Logger, ".", nil))
+
+	report := newCheckReport()
+	require.NoError(t, checkPackage(log.Logger, ".", nil, newModuleRequirements(), report))
+
+	assert.Equal(t, checkChangedFiles, report.ExitCode())
+
+	buf := bytes.NewBuffer(nil)
+	_, err := report.WriteTo(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "not instrumented: main.go (run `go-instana add` and `go-instana instrument`)\n", buf.String())
+}
+
+func TestInstanaModulePath(t *testing.T) {
+	examples := map[string]string{
+		"github.com/instana/go-sensor":                                     "github.com/instana/go-sensor",
+		"github.com/instana/go-sensor/autoprofile":                         "github.com/instana/go-sensor",
+		"github.com/instana/go-sensor/instrumentation/instagin":            "github.com/instana/go-sensor/instrumentation/instagin",
+		"github.com/instana/go-sensor/instrumentation/cloud.google.com/go": "github.com/instana/go-sensor/instrumentation/cloud.google.com",
+	}
+
+	for pkgPath, expected := range examples {
+		t.Run(pkgPath, func(t *testing.T) {
+			assert.Equal(t, expected, instanaModulePath(pkgPath))
+		})
+	}
+}
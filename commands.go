@@ -7,10 +7,10 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/instana/go-instana/internal/registry"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"go/ast"
 	"go/build"
-	"go/parser"
 	"go/token"
 	"io/ioutil"
 	"os"
@@ -21,93 +21,191 @@ import (
 
 // addCommand handles the `go-instana add` execution. It looks up the packages that match given set of
 // patterns and adds an instance of *instana.Sensor to those that do not contain one yet. It skips packages
-// that already have a sensor instance in the global scope.
+// that already have a sensor instance in the global scope. If no patterns provided, the packages of all
+// workspace modules and modules replaced with a local directory are processed as well.
 func addCommand(patterns []string) error {
 	log.Info().Msg(`start "add" command`)
 	defer log.Info().Msg(`finish "add" command`)
+	roots := []string{"."}
 	if len(patterns) == 0 {
 		patterns = append(patterns, "./...")
+		// all packages of the workspace and locally replaced modules are processed as well
+		roots = projectRootDirs(".")
 	}
 
-	paths, err := collectSourcePaths(os.DirFS("./"), patterns)
+	var paths []string
+	for _, root := range roots {
+		rootPaths, err := collectSourcePaths(os.DirFS(root), patterns)
+		if err != nil {
+			return fmt.Errorf("failed to lookup source code directories: %w", err)
+		}
+
+		for _, path := range rootPaths {
+			paths = append(paths, filepath.Join(root, path))
+		}
+	}
+
+	patches := dryRunPatchSet()
+
+	err := processPackages(paths, args.Jobs, func(logger zerolog.Logger, path string) error {
+		return addPackage(logger, path, patches)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to lookup source code directories: %w", err)
+		return err
 	}
 
-	for _, path := range paths {
-		log.Info().Msgf("processing path %s", path)
+	// vendored code is processed only for explicitly selected modules
+	if len(args.VendoredModules) > 0 {
+		if patches != nil {
+			log.Warn().Msg("vendored modules are not processed in dry-run mode")
+		} else if err := addVendoredModules(".", args.VendoredModules); err != nil {
+			return fmt.Errorf("failed to process vendored modules: %w", err)
+		}
+	}
 
-		filePath := filepath.Join(path, instanaGoFileName)
+	if err := writePatchSet(patches); err != nil {
+		return err
+	}
 
-		if fileExists(filePath) {
-			data, err := ioutil.ReadFile(filePath)
-			if err != nil {
-				log.Error().Msgf("reading %s error: %s", instanaGoFileName, err.Error())
-			}
+	return writeResults()
+}
 
-			if err == nil && isGeneratedByGoInstana(bytes.NewBuffer(data)) {
-				if err := os.Remove(filePath); err != nil {
-					log.Error().Msgf("remove %s error: %s", instanaGoFileName, err.Error())
-				} else {
-					log.Debug().Msgf("removed %s", instanaGoFileName)
-				}
-			}
-		}
+// addPackage adds an instance of *instana.Sensor and the instrumentation imports to the package located in `path`.
+// If `patches` is not nil, the changes are added to it instead of being written.
+func addPackage(logger zerolog.Logger, path string, patches *patchSet) error {
+	logger.Info().Msgf("processing path %s", path)
 
-		// find package located at `path`
-		pkg, err := findPackageInPath(path, token.NewFileSet())
+	filePath := filepath.Join(path, instanaGoFileName)
+
+	var (
+		generated  bool
+		oldContent []byte
+	)
+	if fileExists(filePath) {
+		data, err := ioutil.ReadFile(filePath)
 		if err != nil {
-			return fmt.Errorf("can find pkg in path %w", err)
+			logger.Error().Msgf("reading %s error: %s", instanaGoFileName, err.Error())
 		}
 
-		// check if files in the package have imports of the dependencies that can be instrumented
-		instrumentationPackagesToImport := applicableInstrumentationPackages(pkg)
+		generated = err == nil && isGeneratedByGoInstana(bytes.NewBuffer(data))
+		oldContent = data
+	}
 
-		instanaGoFD, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_SYNC, 0666)
-		if err != nil {
-			return fmt.Errorf("failed to create/open file %s: %w", filePath, err)
-		}
-		log.Info().Msgf("created %s", filePath)
+	// find package located at `path`, a previously generated file is replaced, so it's not a part of the package
+	files, err := packageFiles(path)
+	if err == nil && generated {
+		files = withoutFile(files, filePath)
+	}
 
-		sensorNotFound := lookupInstanaSensorInPackage(pkg) == ""
-		notEmpty, err := writeInstanaGoFile(instanaGoFD, pkg.Name, sensorNotFound, instrumentationPackagesToImport)
-		if err != nil {
-			os.Remove(filePath)
-			return err
+	var pkg *ast.Package
+	if err == nil {
+		pkg, err = parseFiles(logger, token.NewFileSet(), path, files)
+	}
+
+	if isNoGoError(err) {
+		logger.Info().Msgf("skip path %s : %s", path, err)
+
+		if generated {
+			results.Add(path, packageResult{Package: patchPath(path), File: patchPath(filePath), Status: "removed", Imports: []string{}})
 		}
 
-		if err := fixImports(filePath); err != nil {
-			return err
+		return updateInstanaGoFile(logger, filePath, generated, nil, patches)
+	}
+
+	if err != nil {
+		return fmt.Errorf("can find pkg in path %w", err)
+	}
+
+	content, err := instanaGoFileContent(filePath, pkg)
+	if err != nil {
+		return err
+	}
+
+	if err := updateInstanaGoFile(logger, filePath, generated, content, patches); err != nil {
+		return err
+	}
+
+	results.Add(path, newPackageResult(path, filePath, pkg, generated, oldContent, content))
+
+	return nil
+}
+
+// newPackageResult returns the description of changes made by `go-instana add` to the package located in `path`,
+// where `oldContent` and `content` are the code of the `instanaGoFileName` file before and after the change
+func newPackageResult(path, filePath string, pkg *ast.Package, generated bool, oldContent, content []byte) packageResult {
+	res := packageResult{
+		Package: patchPath(path),
+		File:    patchPath(filePath),
+		Imports: []string{},
+	}
+
+	switch {
+	case content == nil && generated:
+		res.Status = "removed"
+	case content == nil, generated && bytes.Equal(oldContent, content):
+		res.Status = "unchanged"
+	case generated:
+		res.Status = "updated"
+	default:
+		res.Status = "created"
+	}
+
+	if content != nil {
+		res.SensorAdded = lookupInstanaSensorInPackage(pkg) == ""
+		res.Imports = append(res.Imports, applicableInstrumentationPackages(pkg)...)
+	}
+
+	return res
+}
+
+// updateInstanaGoFile replaces the `instanaGoFileName` file with provided content, where nil content means that
+// the file is not needed. The file is only removed if it has been generated by go-instana. If `patches` is not nil,
+// the change is added to it instead of being written.
+func updateInstanaGoFile(logger zerolog.Logger, filePath string, generated bool, content []byte, patches *patchSet) error {
+	if patches != nil {
+		if content == nil && !generated {
+			return nil
 		}
 
-		if notEmpty {
-			instanaGoFD.Close()
+		return patches.Add(filePath, content)
+	}
+
+	if generated {
+		if err := os.Remove(filePath); err != nil {
+			logger.Error().Msgf("remove %s error: %s", instanaGoFileName, err.Error())
 		} else {
-			os.Remove(filePath)
+			logger.Debug().Msgf("removed %s", instanaGoFileName)
 		}
 	}
 
-	return nil
-}
+	if content == nil {
+		return nil
+	}
 
-// findPackageInPath returns single defined non-test package in the `path`, error in any other case
-func findPackageInPath(path string, fset *token.FileSet) (*ast.Package, error) {
-	pkgs, err := parser.ParseDir(fset, path, func(fInfo os.FileInfo) bool {
-		return !strings.HasSuffix(fInfo.Name(), "_test.go")
-	}, parser.ParseComments)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse source files in %q: %w", path, err)
+	if err := ioutil.WriteFile(filePath, content, 0666); err != nil {
+		return fmt.Errorf("failed to create file %s: %w", filePath, err)
 	}
+	logger.Info().Msgf("created %s", filePath)
+
+	return nil
+}
 
-	if len(pkgs) == 1 {
-		// get single element from map
-		for _, pkg := range pkgs {
-			log.Debug().Msgf("found package %s with %d file(s)", pkg.Name, len(pkg.Files))
-			return pkg, nil
+// withoutFile returns the list of files without `fName`
+func withoutFile(files []string, fName string) []string {
+	var res []string
+	for _, f := range files {
+		if f != fName {
+			res = append(res, f)
 		}
 	}
 
-	return nil, multiplePackageError(path, pkgs)
+	return res
+}
+
+// findPackageInPath returns single defined non-test package in the `path` matching current build context,
+// error in any other case
+func findPackageInPath(logger zerolog.Logger, path string, fset *token.FileSet) (*ast.Package, error) {
+	return loadPackage(logger, fset, path)
 }
 
 func multiplePackageError(path string, pkgs map[string]*ast.Package) error {
@@ -125,6 +223,27 @@ func multiplePackageError(path string, pkgs map[string]*ast.Package) error {
 	return err
 }
 
+// instanaGoFileContent returns the code of the `instanaGoFileName` file for the package, that adds an instance
+// of *instana.Sensor if there is none and imports the instrumentation packages applicable to the package.
+// It returns nil if there is nothing to add.
+func instanaGoFileContent(filePath string, pkg *ast.Package) ([]byte, error) {
+	// check if files in the package have imports of the dependencies that can be instrumented
+	instrumentationPackagesToImport := applicableInstrumentationPackages(pkg)
+	sensorNotFound := lookupInstanaSensorInPackage(pkg) == ""
+
+	buf := bytes.NewBuffer(nil)
+	notEmpty, err := writeInstanaGoFile(buf, pkg.Name, sensorNotFound, instrumentationPackagesToImport)
+	if err != nil {
+		return nil, err
+	}
+
+	if !notEmpty {
+		return nil, nil
+	}
+
+	return formatSource(filePath, buf.Bytes())
+}
+
 // applicableInstrumentationPackages checks if package has imports that can be instrumented and returns necessary instrumentation imports
 func applicableInstrumentationPackages(pkg *ast.Package) []string {
 	pkgs := map[string]struct{}{}
@@ -143,6 +262,7 @@ func applicableInstrumentationPackages(pkg *ast.Package) []string {
 	for k := range pkgs {
 		uniqueImports = append(uniqueImports, k)
 	}
+	sort.Strings(uniqueImports)
 
 	return uniqueImports
 }
@@ -152,32 +272,73 @@ func instrumentCommand() {
 	log.Info().Msg(`start "instrument" command`)
 	defer log.Info().Msg(`finish "instrument" command`)
 
-	cd, err := os.Getwd()
+	if err := checkModuleRoot("."); err != nil {
+		log.Fatal().Msg(err.Error())
+	}
+
+	patches := dryRunPatchSet()
+
+	for _, root := range projectRootDirs(".") {
+		paths, err := collectPackageDirs(root)
+		if err != nil {
+			log.Fatal().Msgf("can't collect paths error: %s", err.Error())
+		}
+
+		deps := loadExportData(root, "./...")
+		cache := openInstrumentationCache()
+
+		err = processPackages(paths, args.Jobs, func(logger zerolog.Logger, path string) error {
+			return instrumentCode(logger, path, deps, cache, patches)
+		})
+		if err != nil {
+			log.Fatal().Msgf("instrumentation error: %s", err.Error())
+		}
+	}
+
+	if len(args.VendoredModules) > 0 {
+		if patches != nil {
+			log.Warn().Msg("vendored modules are not processed in dry-run mode")
+		} else if err := instrumentVendoredModules(".", args.VendoredModules); err != nil {
+			log.Fatal().Msgf("vendored modules instrumentation error: %s", err.Error())
+		}
+	}
+
+	if err := writePatchSet(patches); err != nil {
+		log.Fatal().Msgf("failed to write patch: %s", err)
+	}
+
+	if err := writeResults(); err != nil {
+		log.Fatal().Msgf("failed to write results: %s", err)
+	}
+}
+
+// checkModuleRoot returns an error if `dir` is neither a module nor a workspace root
+func checkModuleRoot(dir string) error {
+	cd, err := filepath.Abs(dir)
 	if err != nil {
-		log.Fatal().Msgf("getwd error: %s", err.Error())
+		return fmt.Errorf("getwd error: %w", err)
 	}
 
 	log.Debug().Msgf("current directory: %s", cd)
 	files, err := ioutil.ReadDir(cd)
 	if err != nil {
-		log.Fatal().Msgf("read dir error: %s", err.Error())
+		return fmt.Errorf("read dir error: %w", err)
 	}
 
-	isModuleRoot := false
 	for _, f := range files {
-		if f.Name() == "go.mod" {
-			isModuleRoot = true
-			break
+		if f.Name() == "go.mod" || f.Name() == "go.work" {
+			return nil
 		}
 	}
 
-	if !isModuleRoot {
-		log.Fatal().Msgf("%s is not a module root", cd)
-		return
-	}
+	return fmt.Errorf("%s is not a module root", cd)
+}
 
-	uniqPaths := make(map[string]struct{})
-	err = filepath.Walk(".",
+// collectPackageDirs returns the sorted list of all directories under the `root` except the hidden and vendored ones.
+// Vendored modules are processed separately, if selected with the -vendor flag.
+func collectPackageDirs(root string) ([]string, error) {
+	var paths []string
+	err := filepath.Walk(root,
 		func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
@@ -187,37 +348,38 @@ func instrumentCommand() {
 				return nil
 			}
 
-			if path != "." && strings.HasPrefix(path, ".") {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+
+			if rel != "." && strings.HasPrefix(rel, ".") {
 				return nil
 			}
 
-			if strings.HasPrefix(path, "vendor") {
+			if strings.HasPrefix(rel, "vendor") {
 				return nil
 			}
 
-			uniqPaths[path] = struct{}{}
+			paths = append(paths, path)
 
 			return nil
 		})
 
-	if err != nil {
-		log.Fatal().Msgf("can't collect paths error: %s", err.Error())
-	}
-
-	for p := range uniqPaths {
-		if err := instrumentCode(p); err != nil {
-			log.Fatal().Msgf("instrumentation error: %s", err.Error())
-		}
-	}
+	return paths, err
 }
 
 // listCommand handles the `go-instana list` execution
 func listCommand() {
-	names := registry.Default.ListNames()
-	sort.Strings(names)
+	for _, name := range registry.Default.ListNames() {
+		if !args.JSON {
+			fmt.Println(name)
+			continue
+		}
 
-	for _, name := range names {
-		fmt.Println(name)
+		if err := writeJSON(os.Stdout, newRecipeResult(name, registry.Default.InstrumentationRecipe(name))); err != nil {
+			log.Fatal().Msgf("failed to write results: %s", err)
+		}
 	}
 }
 
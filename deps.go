@@ -0,0 +1,408 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"html/template"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// depsSensorPackageDir is the name of the package generated inside the main module to provide the sensor
+// instance to instrumented dependencies
+const depsSensorPackageDir = "goinstanasensor"
+
+var depsSensorPackageTmpl = template.Must(template.New("sensor.go").Parse(`// Code generated by go-instana, DO NOT EDIT.
+
+// Package {{ .Package }} provides the Instana sensor shared by instrumented dependencies
+package {{ .Package }}
+
+import instana "{{ .InstanaPackage }}"
+
+// Sensor is used by instrumented dependencies to trace their calls
+var Sensor = instana.NewSensor("")
+`))
+
+var depsInstanaGoTmpl = template.Must(template.New(instanaGoFileName).Parse(`// Code generated by go-instana, DO NOT EDIT.
+
+package {{ .Package }}
+
+import (
+	{{ .SensorPackageName }} "{{ .SensorPackage }}"
+{{ range .InstrumentationPackages }}
+	_ "{{ . }}"{{ end }}
+)
+
+var {{ .SensorName }} = {{ .SensorPackageName }}.Sensor
+`))
+
+// goListModule is the subset of `go list -m -json` output used by go-instana
+type goListModule struct {
+	Path    string
+	Version string
+	Dir     string
+	Main    bool
+}
+
+// dependenciesBuild describes the changes to the go command invocation required to use instrumented dependencies
+type dependenciesBuild struct {
+	// Overlay adds the package providing the sensor to instrumented dependencies
+	Overlay goOverlay
+	// ModFile is the go.mod file replacing instrumented dependencies with their copies
+	ModFile string
+}
+
+// instrumentDependencies copies dependency modules from the allowlist to `outDir` and applies instrumentation
+// recipes to their packages. The instrumented dependencies use the sensor provided by a package generated inside the
+// main module. Since the go command does not allow to overlay files inside the module cache, the copies are used
+// via `replace` directives of a temporary go.mod file, so that files in the module cache are never modified.
+func instrumentDependencies(cwd, outDir string, allowlist []string) (dependenciesBuild, error) {
+	build := dependenciesBuild{
+		Overlay: goOverlay{Replace: make(map[string]string)},
+	}
+
+	var env struct {
+		GOMOD  string
+		GOWORK string
+	}
+
+	if err := goJSON(cwd, &env, "env", "-json", "GOMOD", "GOWORK"); err != nil {
+		return build, err
+	}
+
+	if env.GOWORK != "" && env.GOWORK != "off" {
+		log.Warn().Msg("instrumentation of dependencies is not supported in workspace mode, skipping")
+		return build, nil
+	}
+
+	mainModule, err := mainModuleForDir(cwd)
+	if err != nil {
+		return build, err
+	}
+
+	sensorPkgPath := mainModule.Path + "/" + depsSensorPackageDir
+
+	var replaces []string
+	for _, modPath := range allowlist {
+		if isInstanaModule(modPath) {
+			log.Warn().Msgf("%s: instrumentation of Instana modules is not supported, skipping", modPath)
+			continue
+		}
+
+		var mod goListModule
+		if err := goJSON(cwd, &mod, "list", "-m", "-json", modPath); err != nil {
+			log.Warn().Msgf("%s: failed to find dependency module, skipping: %s", modPath, err)
+			continue
+		}
+
+		if mod.Main || mod.Dir == "" {
+			log.Warn().Msgf("%s: module is not a downloaded dependency, skipping", modPath)
+			continue
+		}
+
+		log.Info().Msgf("processing dependency %s@%s", mod.Path, mod.Version)
+
+		modOutDir := filepath.Join(outDir, "deps", strings.ReplaceAll(mod.Path, "/", "_")+"@"+mod.Version)
+
+		instrumented, err := instrumentDependencyModule(mod, modOutDir, sensorPkgPath, loadExportData(cwd, mod.Path+"/..."))
+		if err != nil {
+			return build, err
+		}
+
+		if instrumented {
+			replaces = append(replaces, "-replace="+mod.Path+"="+modOutDir)
+		}
+	}
+
+	if len(replaces) == 0 {
+		return build, nil
+	}
+
+	modFile, err := writeDependenciesModFile(cwd, env.GOMOD, filepath.Join(outDir, "deps"), replaces)
+	if err != nil {
+		return build, err
+	}
+	build.ModFile = modFile
+
+	// add the package providing the sensor for instrumented dependencies
+	content, err := depsSensorPackageContent()
+	if err != nil {
+		return build, err
+	}
+
+	dst := filepath.Join(outDir, "deps", depsSensorPackageDir, "sensor.go")
+	if err := writeNodeToFile(log.Logger, dst, content); err != nil {
+		return build, fmt.Errorf("failed to write %s: %w", dst, err)
+	}
+
+	build.Overlay.Replace[filepath.Join(mainModule.Dir, depsSensorPackageDir, "sensor.go")] = dst
+
+	return build, nil
+}
+
+// instrumentDependencyModule applies instrumentation recipes to the packages of the dependency module. If any
+// changes were made, the module is copied to `outDir` along with the instrumented files.
+func instrumentDependencyModule(mod goListModule, outDir, sensorPkgPath string, deps exportData) (bool, error) {
+	paths, err := moduleSourcePaths(mod.Dir)
+	if err != nil {
+		return false, fmt.Errorf("failed to lookup source code directories of %s: %w", mod.Path, err)
+	}
+
+	changes := make(map[string][]byte)
+	for _, path := range paths {
+		pkgChanges, err := instrumentDependencyPackage(filepath.Join(mod.Dir, path), sensorPkgPath, deps)
+		if err != nil {
+			log.Warn().Msgf("%s: skipping package: %s", filepath.Join(mod.Path, path), err)
+			continue
+		}
+
+		for fName, data := range pkgChanges {
+			changes[fName] = data
+		}
+	}
+
+	if len(changes) == 0 {
+		return false, nil
+	}
+
+	if err := copyDir(mod.Dir, outDir); err != nil {
+		return false, fmt.Errorf("failed to copy %s: %w", mod.Path, err)
+	}
+
+	for fName, data := range changes {
+		rel, err := filepath.Rel(mod.Dir, fName)
+		if err != nil {
+			return false, fmt.Errorf("failed to find relative path of %s: %w", fName, err)
+		}
+
+		dst := filepath.Join(outDir, rel)
+		if err := writeInstrumentedCopy(log.Logger, dst, fName, data); err != nil {
+			return false, fmt.Errorf("failed to write %s: %w", dst, err)
+		}
+	}
+
+	return true, nil
+}
+
+// writeDependenciesModFile writes a copy of the main module go.mod and go.sum files to `dir` and applies provided
+// `go mod edit` flags to it. It returns the name of the written go.mod file.
+func writeDependenciesModFile(cwd, goMod, dir string, editFlags []string) (string, error) {
+	modFile := filepath.Join(dir, "go.mod")
+
+	for src, dst := range map[string]string{
+		goMod: modFile,
+		strings.TrimSuffix(goMod, ".mod") + ".sum": strings.TrimSuffix(modFile, ".mod") + ".sum",
+	} {
+		data, err := ioutil.ReadFile(src)
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", src, err)
+		}
+
+		if err := writeNodeToFile(log.Logger, dst, data); err != nil {
+			return "", fmt.Errorf("failed to write %s: %w", dst, err)
+		}
+	}
+
+	cmd := exec.Command("go", append(append([]string{"mod", "edit"}, editFlags...), modFile)...)
+	cmd.Dir = cwd
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to update %s: %w: %s", modFile, err, strings.TrimSpace(string(out)))
+	}
+
+	return modFile, nil
+}
+
+// copyDir copies the content of the `src` directory to `dst`. The copied files are made writable, since
+// the module cache is read-only.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, rel)
+
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		return ioutil.WriteFile(target, data, 0644)
+	})
+}
+
+// instrumentDependencyPackage applies instrumentation recipes to the dependency package located in `path` and returns
+// the content of changed files along with the file providing the sensor instance. It returns nil if no changes were made.
+func instrumentDependencyPackage(path, sensorPkgPath string, deps exportData) (map[string][]byte, error) {
+	fset := token.NewFileSet()
+
+	pkg, err := loadPackage(log.Logger, fset, path)
+	if isNoGoError(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	filePath := filepath.Join(path, instanaGoFileName)
+
+	content, err := depsInstanaGoFileContent(filePath, pkg, sensorPkgPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if content == nil {
+		return nil, nil
+	}
+
+	f, err := parser.ParseFile(fset, filePath, content, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated %s: %w", filePath, err)
+	}
+	pkg.Files[filePath] = f
+
+	changes, err := instrumentPackageWithSensor(log.Logger, fset, pkg, deps, "__instanaSensor", instanaPackageImports(fset, pkg.Files))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	changes[filePath] = content
+
+	return changes, nil
+}
+
+// depsInstanaGoFileContent returns the code of the `instanaGoFileName` file for the dependency package, that
+// provides the sensor from the generated package and imports the instrumentation packages applicable to the
+// package. It returns nil if there is nothing to instrument.
+func depsInstanaGoFileContent(filePath string, pkg *ast.Package, sensorPkgPath string) ([]byte, error) {
+	instrumentationPackages := applicableInstrumentationPackages(pkg)
+	if len(instrumentationPackages) == 0 {
+		return nil, nil
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := depsInstanaGoTmpl.Execute(buf, struct {
+		instanaGoTmplArgs
+		SensorPackage     string
+		SensorPackageName string
+	}{
+		instanaGoTmplArgs: instanaGoTmplArgs{
+			Package:                 pkg.Name,
+			SensorName:              "__instanaSensor",
+			InstrumentationPackages: instrumentationPackages,
+		},
+		SensorPackage:     sensorPkgPath,
+		SensorPackageName: "__instana" + depsSensorPackageDir,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to generate %s: %w", instanaGoFileName, err)
+	}
+
+	return formatSource(filePath, buf.Bytes())
+}
+
+// depsSensorPackageContent returns the code of the package providing the sensor to instrumented dependencies
+func depsSensorPackageContent() ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := depsSensorPackageTmpl.Execute(buf, instanaGoTmplArgs{
+		Package:        depsSensorPackageDir,
+		InstanaPackage: SensorPackage,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to generate sensor package: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// mainModuleForDir returns the main module containing `dir`
+func mainModuleForDir(dir string) (goListModule, error) {
+	modules, err := localModules(dir)
+	if err != nil {
+		return goListModule{}, err
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return goListModule{}, err
+	}
+
+	var found localModule
+	for _, mod := range modules {
+		if isInsideDir(mod.Dir, absDir) && len(mod.Dir) > len(found.Dir) {
+			found = mod
+		}
+	}
+
+	if found.Dir == "" {
+		return goListModule{}, fmt.Errorf("%s does not belong to any module", dir)
+	}
+
+	return goListModule{Path: found.Path, Dir: found.Dir, Main: true}, nil
+}
+
+// moduleSourcePaths returns the list of package directories inside the module located in `modDir`, relative to it.
+// Similar to the go command, it skips the `testdata` and `vendor` directories, the ones starting with `.` or `_`
+// and nested modules.
+func moduleSourcePaths(modDir string) ([]string, error) {
+	var paths []string
+
+	err := fs.WalkDir(os.DirFS(modDir), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() {
+			return nil
+		}
+
+		if path != "." {
+			name := d.Name()
+			if name == "testdata" || name == "vendor" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				return fs.SkipDir
+			}
+
+			if fileExists(filepath.Join(modDir, path, "go.mod")) {
+				return fs.SkipDir
+			}
+		}
+
+		paths = append(paths, path)
+
+		return nil
+	})
+
+	return paths, err
+}
@@ -0,0 +1,101 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModuleSourcePaths(t *testing.T) {
+	modDir := t.TempDir()
+
+	for _, fName := range []string{
+		"go.mod",
+		"dep.go",
+		"client/client.go",
+		"internal/util/util.go",
+		"testdata/data.go",
+		"vendor/example.com/lib/lib.go",
+		".hidden/hidden.go",
+		"_examples/example.go",
+		"nested/go.mod",
+		"nested/nested.go",
+	} {
+		writeFile(t, filepath.Join(modDir, fName), "package x\n")
+	}
+
+	paths, err := moduleSourcePaths(modDir)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{".", "client", "internal", "internal/util"}, paths)
+}
+
+func TestCopyDir(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := filepath.Join(t.TempDir(), "copy")
+
+	writeFile(t, filepath.Join(srcDir, "go.mod"), "module example.com/dep\n")
+	writeFile(t, filepath.Join(srcDir, "pkg", "pkg.go"), "package pkg\n")
+	// module cache files are read-only
+	require.NoError(t, os.Chmod(filepath.Join(srcDir, "pkg", "pkg.go"), 0444))
+
+	require.NoError(t, copyDir(srcDir, dstDir))
+
+	data, err := os.ReadFile(filepath.Join(dstDir, "pkg", "pkg.go"))
+	require.NoError(t, err)
+	assert.Equal(t, "package pkg\n", string(data))
+
+	fi, err := os.Stat(filepath.Join(dstDir, "pkg", "pkg.go"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), fi.Mode().Perm())
+}
+
+func TestInstrumentDependencyPackage(t *testing.T) {
+	pkgDir := t.TempDir()
+
+	originalCode := `package dep
+
+import "net/http"
+
+func Get(url string) (*http.Response, error) {
+	client := &http.Client{}
+
+	return client.Get(url)
+}
+`
+	writeFile(t, filepath.Join(pkgDir, "dep.go"), originalCode)
+
+	changes, err := instrumentDependencyPackage(pkgDir, "example.com/app/goinstanasensor", nil)
+	require.NoError(t, err)
+
+	require.Contains(t, changes, filepath.Join(pkgDir, "dep.go"))
+	assert.Contains(t, string(changes[filepath.Join(pkgDir, "dep.go")]), "instana.RoundTripper(__instanaSensor, nil)")
+
+	require.Contains(t, changes, filepath.Join(pkgDir, instanaGoFileName))
+	generated := string(changes[filepath.Join(pkgDir, instanaGoFileName)])
+	assert.Contains(t, generated, `"example.com/app/goinstanasensor"`)
+	assert.Contains(t, generated, "var __instanaSensor = __instanagoinstanasensor.Sensor")
+	assert.True(t, isGeneratedByGoInstana(strings.NewReader(generated)))
+
+	// sources are never modified
+	data, err := os.ReadFile(filepath.Join(pkgDir, "dep.go"))
+	require.NoError(t, err)
+	assert.Equal(t, originalCode, string(data))
+	assert.NoFileExists(t, filepath.Join(pkgDir, instanaGoFileName))
+}
+
+func TestInstrumentDependencyPackage_NothingToInstrument(t *testing.T) {
+	pkgDir := t.TempDir()
+
+	writeFile(t, filepath.Join(pkgDir, "dep.go"), "package dep\n\nfunc Sum(a, b int) int { return a + b }\n")
+
+	changes, err := instrumentDependencyPackage(pkgDir, "example.com/app/goinstanasensor", nil)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
@@ -0,0 +1,109 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"strconv"
+
+	"github.com/instana/go-instana/internal/recipes"
+	"github.com/rs/zerolog"
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// formatSource formats the source code of the file `fName` and sorts its imports. Unlike goimports, it never
+// looks up missing imports in GOPATH or the module cache, so the result only depends on the code itself.
+func formatSource(fName string, src []byte) ([]byte, error) {
+	data, err := format.Source(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to format %s: %w", fName, err)
+	}
+
+	return data, nil
+}
+
+// usedImports returns the set of import paths of the packages referenced by the file. Blank and dot imports
+// are never reported as used.
+func usedImports(f *ast.File, info *types.Info) map[string]bool {
+	// the names of packages referenced in qualified identifiers
+	qualifiers := make(map[string]bool)
+	ast.Inspect(f, func(node ast.Node) bool {
+		sel, ok := node.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+
+		// identifiers resolved by the parser refer to local declarations shadowing the package name
+		if ident, ok := sel.X.(*ast.Ident); ok && ident.Obj == nil {
+			qualifiers[ident.Name] = true
+		}
+
+		return true
+	})
+
+	used := make(map[string]bool)
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+
+		if qualifiers[importName(spec, path, info)] {
+			used[path] = true
+		}
+	}
+
+	return used
+}
+
+// removeUnusedImports removes the imports of packages that were referenced in `usedBefore`, but are no longer
+// used by the file, i.e. the imports made unused by instrumentation recipes. The imports that were unused before
+// are left intact, so that the code is never changed beyond what the recipes did.
+func removeUnusedImports(logger zerolog.Logger, fset *token.FileSet, f *ast.File, info *types.Info, usedBefore map[string]bool) {
+	usedAfter := usedImports(f, info)
+
+	var unused []*ast.ImportSpec
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+
+		if usedBefore[path] && !usedAfter[path] {
+			unused = append(unused, spec)
+		}
+	}
+
+	for _, spec := range unused {
+		path, _ := strconv.Unquote(spec.Path.Value)
+
+		var name string
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+
+		if astutil.DeleteNamedImport(fset, f, name, path) {
+			logger.Debug().Msgf("remove unused import: %s", path)
+		}
+	}
+}
+
+// importName returns the name used to reference the imported package in the file. The name of a package imported
+// without an alias is taken from the type information if available, and guessed from its path otherwise.
+func importName(spec *ast.ImportSpec, path string, info *types.Info) string {
+	if spec.Name != nil {
+		return spec.Name.Name
+	}
+
+	if info != nil {
+		if pkgName, ok := info.Implicits[spec].(*types.PkgName); ok {
+			return pkgName.Name()
+		}
+	}
+
+	return recipes.ExtractLocalImportName(path)
+}
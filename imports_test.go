@@ -0,0 +1,123 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsedImports(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "main.go", `package main
+
+import (
+	"fmt"
+	"net/http"
+	str "strings"
+	_ "embed"
+	. "math"
+	"github.com/go-redis/redis/v8"
+	"os"
+)
+
+func main() {
+	fmt.Println(str.ToUpper("a"), Pi)
+	redis.NewClient(nil)
+
+	os := struct{ Args []string }{}
+	_ = os.Args
+}
+`, parser.ParseComments)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]bool{
+		"fmt":                          true,
+		"strings":                      true,
+		"github.com/go-redis/redis/v8": true,
+	}, usedImports(f, nil))
+}
+
+func TestRemoveUnusedImports(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "main.go", `package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	instagin "github.com/instana/go-sensor/instrumentation/instagin"
+)
+
+func main() {
+	fmt.Println(gin.New())
+}
+`, parser.ParseComments)
+	require.NoError(t, err)
+
+	usedBefore := usedImports(f, nil)
+
+	// replace gin.New() with instagin.New(), as the recipe does
+	call := f.Decls[1].(*ast.FuncDecl).Body.List[0].(*ast.ExprStmt).X.(*ast.CallExpr).Args[0].(*ast.CallExpr)
+	call.Fun.(*ast.SelectorExpr).X = ast.NewIdent("instagin")
+
+	removeUnusedImports(log.Logger, fset, f, nil, usedBefore)
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, format.Node(buf, fset, f))
+
+	// os was unused before the instrumentation, so it's left intact
+	assert.Equal(t, `package main
+
+import (
+	"fmt"
+	"os"
+
+	instagin "github.com/instana/go-sensor/instrumentation/instagin"
+)
+
+func main() {
+	fmt.Println(instagin.New())
+}
+`, buf.String())
+}
+
+func TestFormatSource(t *testing.T) {
+	data, err := formatSource("instana_go_dependency.go", []byte(`package main
+
+import (
+	instana "github.com/instana/go-sensor"
+
+	_ "github.com/instana/go-sensor/instrumentation/instagin"
+	_ "github.com/instana/go-sensor/instrumentation/instagrpc"
+)
+
+var __instanaSensor = instana.NewSensor("")
+
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, `package main
+
+import (
+	instana "github.com/instana/go-sensor"
+
+	_ "github.com/instana/go-sensor/instrumentation/instagin"
+	_ "github.com/instana/go-sensor/instrumentation/instagrpc"
+)
+
+var __instanaSensor = instana.NewSensor("")
+`, string(data))
+
+	_, err = formatSource("broken.go", []byte("package main\n\nfunc main() {\n"))
+	assert.Error(t, err)
+}
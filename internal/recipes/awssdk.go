@@ -6,10 +6,21 @@ import (
 	"github.com/instana/go-instana/internal/registry"
 	"go/ast"
 	"go/token"
+	"go/types"
 )
 
+// awsSDKSessionPkg is the import path of the instrumented package
+const awsSDKSessionPkg = "github.com/aws/aws-sdk-go/aws/session"
+
+// awsSDKMethods are the functions of the instrumented package replaced with their Instana counterparts
+var awsSDKMethods = map[string]insertOption{
+	"New":                   {},
+	"NewSession":            {},
+	"NewSessionWithOptions": {},
+}
+
 func init() {
-	registry.Default.Register("github.com/aws/aws-sdk-go/aws/session", NewAWSSDK())
+	registry.Default.Register(awsSDKSessionPkg, NewAWSSDK())
 }
 
 // NewAWSSDK returns AWSSDK recipe
@@ -28,11 +39,17 @@ func (recipe *AWSSDK) ImportPath() string {
 	return "github.com/instana/go-sensor/instrumentation/instaawssdk"
 }
 
+// Functions returns the functions of the instrumented package transformed by the recipe
+func (recipe *AWSSDK) Functions() []string {
+	return functionNames(awsSDKMethods)
+}
+
 // Instrument applies recipe to the ast Node
-func (recipe *AWSSDK) Instrument(fset *token.FileSet, f ast.Node, targetPkg, sensorVar string) (changed bool) {
-	return recipe.defaultRecipe.instrument(fset, f, targetPkg, sensorVar, recipe.InstanaPkg, recipe.ImportPath(), map[string]insertOption{
-		"New":                   {},
-		"NewSession":            {},
-		"NewSessionWithOptions": {},
-	})
+func (recipe *AWSSDK) Instrument(fset *token.FileSet, info *types.Info, f ast.Node, targetPkg, sensorVar string) registry.Edits {
+	return recipe.defaultRecipe.instrument(fset, info, f, awsSDKSessionPkg, targetPkg, sensorVar, recipe.InstanaPkg, recipe.ImportPath(), awsSDKMethods)
+}
+
+// Remove reverts the changes made by Instrument()
+func (recipe *AWSSDK) Remove(fset *token.FileSet, info *types.Info, f ast.Node, targetPkg, instanaPkg, sensorVar string) registry.Edits {
+	return recipe.defaultRecipe.remove(fset, info, f, awsSDKSessionPkg, targetPkg, sensorVar, instanaPkg, recipe.ImportPath(), awsSDKMethods)
 }
@@ -130,7 +130,7 @@ func main() {
 			require.NoError(t, err)
 
 			changed := recipes.NewAWSSDK().
-				Instrument(token.NewFileSet(), node, example.TargetPkg, "__instanaSensor")
+				Instrument(token.NewFileSet(), nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 			assert.True(t, changed)
 
@@ -209,7 +209,7 @@ func main() {
 			require.NoError(t, err)
 
 			changed := recipes.NewAWSSDK().
-				Instrument(token.NewFileSet(), node, example.TargetPkg, "__instanaSensor")
+				Instrument(token.NewFileSet(), nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 			assert.False(t, changed)
 
@@ -7,11 +7,20 @@ import (
 	"github.com/instana/go-instana/internal/registry"
 	"go/ast"
 	"go/token"
+	"go/types"
 )
 
+// databaseSQLPkg is the import path of the instrumented package
+const databaseSQLPkg = "database/sql"
+
+// databaseSQLMethods are the functions of the instrumented package replaced with their Instana counterparts
+var databaseSQLMethods = map[string]insertOption{
+	"Open": {functionName: "SQLInstrumentAndOpen"},
+}
+
 func init() {
 	recipe := NewDatabaseSQL()
-	registry.Default.Register("database/sql", recipe)
+	registry.Default.Register(databaseSQLPkg, recipe)
 }
 
 func NewDatabaseSQL() *DatabaseSQL {
@@ -29,9 +38,17 @@ func (recipe *DatabaseSQL) ImportPath() string {
 	return "github.com/instana/go-sensor"
 }
 
+// Functions returns the functions of the instrumented package transformed by the recipe
+func (recipe *DatabaseSQL) Functions() []string {
+	return functionNames(databaseSQLMethods)
+}
+
 // Instrument instruments sql.Open()
-func (recipe *DatabaseSQL) Instrument(fset *token.FileSet, node ast.Node, targetPkg, sensorVar string) (changed bool) {
-	return recipe.defaultRecipe.instrument(fset, node, targetPkg, sensorVar, recipe.InstanaPkg, recipe.ImportPath(), map[string]insertOption{
-		"Open": {functionName: "SQLInstrumentAndOpen"},
-	})
+func (recipe *DatabaseSQL) Instrument(fset *token.FileSet, info *types.Info, node ast.Node, targetPkg, sensorVar string) registry.Edits {
+	return recipe.defaultRecipe.instrument(fset, info, node, databaseSQLPkg, targetPkg, sensorVar, recipe.InstanaPkg, recipe.ImportPath(), databaseSQLMethods)
+}
+
+// Remove reverts the changes made by Instrument()
+func (recipe *DatabaseSQL) Remove(fset *token.FileSet, info *types.Info, f ast.Node, targetPkg, instanaPkg, sensorVar string) registry.Edits {
+	return recipe.defaultRecipe.remove(fset, info, f, databaseSQLPkg, targetPkg, sensorVar, instanaPkg, recipe.ImportPath(), databaseSQLMethods)
 }
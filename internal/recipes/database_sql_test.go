@@ -60,7 +60,7 @@ func main() {
 			require.NoError(t, err)
 
 			changed := recipes.NewDatabaseSQL().
-				Instrument(token.NewFileSet(), node, example.TargetPkg, "__instanaSensor")
+				Instrument(token.NewFileSet(), nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 			assert.True(t, changed)
 
@@ -105,7 +105,7 @@ func main() {
 			require.NoError(t, err)
 
 			changed := recipes.NewDatabaseSQL().
-				Instrument(token.NewFileSet(), node, example.TargetPkg, "__instanaSensor")
+				Instrument(token.NewFileSet(), nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 			assert.False(t, changed)
 
@@ -3,9 +3,13 @@
 package recipes
 
 import (
+	"fmt"
+	"github.com/instana/go-instana/internal/registry"
 	"go/ast"
 	"go/token"
+	"go/types"
 	"golang.org/x/tools/go/ast/astutil"
+	"sort"
 )
 
 const firstInsertPosition = 0
@@ -19,40 +23,66 @@ type insertOption struct {
 	functionName   string
 }
 
+// functionNames returns the sorted list of functions replaced by the recipe
+func functionNames(methods map[string]insertOption) []string {
+	names := make([]string, 0, len(methods))
+	for name := range methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
 // instrument applies recipe to the ast Node
-func (recipe *defaultRecipe) instrument(fset *token.FileSet, f ast.Node, targetPkg, sensorVar, instanaPkg, importPath string, methods map[string]insertOption) (changed bool) {
-	astutil.Apply(f,
+func (recipe *defaultRecipe) instrument(fset *token.FileSet, info *types.Info, f ast.Node, targetPkgPath, targetPkg, sensorVar, instanaPkg, importPath string, methods map[string]insertOption) (edits registry.Edits) {
+	apply(f,
 		func(c *astutil.Cursor) bool {
 			return true
 		},
 		func(c *astutil.Cursor) bool {
 			switch node := c.Node().(type) {
 			case *ast.CallExpr:
-				changed = recipe.instrumentMethodCall(node, targetPkg, sensorVar, instanaPkg, methods) || changed
+				if e, ok := recipe.instrumentMethodCall(info, node, targetPkgPath, targetPkg, sensorVar, instanaPkg, methods); ok {
+					edits = append(edits, e)
+				}
 			}
 
 			return true
 		},
 	)
 
-	if changed {
+	if edits.Changed() {
 		addNamedImport(fset, f, instanaPkg, importPath)
 	}
 
-	return changed
+	return edits
 }
 
-func (recipe *defaultRecipe) instrumentMethodCall(call *ast.CallExpr, targetPkg, sensorVar, instanaPkg string, methods map[string]insertOption) bool {
-	pkgName, fnName, ok := extractFunctionName(call)
+func (recipe *defaultRecipe) instrumentMethodCall(info *types.Info, call *ast.CallExpr, targetPkgPath, targetPkg, sensorVar, instanaPkg string, methods map[string]insertOption) (registry.Edit, bool) {
+	fnName, ok := packageFunctionName(info, call, targetPkgPath, targetPkg)
+	if !ok {
+		return registry.Edit{}, false
+	}
+
+	opt, ok := methods[fnName]
 	if !ok {
-		return false
+		return registry.Edit{}, false
 	}
 
-	if pkgName != targetPkg {
-		return false
+	newFnName := fnName
+	if opt.functionName != "" {
+		newFnName = opt.functionName
 	}
 
-	if opt, ok := methods[fnName]; ok {
+	// the sensor can only be inserted in front of an existing argument or right after the last one
+	if opt.sensorPosition > len(call.Args) {
+		return skipNode(call, "unexpected-arguments", fmt.Sprintf("%s.%s() is called with %d argument(s)", targetPkg, fnName, len(call.Args))), true
+	}
+
+	message := fmt.Sprintf("%s.%s() is replaced with %s.%s()", targetPkg, fnName, instanaPkg, newFnName)
+
+	return changeNode(call, "replace-call", message, func() ast.Node {
 		args := call.Args
 		ep := call.Ellipsis
 
@@ -67,25 +97,96 @@ func (recipe *defaultRecipe) instrumentMethodCall(call *ast.CallExpr, targetPkg,
 		default:
 			index := opt.sensorPosition
 
-			newArgs = append(args[:index+1], args[index:]...)
-			newArgs[index] = ast.NewIdent(sensorVar)
-		}
-
-		if opt.functionName != "" {
-			fnName = opt.functionName
+			newArgs = append(newArgs, args[:index]...)
+			newArgs = append(newArgs, ast.NewIdent(sensorVar))
+			newArgs = append(newArgs, args[index:]...)
 		}
 
 		*call = ast.CallExpr{
 			Fun: &ast.SelectorExpr{
 				X:   ast.NewIdent(instanaPkg),
-				Sel: ast.NewIdent(fnName),
+				Sel: ast.NewIdent(newFnName),
 			},
 			Args:     newArgs,
 			Ellipsis: ep,
 		}
 
-		return true
+		return call
+	}), true
+}
+
+// remove reverts the changes made by instrument() to the ast Node, where `instanaPkg` is the local name of
+// the instrumentation package import
+func (recipe *defaultRecipe) remove(fset *token.FileSet, info *types.Info, f ast.Node, targetPkgPath, targetPkg, sensorVar, instanaPkg, importPath string, methods map[string]insertOption) (edits registry.Edits) {
+	apply(f,
+		func(c *astutil.Cursor) bool {
+			return true
+		},
+		func(c *astutil.Cursor) bool {
+			switch node := c.Node().(type) {
+			case *ast.CallExpr:
+				if e, ok := recipe.removeMethodCall(info, node, targetPkg, sensorVar, instanaPkg, importPath, methods); ok {
+					edits = append(edits, e)
+				}
+			}
+
+			return true
+		},
+	)
+
+	if edits.Changed() {
+		addImport(fset, f, targetPkg, targetPkgPath)
+	}
+
+	return edits
+}
+
+func (recipe *defaultRecipe) removeMethodCall(info *types.Info, call *ast.CallExpr, targetPkg, sensorVar, instanaPkg, importPath string, methods map[string]insertOption) (registry.Edit, bool) {
+	newFnName, ok := packageFunctionName(info, call, importPath, instanaPkg)
+	if !ok {
+		return registry.Edit{}, false
+	}
+
+	fnName, opt, ok := originalFunction(methods, newFnName)
+	if !ok {
+		return registry.Edit{}, false
+	}
+
+	index := opt.sensorPosition
+	if index == lastInsertPosition {
+		index = len(call.Args) - 1
+	}
+
+	// the calls that have not been added by instrument() are left intact
+	if index < 0 || index >= len(call.Args) || !isSensorVar(call.Args[index], sensorVar) {
+		return registry.Edit{}, false
+	}
+
+	message := fmt.Sprintf("%s.%s() is replaced with %s.%s()", instanaPkg, newFnName, targetPkg, fnName)
+
+	return changeNode(call, "restore-call", message, func() ast.Node {
+		args := append(append([]ast.Expr(nil), call.Args[:index]...), call.Args[index+1:]...)
+
+		*call = ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   ast.NewIdent(targetPkg),
+				Sel: ast.NewIdent(fnName),
+			},
+			Args:     args,
+			Ellipsis: call.Ellipsis,
+		}
+
+		return call
+	}), true
+}
+
+// originalFunction returns the name of the function replaced with `newFnName` by instrument()
+func originalFunction(methods map[string]insertOption, newFnName string) (string, insertOption, bool) {
+	for fnName, opt := range methods {
+		if opt.functionName == newFnName || (opt.functionName == "" && fnName == newFnName) {
+			return fnName, opt, true
+		}
 	}
 
-	return false
+	return "", insertOption{}, false
 }
@@ -6,10 +6,19 @@ import (
 	"github.com/instana/go-instana/internal/registry"
 	"go/ast"
 	"go/token"
+	"go/types"
 )
 
+// echoPkg is the import path of the instrumented package
+const echoPkg = "github.com/labstack/echo/v4"
+
+// echoMethods are the functions of the instrumented package replaced with their Instana counterparts
+var echoMethods = map[string]insertOption{
+	"New": {},
+}
+
 func init() {
-	registry.Default.Register("github.com/labstack/echo/v4", NewEcho())
+	registry.Default.Register(echoPkg, NewEcho())
 }
 
 func NewEcho() *Echo {
@@ -27,9 +36,17 @@ func (recipe *Echo) ImportPath() string {
 	return "github.com/instana/go-sensor/instrumentation/instaecho"
 }
 
+// Functions returns the functions of the instrumented package transformed by the recipe
+func (recipe *Echo) Functions() []string {
+	return functionNames(echoMethods)
+}
+
 // Instrument applies recipe to the ast Node
-func (recipe *Echo) Instrument(fset *token.FileSet, f ast.Node, targetPkg, sensorVar string) bool {
-	return recipe.defaultRecipe.instrument(fset, f, targetPkg, sensorVar, recipe.InstanaPkg, recipe.ImportPath(), map[string]insertOption{
-		"New": {},
-	})
+func (recipe *Echo) Instrument(fset *token.FileSet, info *types.Info, f ast.Node, targetPkg, sensorVar string) registry.Edits {
+	return recipe.defaultRecipe.instrument(fset, info, f, echoPkg, targetPkg, sensorVar, recipe.InstanaPkg, recipe.ImportPath(), echoMethods)
+}
+
+// Remove reverts the changes made by Instrument()
+func (recipe *Echo) Remove(fset *token.FileSet, info *types.Info, f ast.Node, targetPkg, instanaPkg, sensorVar string) registry.Edits {
+	return recipe.defaultRecipe.remove(fset, info, f, echoPkg, targetPkg, sensorVar, instanaPkg, recipe.ImportPath(), echoMethods)
 }
@@ -119,7 +119,7 @@ func RunEcho(addr string) {
 			require.NoError(t, err)
 
 			changed := recipes.NewEcho().
-				Instrument(token.NewFileSet(), node, example.TargetPkg, "__instanaSensor")
+				Instrument(token.NewFileSet(), nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 			assert.Equal(t, example.Changed, changed)
 
@@ -0,0 +1,59 @@
+// (c) Copyright IBM Corp. 2022
+
+package recipes_test
+
+import (
+	"sort"
+	"testing"
+
+	_ "github.com/instana/go-instana/internal/recipes"
+	"github.com/instana/go-instana/internal/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFunctions(t *testing.T) {
+	for _, name := range registry.Default.ListNames() {
+		t.Run(name, func(t *testing.T) {
+			describer, ok := registry.Default.InstrumentationRecipe(name).(registry.Describer)
+			require.True(t, ok, "registered recipes are expected to implement registry.Describer")
+
+			fns := describer.Functions()
+			assert.NotEmpty(t, fns)
+			assert.True(t, sort.StringsAreSorted(fns), fns)
+		})
+	}
+}
+
+func TestFunctions_SubRecipes(t *testing.T) {
+	examples := map[string][]string{
+		"net/http:server":                            {"(*ServeMux).Handle", "(*ServeMux).HandleFunc", "Handle", "HandleFunc"},
+		"net/http:client":                            {"Client{}"},
+		"google.golang.org/grpc:server":              {"NewServer"},
+		"google.golang.org/grpc:client":              {"Dial"},
+		"github.com/gin-gonic/gin":                   {"Default", "New"},
+		"github.com/Shopify/sarama:constructors":     {"NewAsyncProducer", "NewAsyncProducerFromClient", "NewConsumer", "NewConsumerFromClient", "NewConsumerGroup", "NewConsumerGroupFromClient", "NewSyncProducer", "NewSyncProducerFromClient"},
+		"github.com/Shopify/sarama:producer-context": {"(SyncProducer).SendMessage"},
+	}
+
+	for name, expected := range examples {
+		t.Run(name, func(t *testing.T) {
+			describer := registry.Default.InstrumentationRecipe(name).(registry.Describer)
+			assert.Equal(t, expected, describer.Functions())
+		})
+	}
+}
+
+func TestSubRecipeNames(t *testing.T) {
+	bare := make(map[string]bool)
+	for _, name := range registry.Default.ListNames() {
+		bare[name] = registry.TargetPackage(name) == name
+	}
+
+	// the package recipes registered under the package path can't be unregistered separately from its sub-recipes
+	for name, isBare := range bare {
+		if !isBare {
+			assert.False(t, bare[registry.TargetPackage(name)], "%s is expected to be a sub-recipe along with %s", registry.TargetPackage(name), name)
+		}
+	}
+}
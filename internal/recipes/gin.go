@@ -6,10 +6,20 @@ import (
 	"github.com/instana/go-instana/internal/registry"
 	"go/ast"
 	"go/token"
+	"go/types"
 )
 
+// ginPkg is the import path of the instrumented package
+const ginPkg = "github.com/gin-gonic/gin"
+
+// ginMethods are the functions of the instrumented package replaced with their Instana counterparts
+var ginMethods = map[string]insertOption{
+	"New":     {},
+	"Default": {},
+}
+
 func init() {
-	registry.Default.Register("github.com/gin-gonic/gin", NewGin())
+	registry.Default.Register(ginPkg, NewGin())
 }
 
 // NewGin returns Gin recipe
@@ -28,10 +38,17 @@ func (recipe *Gin) ImportPath() string {
 	return "github.com/instana/go-sensor/instrumentation/instagin"
 }
 
+// Functions returns the functions of the instrumented package transformed by the recipe
+func (recipe *Gin) Functions() []string {
+	return functionNames(ginMethods)
+}
+
 // Instrument applies recipe to the ast Node
-func (recipe *Gin) Instrument(fset *token.FileSet, f ast.Node, targetPkg, sensorVar string) bool {
-	return recipe.defaultRecipe.instrument(fset, f, targetPkg, sensorVar, recipe.InstanaPkg, recipe.ImportPath(), map[string]insertOption{
-		"New":     {},
-		"Default": {},
-	})
+func (recipe *Gin) Instrument(fset *token.FileSet, info *types.Info, f ast.Node, targetPkg, sensorVar string) registry.Edits {
+	return recipe.defaultRecipe.instrument(fset, info, f, ginPkg, targetPkg, sensorVar, recipe.InstanaPkg, recipe.ImportPath(), ginMethods)
+}
+
+// Remove reverts the changes made by Instrument()
+func (recipe *Gin) Remove(fset *token.FileSet, info *types.Info, f ast.Node, targetPkg, instanaPkg, sensorVar string) registry.Edits {
+	return recipe.defaultRecipe.remove(fset, info, f, ginPkg, targetPkg, sensorVar, instanaPkg, recipe.ImportPath(), ginMethods)
 }
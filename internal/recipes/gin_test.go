@@ -100,7 +100,7 @@ func assertGinInstrumentation(t *testing.T, examples map[string]struct {
 				require.NoError(t, err)
 
 				changed := recipes.NewGin().
-					Instrument(token.NewFileSet(), node, example.TargetPkg, "__instanaSensor")
+					Instrument(token.NewFileSet(), nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 				assert.True(t, changed)
 
@@ -258,7 +258,7 @@ func main() {
 				require.NoError(t, err)
 
 				changed := recipes.NewGin().
-					Instrument(token.NewFileSet(), node, example.TargetPkg, "__instanaSensor")
+					Instrument(token.NewFileSet(), nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 				assert.False(t, changed)
 
@@ -7,20 +7,44 @@ import (
 	"github.com/instana/go-instana/internal/registry"
 	"go/ast"
 	"go/token"
+	"go/types"
 	"golang.org/x/tools/go/ast/astutil"
 )
 
+// grpcPkg is the import path of the instrumented package
+const grpcPkg = "google.golang.org/grpc"
+
 func init() {
-	registry.Default.Register("google.golang.org/grpc", NewGRPC())
+	registry.Default.Register(registry.RecipeName(grpcPkg, "server"), NewGRPCServer())
+	registry.Default.Register(registry.RecipeName(grpcPkg, "client"), NewGRPCClient())
 }
 
+// NewGRPC returns the recipe instrumenting both gRPC servers and clients
 func NewGRPC() *GRPC {
-	return &GRPC{InstanaPkg: "instagrpc"}
+	return &GRPC{InstanaPkg: "instagrpc", server: true, client: true}
+}
+
+// NewGRPCServer returns the recipe instrumenting gRPC servers only
+func NewGRPCServer() *GRPC {
+	recipe := NewGRPC()
+	recipe.client = false
+
+	return recipe
+}
+
+// NewGRPCClient returns the recipe instrumenting gRPC clients only
+func NewGRPCClient() *GRPC {
+	recipe := NewGRPC()
+	recipe.server = false
+
+	return recipe
 }
 
 // GRPC instruments google.golang.org/grpc package with Instana
 type GRPC struct {
 	InstanaPkg string
+
+	server, client bool
 }
 
 // ImportPath returns instrumentation import path
@@ -28,70 +52,84 @@ func (recipe *GRPC) ImportPath() string {
 	return "github.com/instana/go-sensor/instrumentation/instagrpc"
 }
 
-func (recipe *GRPC) Instrument(fset *token.FileSet, f ast.Node, targetPkg, sensorVar string) (changed bool) {
-	astutil.Apply(f,
+// Functions returns the functions of the instrumented package transformed by the recipe
+func (recipe *GRPC) Functions() []string {
+	var fns []string
+	if recipe.client {
+		fns = append(fns, "Dial")
+	}
+
+	if recipe.server {
+		fns = append(fns, "NewServer")
+	}
+
+	return fns
+}
+
+// Instrument adds Instana interceptors to grpc.NewServer() and grpc.Dial() calls
+func (recipe *GRPC) Instrument(fset *token.FileSet, info *types.Info, f ast.Node, targetPkg, sensorVar string) (edits registry.Edits) {
+	apply(f,
 		func(c *astutil.Cursor) bool {
 			return true
 		},
 		func(c *astutil.Cursor) bool {
 			switch node := c.Node().(type) {
 			case *ast.CallExpr:
-				changed = recipe.instrumentMethodCall(node, targetPkg, sensorVar) || changed
+				if e, ok := recipe.instrumentMethodCall(info, node, targetPkg, sensorVar); ok {
+					edits = append(edits, e)
+				}
 			}
 
 			return true
 		},
 	)
 
-	if changed {
+	if edits.Changed() {
 		addNamedImport(fset, f, recipe.InstanaPkg, recipe.ImportPath())
 	}
 
-	return changed
+	return edits
 }
 
-func (recipe *GRPC) instrumentMethodCall(call *ast.CallExpr, targetPkg, sensorVar string) bool {
-	pkgName, fnName, ok := extractFunctionName(call)
+func (recipe *GRPC) instrumentMethodCall(info *types.Info, call *ast.CallExpr, targetPkg, sensorVar string) (registry.Edit, bool) {
+	fnName, ok := packageFunctionName(info, call, grpcPkg, targetPkg)
 	if !ok {
-		return false
+		return registry.Edit{}, false
 	}
 
-	if pkgName != targetPkg {
-		return false
-	}
-
-	switch fnName {
-	case "NewServer":
+	switch {
+	case fnName == "NewServer" && recipe.server:
 		if recipe.argumentsAlreadyInstrumented(call.Args, sensorVar) {
-			return false
+			return registry.Edit{}, false
 		}
 
-		originalArgsLen := len(call.Args)
-		call.Args = append([]ast.Expr{
-			recipe.targetCallExpr(targetPkg, "ChainStreamInterceptor", recipe.instrumentationCallExpr("StreamServerInterceptor", sensorVar)),
-			recipe.targetCallExpr(targetPkg, "ChainUnaryInterceptor", recipe.instrumentationCallExpr("UnaryServerInterceptor", sensorVar)),
-		}, call.Args...)
+		return changeNode(call, "add-server-interceptors", "Instana interceptors are added to grpc.NewServer() options", func() ast.Node {
+			call.Args = append([]ast.Expr{
+				recipe.targetCallExpr(targetPkg, "ChainStreamInterceptor", recipe.instrumentationCallExpr("StreamServerInterceptor", sensorVar)),
+				recipe.targetCallExpr(targetPkg, "ChainUnaryInterceptor", recipe.instrumentationCallExpr("UnaryServerInterceptor", sensorVar)),
+			}, call.Args...)
 
-		return originalArgsLen != len(call.Args)
-	case "Dial":
+			return call
+		}), true
+	case fnName == "Dial" && recipe.client:
 		if recipe.argumentsAlreadyInstrumented(call.Args, sensorVar) {
-			return false
+			return registry.Edit{}, false
 		}
 
-		originalArgsLen := len(call.Args)
-
-		if originalArgsLen == 0 {
-			return false
+		if len(call.Args) == 0 {
+			return skipNode(call, "dial-without-target", "grpc.Dial() is called without the target argument"), true
 		}
 
-		call.Args = append([]ast.Expr{call.Args[0]}, append([]ast.Expr{
-			recipe.targetCallExpr(targetPkg, "WithChainStreamInterceptor", recipe.instrumentationCallExpr("StreamClientInterceptor", sensorVar)),
-			recipe.targetCallExpr(targetPkg, "WithChainUnaryInterceptor", recipe.instrumentationCallExpr("UnaryClientInterceptor", sensorVar)),
-		}, call.Args[1:]...)...)
+		return changeNode(call, "add-client-interceptors", "Instana interceptors are added to grpc.Dial() options", func() ast.Node {
+			call.Args = append([]ast.Expr{call.Args[0]}, append([]ast.Expr{
+				recipe.targetCallExpr(targetPkg, "WithChainStreamInterceptor", recipe.instrumentationCallExpr("StreamClientInterceptor", sensorVar)),
+				recipe.targetCallExpr(targetPkg, "WithChainUnaryInterceptor", recipe.instrumentationCallExpr("UnaryClientInterceptor", sensorVar)),
+			}, call.Args[1:]...)...)
 
-		return originalArgsLen != len(call.Args)
+			return call
+		}), true
 	default:
-		return false
+		return registry.Edit{}, false
 	}
 }
 
@@ -144,3 +182,96 @@ func (recipe *GRPC) instrumentationCallExpr(funcName, sensorVar string) ast.Expr
 		},
 	}
 }
+
+// Remove removes Instana interceptors added by Instrument() from grpc.NewServer() and grpc.Dial() calls
+func (recipe *GRPC) Remove(fset *token.FileSet, info *types.Info, f ast.Node, targetPkg, instanaPkg, sensorVar string) (edits registry.Edits) {
+	apply(f,
+		func(c *astutil.Cursor) bool {
+			return true
+		},
+		func(c *astutil.Cursor) bool {
+			switch node := c.Node().(type) {
+			case *ast.CallExpr:
+				if e, ok := recipe.removeMethodCall(info, node, targetPkg, instanaPkg, sensorVar); ok {
+					edits = append(edits, e)
+				}
+			}
+
+			return true
+		},
+	)
+
+	return edits
+}
+
+func (recipe *GRPC) removeMethodCall(info *types.Info, call *ast.CallExpr, targetPkg, instanaPkg, sensorVar string) (registry.Edit, bool) {
+	fnName, ok := packageFunctionName(info, call, grpcPkg, targetPkg)
+	if !ok {
+		return registry.Edit{}, false
+	}
+
+	var (
+		interceptors map[string]string
+		reason       string
+	)
+	switch {
+	case fnName == "NewServer" && recipe.server:
+		reason, interceptors = "remove-server-interceptors", map[string]string{
+			"ChainStreamInterceptor": "StreamServerInterceptor",
+			"ChainUnaryInterceptor":  "UnaryServerInterceptor",
+		}
+	case fnName == "Dial" && recipe.client:
+		reason, interceptors = "remove-client-interceptors", map[string]string{
+			"WithChainStreamInterceptor": "StreamClientInterceptor",
+			"WithChainUnaryInterceptor":  "UnaryClientInterceptor",
+		}
+	default:
+		return registry.Edit{}, false
+	}
+
+	var args []ast.Expr
+	for _, arg := range call.Args {
+		if !recipe.isInterceptorOption(info, arg, targetPkg, instanaPkg, sensorVar, interceptors) {
+			args = append(args, arg)
+		}
+	}
+
+	if len(args) == len(call.Args) {
+		return registry.Edit{}, false
+	}
+
+	return changeNode(call, reason, "Instana interceptors are removed from "+targetPkg+"."+fnName+"() options", func() ast.Node {
+		call.Args = args
+
+		return call
+	}), true
+}
+
+// isInterceptorOption returns whether the expression is an option added by Instrument(), such as
+// `grpc.ChainUnaryInterceptor(instagrpc.UnaryServerInterceptor(__instanaSensor))`, where `interceptors` maps the
+// option function names to the names of Instana interceptors
+func (recipe *GRPC) isInterceptorOption(info *types.Info, expr ast.Expr, targetPkg, instanaPkg, sensorVar string, interceptors map[string]string) bool {
+	call, ok := expr.(*ast.CallExpr)
+	if !ok || len(call.Args) != 1 {
+		return false
+	}
+
+	optName, ok := packageFunctionName(info, call, grpcPkg, targetPkg)
+	if !ok {
+		return false
+	}
+
+	interceptorName, ok := interceptors[optName]
+	if !ok {
+		return false
+	}
+
+	interceptor, ok := call.Args[0].(*ast.CallExpr)
+	if !ok || len(interceptor.Args) != 1 || !isSensorVar(interceptor.Args[0], sensorVar) {
+		return false
+	}
+
+	fnName, ok := packageFunctionName(info, interceptor, recipe.ImportPath(), instanaPkg)
+
+	return ok && fnName == interceptorName
+}
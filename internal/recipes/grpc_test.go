@@ -54,7 +54,7 @@ func TestGRPCServerRecipe(t *testing.T) {
 			require.NoError(t, err)
 
 			changed := recipes.NewGRPC().
-				Instrument(token.NewFileSet(), node, example.TargetPkg, "__instanaSensor")
+				Instrument(token.NewFileSet(), nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 			assert.True(t, changed)
 
@@ -144,7 +144,7 @@ func main() {
 			require.NoError(t, err)
 
 			changed := recipes.NewGRPC().
-				Instrument(token.NewFileSet(), node, example.TargetPkg, "__instanaSensor")
+				Instrument(token.NewFileSet(), nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 			assert.Equal(t, example.Changed, changed)
 
@@ -195,7 +195,7 @@ func TestGRPCClientRecipe(t *testing.T) {
 			require.NoError(t, err)
 
 			changed := recipes.NewGRPC().
-				Instrument(token.NewFileSet(), node, example.TargetPkg, "__instanaSensor")
+				Instrument(token.NewFileSet(), nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 			assert.True(t, changed)
 
@@ -286,7 +286,7 @@ func main() {
 			require.NoError(t, err)
 
 			changed := recipes.NewGRPC().
-				Instrument(token.NewFileSet(), node, example.TargetPkg, "__instanaSensor")
+				Instrument(token.NewFileSet(), nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 			assert.Equal(t, example.Changed, changed)
 
@@ -297,3 +297,39 @@ func main() {
 		})
 	}
 }
+
+func TestGRPCRecipe_SubRecipes(t *testing.T) {
+	examples := map[string]struct {
+		Recipe         *recipes.GRPC
+		Server, Client bool
+	}{
+		"server":   {Recipe: recipes.NewGRPCServer(), Server: true},
+		"client":   {Recipe: recipes.NewGRPCClient(), Client: true},
+		"combined": {Recipe: recipes.NewGRPC(), Server: true, Client: true},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			server, err := parser.ParseExpr(`grpc.NewServer()`)
+			require.NoError(t, err)
+
+			client, err := parser.ParseExpr(`grpc.Dial("localhost")`)
+			require.NoError(t, err)
+
+			assert.Equal(t, example.Server, example.Recipe.Instrument(token.NewFileSet(), nil, server, "grpc", "__instanaSensor").Changed())
+			assert.Equal(t, example.Client, example.Recipe.Instrument(token.NewFileSet(), nil, client, "grpc", "__instanaSensor").Changed())
+		})
+	}
+}
+
+func TestGRPCClientRecipe_DialWithoutTarget(t *testing.T) {
+	node, err := parser.ParseExpr(`grpc.Dial()`)
+	require.NoError(t, err)
+
+	edits := recipes.NewGRPC().Instrument(token.NewFileSet(), nil, node, "grpc", "__instanaSensor")
+	require.Len(t, edits, 1)
+
+	assert.False(t, edits.Changed())
+	assert.Equal(t, "dial-without-target", edits[0].Reason)
+	assert.Equal(t, `grpc.Dial()`, edits[0].Original)
+}
@@ -6,11 +6,15 @@ import (
 	"github.com/instana/go-instana/internal/registry"
 	"go/ast"
 	"go/token"
+	"go/types"
 	"golang.org/x/tools/go/ast/astutil"
 )
 
+// httpRouterPkg is the import path of the instrumented package
+const httpRouterPkg = "github.com/julienschmidt/httprouter"
+
 func init() {
-	registry.Default.Register("github.com/julienschmidt/httprouter", NewHttpRouter())
+	registry.Default.Register(httpRouterPkg, NewHttpRouter())
 }
 
 // NewHttpRouter returns the HttpRouter recipe
@@ -28,72 +32,123 @@ func (recipe *HttpRouter) ImportPath() string {
 	return "github.com/instana/go-sensor/instrumentation/instahttprouter"
 }
 
+// Functions returns the functions of the instrumented package transformed by the recipe
+func (recipe *HttpRouter) Functions() []string {
+	return []string{"New"}
+}
+
 // Instrument applies the recipe to the ast Node
-func (recipe *HttpRouter) Instrument(fset *token.FileSet, f ast.Node, targetPkg, sensorVar string) (changed bool) {
-	astutil.Apply(f, func(c *astutil.Cursor) bool {
+func (recipe *HttpRouter) Instrument(fset *token.FileSet, info *types.Info, f ast.Node, targetPkg, sensorVar string) (edits registry.Edits) {
+	apply(f, func(c *astutil.Cursor) bool {
 		return c.Node() != nil
 	}, func(c *astutil.Cursor) bool {
 		switch node := c.Node().(type) {
 		// We look for `var something *httprouter.Router` and replace by `var something *instahttprouter.WrappedRouter`
 		case *ast.SelectorExpr:
-			nodeX, ok := node.X.(*ast.Ident)
-
-			if ok && nodeX.Name == "httprouter" && node.Sel.Name == "Router" {
-				nodeX.Name = recipe.InstanaPkg
-				node.Sel.Name = "WrappedRouter"
-				changed = true
+			if node.Sel.Name != "Router" || !isImportedPackage(info, node.X, httpRouterPkg, targetPkg) {
+				return true
 			}
 
+			edits = append(edits, changeNode(node, "replace-router-type", targetPkg+".Router type is replaced with "+recipe.InstanaPkg+".WrappedRouter", func() ast.Node {
+				replacement := &ast.SelectorExpr{
+					X:   ast.NewIdent(recipe.InstanaPkg),
+					Sel: ast.NewIdent("WrappedRouter"),
+				}
+				c.Replace(replacement)
+
+				return replacement
+			}))
+
 		// Replacing httprouter.New() by instahttprouter.Wrap(httprouter.New(), __instanaSensor)
 		case *ast.CallExpr:
-			libPkg, libFunction, _ := extractFunctionName(node)
-
-			if libPkg != "httprouter" || libFunction != "New" {
+			if fnName, ok := packageFunctionName(info, node, httpRouterPkg, targetPkg); !ok || fnName != "New" {
 				return true
 			}
 
 			// If httprouter.New() is an argument of instahttprouter.Wrap(), it is already instrumented
 			if parent, ok := c.Parent().(*ast.CallExpr); ok {
-				instanaPkg, instanaFunction, found := extractFunctionName(parent)
-				if found && instanaPkg == "instahttprouter" && instanaFunction == "Wrap" {
+				if fnName, ok := packageFunctionName(info, parent, recipe.ImportPath(), recipe.InstanaPkg); ok && fnName == "Wrap" {
 					return true
 				}
 			}
 
-			fn, ok := node.Fun.(*ast.SelectorExpr)
+			edits = append(edits, changeNode(node, "wrap-router", targetPkg+".New() is wrapped with "+recipe.InstanaPkg+".Wrap()", func() ast.Node {
+				replacement := &ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   ast.NewIdent(recipe.InstanaPkg),
+						Sel: ast.NewIdent("Wrap"),
+					},
+					Args: []ast.Expr{
+						node,
+						ast.NewIdent(sensorVar),
+					},
+				}
+				c.Replace(replacement)
+
+				return replacement
+			}))
+		}
+
+		return true
+	})
 
-			if !ok {
+	if edits.Changed() {
+		addNamedImport(fset, f, recipe.InstanaPkg, recipe.ImportPath())
+	}
+
+	return edits
+}
+
+// Remove reverts the changes made by Instrument()
+func (recipe *HttpRouter) Remove(fset *token.FileSet, info *types.Info, f ast.Node, targetPkg, instanaPkg, sensorVar string) (edits registry.Edits) {
+	var routerTypeRestored bool
+
+	apply(f, func(c *astutil.Cursor) bool {
+		return c.Node() != nil
+	}, func(c *astutil.Cursor) bool {
+		switch node := c.Node().(type) {
+		// Replacing `*instahttprouter.WrappedRouter` back with `*httprouter.Router`
+		case *ast.SelectorExpr:
+			if node.Sel.Name != "WrappedRouter" || !isImportedPackage(info, node.X, recipe.ImportPath(), instanaPkg) {
 				return true
 			}
 
-			fnX, ok := fn.X.(*ast.Ident)
+			routerTypeRestored = true
+			edits = append(edits, changeNode(node, "restore-router-type", instanaPkg+".WrappedRouter type is replaced with "+targetPkg+".Router", func() ast.Node {
+				replacement := &ast.SelectorExpr{
+					X:   ast.NewIdent(targetPkg),
+					Sel: ast.NewIdent("Router"),
+				}
+				c.Replace(replacement)
+
+				return replacement
+			}))
 
-			if !ok {
+		// Replacing instahttprouter.Wrap(httprouter.New(), __instanaSensor) back with httprouter.New()
+		case *ast.CallExpr:
+			if fnName, ok := packageFunctionName(info, node, recipe.ImportPath(), instanaPkg); !ok || fnName != "Wrap" {
 				return true
 			}
 
-			node.Args = []ast.Expr{
-				&ast.BasicLit{
-					Kind:  token.STRING,
-					Value: "httprouter.New()",
-				},
-				&ast.BasicLit{
-					Kind:  token.STRING,
-					Value: sensorVar,
-				},
+			if len(node.Args) != 2 || !isSensorVar(node.Args[1], sensorVar) {
+				return true
 			}
-			fnX.Name = "instahttprouter"
-			fn.Sel.Name = "Wrap"
 
-			changed = true
+			edits = append(edits, changeNode(node, "unwrap-router", "the router is unwrapped from "+instanaPkg+".Wrap()", func() ast.Node {
+				c.Replace(node.Args[0])
+
+				return node.Args[0]
+			}))
 		}
 
 		return true
 	})
 
-	if changed {
-		addNamedImport(fset, f, recipe.InstanaPkg, recipe.ImportPath())
+	// the target package is only referenced by the restored type, since the router passed to Wrap() is already
+	// created with it
+	if routerTypeRestored {
+		addImport(fset, f, targetPkg, httpRouterPkg)
 	}
 
-	return changed
+	return edits
 }
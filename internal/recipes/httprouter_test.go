@@ -182,7 +182,7 @@ func main() {
 
 			recipe := NewHttpRouter()
 
-			changed := recipe.Instrument(fset, node, example.TargetPkg, "__instanaSensor")
+			changed := recipe.Instrument(fset, nil, node, example.TargetPkg, "__instanaSensor").Changed()
 			assert.Equal(t, example.Changed, changed)
 
 			buf := bytes.NewBuffer(nil)
@@ -3,14 +3,19 @@
 package recipes
 
 import (
+	"fmt"
 	"github.com/instana/go-instana/internal/registry"
 	"go/ast"
 	"go/token"
+	"go/types"
 	"golang.org/x/tools/go/ast/astutil"
 )
 
+// lambdaPkg is the import path of the instrumented package
+const lambdaPkg = "github.com/aws/aws-lambda-go/lambda"
+
 func init() {
-	registry.Default.Register("github.com/aws/aws-lambda-go/lambda", NewLambda())
+	registry.Default.Register(lambdaPkg, NewLambda())
 }
 
 func NewLambda() *Lambda {
@@ -27,83 +32,75 @@ func (recipe *Lambda) ImportPath() string {
 	return "github.com/instana/go-sensor/instrumentation/instalambda"
 }
 
-func (recipe *Lambda) Instrument(fset *token.FileSet, f ast.Node, targetPkg, sensorVar string) (changed bool) {
-	astutil.Apply(f,
+// Functions returns the functions of the instrumented package transformed by the recipe
+func (recipe *Lambda) Functions() []string {
+	return []string{"Start", "StartHandler", "StartHandlerWithContext", "StartWithContext", "StartWithOptions"}
+}
+
+// Instrument wraps the handlers passed to lambda.Start*() functions
+func (recipe *Lambda) Instrument(fset *token.FileSet, info *types.Info, f ast.Node, targetPkg, sensorVar string) (edits registry.Edits) {
+	apply(f,
 		func(c *astutil.Cursor) bool {
 			return true
 		},
 		func(c *astutil.Cursor) bool {
 			switch node := c.Node().(type) {
 			case *ast.CallExpr:
-				changed = recipe.instrumentMethodCall(node, targetPkg, sensorVar) || changed
+				if e, ok := recipe.instrumentMethodCall(info, node, targetPkg, sensorVar); ok {
+					edits = append(edits, e)
+				}
 			}
 
 			return true
 		},
 	)
 
-	if changed {
+	if edits.Changed() {
 		addNamedImport(fset, f, recipe.InstanaPkg, recipe.ImportPath())
 	}
 
-	return changed
+	return edits
 }
 
-func (recipe *Lambda) instrumentMethodCall(call *ast.CallExpr, targetPkg, sensorVar string) bool {
-	pkgName, fnName, ok := extractFunctionName(call)
+func (recipe *Lambda) instrumentMethodCall(info *types.Info, call *ast.CallExpr, targetPkg, sensorVar string) (registry.Edit, bool) {
+	fnName, ok := packageFunctionName(info, call, lambdaPkg, targetPkg)
 	if !ok {
-		return false
-	}
-
-	if pkgName != targetPkg {
-		return false
+		return registry.Edit{}, false
 	}
 
+	var (
+		handlerIndex int
+		wrapperName  string
+	)
 	switch fnName {
-	case "Start":
-		if recipe.argumentsAlreadyInstrumented(call.Args, sensorVar) {
-			return false
-		}
-
-		call.Args[0] = recipe.instrumentationCallExpr("NewHandler", call.Args[0], sensorVar)
-
-		return true
-
+	case "Start", "StartWithOptions":
+		handlerIndex, wrapperName = 0, "NewHandler"
 	case "StartHandler":
-		if recipe.argumentsAlreadyInstrumented(call.Args, sensorVar) {
-			return false
-		}
-
-		call.Args[0] = recipe.instrumentationCallExpr("WrapHandler", call.Args[0], sensorVar)
-
-		return true
+		handlerIndex, wrapperName = 0, "WrapHandler"
 	case "StartHandlerWithContext":
-		if recipe.argumentsAlreadyInstrumented(call.Args, sensorVar) {
-			return false
-		}
-
-		call.Args[1] = recipe.instrumentationCallExpr("WrapHandler", call.Args[1], sensorVar)
+		handlerIndex, wrapperName = 1, "WrapHandler"
+	case "StartWithContext":
+		handlerIndex, wrapperName = 1, "NewHandler"
+	default:
+		return registry.Edit{}, false
+	}
 
-		return true
-	case "StartWithOptions":
-		if recipe.argumentsAlreadyInstrumented(call.Args, sensorVar) {
-			return false
-		}
+	if recipe.argumentsAlreadyInstrumented(call.Args, sensorVar) {
+		return registry.Edit{}, false
+	}
 
-		call.Args[0] = recipe.instrumentationCallExpr("NewHandler", call.Args[0], sensorVar)
+	if len(call.Args) <= handlerIndex {
+		return skipNode(call, "unexpected-arguments", fmt.Sprintf("%s.%s() is called without the handler argument", targetPkg, fnName)), true
+	}
 
-		return true
-	case "StartWithContext":
-		if recipe.argumentsAlreadyInstrumented(call.Args, sensorVar) {
-			return false
-		}
+	handler := call.Args[handlerIndex]
+	message := fmt.Sprintf("the handler passed to %s.%s() is wrapped with %s.%s()", targetPkg, fnName, recipe.InstanaPkg, wrapperName)
 
-		call.Args[1] = recipe.instrumentationCallExpr("NewHandler", call.Args[1], sensorVar)
+	return changeNode(handler, "wrap-handler", message, func() ast.Node {
+		call.Args[handlerIndex] = recipe.instrumentationCallExpr(wrapperName, handler, sensorVar)
 
-		return true
-	default:
-		return false
-	}
+		return call.Args[handlerIndex]
+	}), true
 }
 
 func (recipe *Lambda) argumentsAlreadyInstrumented(args []ast.Expr, sensorVar string) bool {
@@ -142,3 +139,63 @@ func (recipe *Lambda) instrumentationCallExpr(funcName string, handler ast.Expr,
 		},
 	}
 }
+
+// Remove unwraps the handlers passed to lambda.Start*() functions
+func (recipe *Lambda) Remove(fset *token.FileSet, info *types.Info, f ast.Node, targetPkg, instanaPkg, sensorVar string) (edits registry.Edits) {
+	apply(f,
+		func(c *astutil.Cursor) bool {
+			return true
+		},
+		func(c *astutil.Cursor) bool {
+			switch node := c.Node().(type) {
+			case *ast.CallExpr:
+				if e, ok := recipe.removeMethodCall(info, node, targetPkg, instanaPkg, sensorVar); ok {
+					edits = append(edits, e)
+				}
+			}
+
+			return true
+		},
+	)
+
+	return edits
+}
+
+func (recipe *Lambda) removeMethodCall(info *types.Info, call *ast.CallExpr, targetPkg, instanaPkg, sensorVar string) (registry.Edit, bool) {
+	fnName, ok := packageFunctionName(info, call, lambdaPkg, targetPkg)
+	if !ok {
+		return registry.Edit{}, false
+	}
+
+	var handlerIndex int
+	switch fnName {
+	case "Start", "StartWithOptions", "StartHandler":
+		handlerIndex = 0
+	case "StartHandlerWithContext", "StartWithContext":
+		handlerIndex = 1
+	default:
+		return registry.Edit{}, false
+	}
+
+	if len(call.Args) <= handlerIndex {
+		return registry.Edit{}, false
+	}
+
+	wrapper, ok := call.Args[handlerIndex].(*ast.CallExpr)
+	if !ok || len(wrapper.Args) != 2 || !isSensorVar(wrapper.Args[1], sensorVar) {
+		return registry.Edit{}, false
+	}
+
+	wrapperName, ok := packageFunctionName(info, wrapper, recipe.ImportPath(), instanaPkg)
+	if !ok || (wrapperName != "NewHandler" && wrapperName != "WrapHandler") {
+		return registry.Edit{}, false
+	}
+
+	message := fmt.Sprintf("the handler passed to %s.%s() is unwrapped from %s.%s()", targetPkg, fnName, instanaPkg, wrapperName)
+
+	return changeNode(wrapper, "unwrap-handler", message, func() ast.Node {
+		call.Args[handlerIndex] = wrapper.Args[0]
+
+		return call.Args[handlerIndex]
+	}), true
+}
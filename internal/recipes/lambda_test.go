@@ -172,7 +172,7 @@ func assertLambdaInstrumentation(t *testing.T, examples map[string]struct {
 			require.NoError(t, err)
 
 			changed := recipes.NewLambda().
-				Instrument(token.NewFileSet(), node, example.TargetPkg, "__instanaSensor")
+				Instrument(token.NewFileSet(), nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 			assert.True(t, changed)
 
@@ -282,9 +282,30 @@ func main() {
 			require.NoError(t, err)
 
 			changed := recipes.NewLambda().
-				Instrument(token.NewFileSet(), node, example.TargetPkg, "__instanaSensor")
+				Instrument(token.NewFileSet(), nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 			assert.False(t, changed)
 		})
 	}
 }
+
+func TestLambda_UnexpectedArguments(t *testing.T) {
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, "test", `package main
+
+import (
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+func main() {
+	lambda.StartWithContext()
+}
+`, parser.AllErrors)
+	require.NoError(t, err)
+
+	edits := recipes.NewLambda().Instrument(fset, nil, node, "lambda", "__instanaSensor")
+
+	assert.False(t, edits.Changed())
+	require.Len(t, edits.Skipped(), 1)
+	assert.Equal(t, "unexpected-arguments", edits[0].Reason)
+}
@@ -6,10 +6,20 @@ import (
 	"github.com/instana/go-instana/internal/registry"
 	"go/ast"
 	"go/token"
+	"go/types"
 )
 
+// mongoPkg is the import path of the instrumented package
+const mongoPkg = "go.mongodb.org/mongo-driver/mongo"
+
+// mongoMethods are the functions of the instrumented package replaced with their Instana counterparts
+var mongoMethods = map[string]insertOption{
+	"Connect":   {sensorPosition: 1},
+	"NewClient": {},
+}
+
 func init() {
-	registry.Default.Register("go.mongodb.org/mongo-driver/mongo", NewMongo())
+	registry.Default.Register(mongoPkg, NewMongo())
 }
 
 func NewMongo() *Mongo {
@@ -27,10 +37,17 @@ func (recipe *Mongo) ImportPath() string {
 	return "github.com/instana/go-sensor/instrumentation/instamongo"
 }
 
+// Functions returns the functions of the instrumented package transformed by the recipe
+func (recipe *Mongo) Functions() []string {
+	return functionNames(mongoMethods)
+}
+
 // Instrument applies recipe to the ast Node
-func (recipe *Mongo) Instrument(fset *token.FileSet, f ast.Node, targetPkg, sensorVar string) (changed bool) {
-	return recipe.defaultRecipe.instrument(fset, f, targetPkg, sensorVar, recipe.InstanaPkg, recipe.ImportPath(), map[string]insertOption{
-		"Connect":   {sensorPosition: 1},
-		"NewClient": {},
-	})
+func (recipe *Mongo) Instrument(fset *token.FileSet, info *types.Info, f ast.Node, targetPkg, sensorVar string) registry.Edits {
+	return recipe.defaultRecipe.instrument(fset, info, f, mongoPkg, targetPkg, sensorVar, recipe.InstanaPkg, recipe.ImportPath(), mongoMethods)
+}
+
+// Remove reverts the changes made by Instrument()
+func (recipe *Mongo) Remove(fset *token.FileSet, info *types.Info, f ast.Node, targetPkg, instanaPkg, sensorVar string) registry.Edits {
+	return recipe.defaultRecipe.remove(fset, info, f, mongoPkg, targetPkg, sensorVar, instanaPkg, recipe.ImportPath(), mongoMethods)
 }
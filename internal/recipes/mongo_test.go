@@ -38,6 +38,12 @@ func TestMongoClientRecipe(t *testing.T) {
 			Expected:  `instamongo.NewClient(__instanaSensor, options.Client().ApplyURI("mongodb://localhost:27017"))`,
 			Changed:   true,
 		},
+		"Connect without arguments": {
+			TargetPkg: "mongo",
+			Code:      `mongo.Connect()`,
+			Expected:  `mongo.Connect()`,
+			Changed:   false,
+		},
 		"already instrumented NewClient": {
 			TargetPkg: "mongo",
 			Code:      `instamongo.NewClient(__instanaSensor, options.Client().ApplyURI("mongodb://localhost:27017"))`,
@@ -52,7 +58,7 @@ func TestMongoClientRecipe(t *testing.T) {
 			require.NoError(t, err)
 
 			changed := recipes.NewMongo().
-				Instrument(token.NewFileSet(), node, example.TargetPkg, "__instanaSensor")
+				Instrument(token.NewFileSet(), nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 			assert.Equal(t, example.Changed, changed)
 
@@ -6,10 +6,19 @@ import (
 	"github.com/instana/go-instana/internal/registry"
 	"go/ast"
 	"go/token"
+	"go/types"
 )
 
+// muxPkg is the import path of the instrumented package
+const muxPkg = "github.com/gorilla/mux"
+
+// muxMethods are the functions of the instrumented package replaced with their Instana counterparts
+var muxMethods = map[string]insertOption{
+	"NewRouter": {},
+}
+
 func init() {
-	registry.Default.Register("github.com/gorilla/mux", NewMux())
+	registry.Default.Register(muxPkg, NewMux())
 }
 
 // NewMux returns Mux recipe
@@ -28,9 +37,17 @@ func (recipe *Mux) ImportPath() string {
 	return "github.com/instana/go-sensor/instrumentation/instamux"
 }
 
+// Functions returns the functions of the instrumented package transformed by the recipe
+func (recipe *Mux) Functions() []string {
+	return functionNames(muxMethods)
+}
+
 // Instrument applies recipe to the ast Node
-func (recipe *Mux) Instrument(fset *token.FileSet, f ast.Node, targetPkg, sensorVar string) (changed bool) {
-	return recipe.defaultRecipe.instrument(fset, f, targetPkg, sensorVar, recipe.InstanaPkg, recipe.ImportPath(), map[string]insertOption{
-		"NewRouter": {},
-	})
+func (recipe *Mux) Instrument(fset *token.FileSet, info *types.Info, f ast.Node, targetPkg, sensorVar string) registry.Edits {
+	return recipe.defaultRecipe.instrument(fset, info, f, muxPkg, targetPkg, sensorVar, recipe.InstanaPkg, recipe.ImportPath(), muxMethods)
+}
+
+// Remove reverts the changes made by Instrument()
+func (recipe *Mux) Remove(fset *token.FileSet, info *types.Info, f ast.Node, targetPkg, instanaPkg, sensorVar string) registry.Edits {
+	return recipe.defaultRecipe.remove(fset, info, f, muxPkg, targetPkg, sensorVar, instanaPkg, recipe.ImportPath(), muxMethods)
 }
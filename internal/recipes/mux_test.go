@@ -93,7 +93,7 @@ func assertMuxInstrumentation(t *testing.T, examples map[string]struct {
 			require.NoError(t, err)
 
 			changed := recipes.NewMux().
-				Instrument(token.NewFileSet(), node, example.TargetPkg, "__instanaSensor")
+				Instrument(token.NewFileSet(), nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 			assert.True(t, changed)
 
@@ -241,7 +241,7 @@ func main() {
 			require.NoError(t, err)
 
 			changed := recipes.NewMux().
-				Instrument(token.NewFileSet(), node, example.TargetPkg, "__instanaSensor")
+				Instrument(token.NewFileSet(), nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 			assert.False(t, changed)
 
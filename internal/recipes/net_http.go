@@ -7,23 +7,51 @@ import (
 	"github.com/instana/go-instana/internal/registry"
 	"go/ast"
 	"go/token"
+	"go/types"
 
 	"golang.org/x/tools/go/ast/astutil"
 )
 
+// netHTTPPkg is the import path of the instrumented package
+const netHTTPPkg = "net/http"
+
 func init() {
-	registry.Default.Register("net/http", NewNetHTTP())
+	// the handlers are wrapped after web framework recipes have replaced the routers, so that a router passed
+	// to http.Handle is already instrumented
+	registry.Default.Register(registry.RecipeName(netHTTPPkg, "server"), NewNetHTTPServer(), registry.RunsAfter(ginPkg, echoPkg, muxPkg, httpRouterPkg))
+	registry.Default.Register(registry.RecipeName(netHTTPPkg, "client"), NewNetHTTPClient())
 }
 
+// NewNetHTTP returns the recipe instrumenting both net/http handlers and clients
 func NewNetHTTP() *NetHTTP {
 	return &NetHTTP{
 		InstanaPkg: "instana",
+		server:     true,
+		client:     true,
 	}
 }
 
+// NewNetHTTPServer returns the recipe instrumenting net/http handlers only
+func NewNetHTTPServer() *NetHTTP {
+	recipe := NewNetHTTP()
+	recipe.client = false
+
+	return recipe
+}
+
+// NewNetHTTPClient returns the recipe instrumenting net/http clients only
+func NewNetHTTPClient() *NetHTTP {
+	recipe := NewNetHTTP()
+	recipe.server = false
+
+	return recipe
+}
+
 // NetHTTP instruments net/http package with Instana
 type NetHTTP struct {
 	InstanaPkg string
+
+	server, client bool
 }
 
 // ImportPath returns instrumentation import path
@@ -31,32 +59,68 @@ func (recipe *NetHTTP) ImportPath() string {
 	return "github.com/instana/go-sensor"
 }
 
-// Instrument instruments net/http.HandleFunc and net/http.Handle calls as well as (http.Client).Transport
-func (recipe *NetHTTP) Instrument(fset *token.FileSet, node ast.Node, targetPkg, sensorVar string) (changed bool) {
-	astutil.Apply(node, func(c *astutil.Cursor) bool {
+// Functions returns the functions and methods of the instrumented package transformed by the recipe
+func (recipe *NetHTTP) Functions() []string {
+	var fns []string
+	if recipe.server {
+		fns = append(fns, "(*ServeMux).Handle", "(*ServeMux).HandleFunc", "Handle", "HandleFunc")
+	}
+
+	if recipe.client {
+		fns = append(fns, "Client{}")
+	}
+
+	return fns
+}
+
+// Instrument instruments net/http.HandleFunc and net/http.Handle calls, the same methods of *http.ServeMux as well as
+// (http.Client).Transport
+func (recipe *NetHTTP) Instrument(fset *token.FileSet, info *types.Info, node ast.Node, targetPkg, sensorVar string) (edits registry.Edits) {
+	apply(node, func(c *astutil.Cursor) bool {
 		return true
 	}, func(c *astutil.Cursor) bool {
 		switch node := c.Node().(type) {
 		case *ast.CallExpr:
-			changed = recipe.instrumentMethodCall(node, targetPkg, sensorVar) || changed
+			if recipe.server {
+				if e, ok := recipe.instrumentMethodCall(info, node, targetPkg, sensorVar); ok {
+					edits = append(edits, e)
+				}
+			}
+
+			if recipe.client && recipe.isNewClientCall(info, node, targetPkg) {
+				edits = append(edits, skipNode(node, "client-created-with-new", "http.Client created with new() cannot be instrumented, use &http.Client{} instead"))
+			}
+		case *ast.SelectorExpr:
+			if recipe.client && recipe.isDefaultClient(info, node, targetPkg) {
+				edits = append(edits, skipNode(node, "default-client", "http.DefaultClient cannot be instrumented, use &http.Client{} instead"))
+			}
 		case *ast.CompositeLit:
-			changed = recipe.instrumentCompositeLit(node, targetPkg, sensorVar) || changed
+			if recipe.client {
+				if e, ok := recipe.instrumentCompositeLit(info, node, targetPkg, sensorVar); ok {
+					edits = append(edits, e)
+				}
+			}
 		}
 
 		return true
 	})
 
-	return changed
+	if edits.Changed() {
+		addNamedImport(fset, node, recipe.InstanaPkg, recipe.ImportPath())
+	}
+
+	return edits
 }
 
-func (recipe *NetHTTP) instrumentMethodCall(call *ast.CallExpr, targetPkg, sensorVar string) bool {
-	pkgName, fnName, ok := extractFunctionName(call)
+func (recipe *NetHTTP) instrumentMethodCall(info *types.Info, call *ast.CallExpr, targetPkg, sensorVar string) (registry.Edit, bool) {
+	fnName, ok := packageFunctionName(info, call, netHTTPPkg, targetPkg)
 	if !ok {
-		return false
+		// (*http.ServeMux).Handle() and (*http.ServeMux).HandleFunc() calls can only be found using type information
+		fnName, ok = recipe.serveMuxMethodName(info, call)
 	}
 
-	if pkgName != targetPkg {
-		return false
+	if !ok || len(call.Args) != 2 {
+		return registry.Edit{}, false
 	}
 
 	switch fnName {
@@ -65,12 +129,14 @@ func (recipe *NetHTTP) instrumentMethodCall(call *ast.CallExpr, targetPkg, senso
 
 		// Double instrumentation check: handler is not an already instrumented http.HandlerFunc?
 		if _, ok := assertFunctionName(handler, recipe.InstanaPkg, "TracingHandlerFunc"); ok {
-			return false
+			return registry.Edit{}, false
 		}
 
-		recipe.instrumentHandleFunc(call, handler, sensorVar)
+		return changeNode(call, "wrap-handler", "the handler is wrapped with "+recipe.InstanaPkg+".TracingHandlerFunc()", func() ast.Node {
+			recipe.instrumentHandleFunc(call, handler, sensorVar)
 
-		return true
+			return call
+		}), true
 	case "Handle":
 		handler := call.Args[1]
 
@@ -78,23 +144,64 @@ func (recipe *NetHTTP) instrumentMethodCall(call *ast.CallExpr, targetPkg, senso
 		if call, ok := assertFunctionName(handler, targetPkg, "HandlerFunc"); ok {
 			if len(call.Args) > 0 {
 				if _, ok := assertFunctionName(call.Args[0], recipe.InstanaPkg, "TracingHandlerFunc"); ok {
-					return false
+					return registry.Edit{}, false
 				}
 			}
 		}
 
-		// Replace http.Handle with http.HandlerFunc, since instana.TracingHandleFunc() returns
-		// a function instead of http.Handler
-		call.Fun.(*ast.SelectorExpr).Sel.Name = "HandleFunc"
-		recipe.instrumentHandleFunc(call, &ast.SelectorExpr{
-			X:   handler,
-			Sel: ast.NewIdent("ServeHTTP"),
-		}, sensorVar)
-
-		return true
+		return changeNode(call, "wrap-handler", "the handler is wrapped with "+recipe.InstanaPkg+".TracingHandlerFunc()", func() ast.Node {
+			// Replace http.Handle with http.HandlerFunc, since instana.TracingHandleFunc() returns
+			// a function instead of http.Handler
+			call.Fun.(*ast.SelectorExpr).Sel.Name = "HandleFunc"
+			recipe.instrumentHandleFunc(call, &ast.SelectorExpr{
+				X:   handler,
+				Sel: ast.NewIdent("ServeHTTP"),
+			}, sensorVar)
+
+			return call
+		}), true
 	default:
+		return registry.Edit{}, false
+	}
+}
+
+// isNewClientCall returns whether the call creates http.Client with new()
+func (recipe *NetHTTP) isNewClientCall(info *types.Info, call *ast.CallExpr, targetPkg string) bool {
+	fn, ok := call.Fun.(*ast.Ident)
+	if !ok || fn.Name != "new" || len(call.Args) != 1 {
 		return false
 	}
+
+	if info != nil {
+		if obj, ok := info.Uses[fn]; ok {
+			if _, ok := obj.(*types.Builtin); !ok {
+				return false
+			}
+		}
+	}
+
+	name, ok := packageTypeName(info, call.Args[0], netHTTPPkg, targetPkg)
+
+	return ok && name == "Client"
+}
+
+// isDefaultClient returns whether `sel` refers to http.DefaultClient
+func (recipe *NetHTTP) isDefaultClient(info *types.Info, sel *ast.SelectorExpr, targetPkg string) bool {
+	return sel.Sel.Name == "DefaultClient" && isImportedPackage(info, sel.X, netHTTPPkg, targetPkg)
+}
+
+// serveMuxMethodName returns the name of the *http.ServeMux method called with `call`
+func (recipe *NetHTTP) serveMuxMethodName(info *types.Info, call *ast.CallExpr) (string, bool) {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return "", false
+	}
+
+	if pkgPath, typeName, ok := methodReceiverType(info, sel); !ok || pkgPath != netHTTPPkg || typeName != "ServeMux" {
+		return "", false
+	}
+
+	return sel.Sel.Name, true
 }
 
 func (recipe *NetHTTP) instrumentHandleFunc(call *ast.CallExpr, handler ast.Expr, sensorVar string) {
@@ -111,10 +218,10 @@ func (recipe *NetHTTP) instrumentHandleFunc(call *ast.CallExpr, handler ast.Expr
 	}
 }
 
-func (recipe *NetHTTP) instrumentCompositeLit(lit *ast.CompositeLit, targetPkg, sensorVar string) bool {
-	pkg, name, ok := extractSelectorPackageAndName(lit.Type)
-	if !ok || pkg != targetPkg {
-		return false
+func (recipe *NetHTTP) instrumentCompositeLit(info *types.Info, lit *ast.CompositeLit, targetPkg, sensorVar string) (registry.Edit, bool) {
+	name, ok := compositeLitTypeName(info, lit, netHTTPPkg, targetPkg)
+	if !ok {
+		return registry.Edit{}, false
 	}
 
 	switch name {
@@ -135,26 +242,30 @@ func (recipe *NetHTTP) instrumentCompositeLit(lit *ast.CompositeLit, targetPkg,
 				// Double instrumentation check: is the transport already wrapped?
 				if call, ok := kv.Value.(*ast.CallExpr); ok {
 					if pkg, name, ok := extractFunctionName(call); ok && pkg == recipe.InstanaPkg && name == "RoundTripper" {
-						return false
+						return registry.Edit{}, false
 					}
 				}
 
-				kv.Value = recipe.instrumentTransport(kv.Value, sensorVar)
+				return changeNode(kv.Value, "wrap-transport", "http.Client transport is wrapped with "+recipe.InstanaPkg+".RoundTripper()", func() ast.Node {
+					kv.Value = recipe.instrumentTransport(kv.Value, sensorVar)
 
-				return true
+					return kv.Value
+				}), true
 			}
 		}
 
 		// Initialize (http.Client).Transport otherwise with instana.RoundTripper
-		lit.Elts = append(lit.Elts, &ast.KeyValueExpr{
-			Key:   ast.NewIdent("Transport"),
-			Value: recipe.instrumentTransport(ast.NewIdent("nil"), sensorVar),
-		})
-
-		return true
+		return changeNode(lit, "add-transport", "http.Client transport is initialized with "+recipe.InstanaPkg+".RoundTripper()", func() ast.Node {
+			lit.Elts = append(lit.Elts, &ast.KeyValueExpr{
+				Key:   ast.NewIdent("Transport"),
+				Value: recipe.instrumentTransport(ast.NewIdent("nil"), sensorVar),
+			})
+
+			return lit
+		}), true
 	}
 
-	return false
+	return registry.Edit{}, false
 }
 
 func (recipe *NetHTTP) instrumentTransport(orig ast.Expr, sensorVar string) ast.Expr {
@@ -180,3 +291,127 @@ func assertFunctionName(node ast.Expr, pkg, fn string) (*ast.CallExpr, bool) {
 
 	return call, ok && fnPkg == pkg && fnName == fn
 }
+
+// Remove unwraps the handlers passed to net/http.HandleFunc and the same method of *http.ServeMux, as well as
+// the (http.Client).Transport wrapped by Instrument()
+func (recipe *NetHTTP) Remove(fset *token.FileSet, info *types.Info, node ast.Node, targetPkg, instanaPkg, sensorVar string) (edits registry.Edits) {
+	apply(node, func(c *astutil.Cursor) bool {
+		return true
+	}, func(c *astutil.Cursor) bool {
+		switch node := c.Node().(type) {
+		case *ast.CallExpr:
+			if recipe.server {
+				if e, ok := recipe.removeMethodCall(info, node, targetPkg, instanaPkg, sensorVar); ok {
+					edits = append(edits, e)
+				}
+			}
+		case *ast.CompositeLit:
+			if recipe.client {
+				if e, ok := recipe.removeCompositeLit(info, node, targetPkg, instanaPkg, sensorVar); ok {
+					edits = append(edits, e)
+				}
+			}
+		}
+
+		return true
+	})
+
+	return edits
+}
+
+func (recipe *NetHTTP) removeMethodCall(info *types.Info, call *ast.CallExpr, targetPkg, instanaPkg, sensorVar string) (registry.Edit, bool) {
+	fnName, ok := packageFunctionName(info, call, netHTTPPkg, targetPkg)
+	if !ok {
+		fnName, ok = recipe.serveMuxMethodName(info, call)
+	}
+
+	if !ok || fnName != "HandleFunc" || len(call.Args) != 2 {
+		return registry.Edit{}, false
+	}
+
+	wrapper, ok := call.Args[1].(*ast.CallExpr)
+	if !ok || len(wrapper.Args) != 3 || !isSensorVar(wrapper.Args[0], sensorVar) {
+		return registry.Edit{}, false
+	}
+
+	if fnName, ok := packageFunctionName(info, wrapper, recipe.ImportPath(), instanaPkg); !ok || fnName != "TracingHandlerFunc" {
+		return registry.Edit{}, false
+	}
+
+	handler := wrapper.Args[2]
+
+	// http.Handle() calls are replaced with http.HandleFunc() using the ServeHTTP method of the handler
+	if sel, ok := handler.(*ast.SelectorExpr); ok && sel.Sel.Name == "ServeHTTP" && recipe.isHandler(info, sel.X) {
+		return changeNode(call, "unwrap-handler", "the handler is unwrapped from "+instanaPkg+".TracingHandlerFunc()", func() ast.Node {
+			call.Fun.(*ast.SelectorExpr).Sel.Name = "Handle"
+			call.Args[1] = sel.X
+
+			return call
+		}), true
+	}
+
+	return changeNode(call, "unwrap-handler", "the handler is unwrapped from "+instanaPkg+".TracingHandlerFunc()", func() ast.Node {
+		call.Args[1] = handler
+
+		return call
+	}), true
+}
+
+// isHandler returns whether the expression implements http.Handler. Without type information any expression
+// having the ServeHTTP method is assumed to be a handler.
+func (recipe *NetHTTP) isHandler(info *types.Info, expr ast.Expr) bool {
+	if info == nil {
+		return true
+	}
+
+	t := info.TypeOf(expr)
+	if t == nil {
+		return true
+	}
+
+	// the method set of a non-pointer type does not include the methods with pointer receivers
+	return types.NewMethodSet(t).Lookup(nil, "ServeHTTP") != nil
+}
+
+func (recipe *NetHTTP) removeCompositeLit(info *types.Info, lit *ast.CompositeLit, targetPkg, instanaPkg, sensorVar string) (registry.Edit, bool) {
+	if name, ok := compositeLitTypeName(info, lit, netHTTPPkg, targetPkg); !ok || name != "Client" {
+		return registry.Edit{}, false
+	}
+
+	for i, el := range lit.Elts {
+		kv, ok := el.(*ast.KeyValueExpr)
+		if !ok {
+			continue
+		}
+
+		if key, ok := kv.Key.(*ast.Ident); !ok || key.Name != "Transport" {
+			continue
+		}
+
+		call, ok := kv.Value.(*ast.CallExpr)
+		if !ok || len(call.Args) != 2 || !isSensorVar(call.Args[0], sensorVar) {
+			return registry.Edit{}, false
+		}
+
+		if fnName, ok := packageFunctionName(info, call, recipe.ImportPath(), instanaPkg); !ok || fnName != "RoundTripper" {
+			return registry.Edit{}, false
+		}
+
+		// the transport initialized by Instrument() is removed, otherwise the original one is restored
+		if ident, ok := call.Args[1].(*ast.Ident); ok && ident.Name == "nil" {
+			return changeNode(lit, "remove-transport", "http.Client transport initialized with "+instanaPkg+".RoundTripper() is removed", func() ast.Node {
+				lit.Elts = append(lit.Elts[:i:i], lit.Elts[i+1:]...)
+
+				return lit
+			}), true
+		}
+
+		return changeNode(kv.Value, "unwrap-transport", "http.Client transport is unwrapped from "+instanaPkg+".RoundTripper()", func() ast.Node {
+			kv.Value = call.Args[1]
+
+			return kv.Value
+		}), true
+	}
+
+	return registry.Edit{}, false
+}
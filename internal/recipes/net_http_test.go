@@ -63,7 +63,7 @@ func TestNetHTTPRecipe(t *testing.T) {
 			require.NoError(t, err)
 
 			changed := recipes.NewNetHTTP().
-				Instrument(nil, node, example.TargetPkg, "__instanaSensor")
+				Instrument(nil, nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 			assert.True(t, changed)
 
@@ -88,7 +88,7 @@ func TestNetHTTPRecipe_Ignore(t *testing.T) {
 			require.NoError(t, err)
 
 			changed := recipes.NewNetHTTP().
-				Instrument(nil, node, "http", "__instanaSensor")
+				Instrument(nil, nil, node, "http", "__instanaSensor").Changed()
 
 			require.False(t, changed)
 
@@ -117,7 +117,7 @@ func TestNetHTTPRecipe_InstrumentedCode(t *testing.T) {
 			require.NoError(t, err)
 
 			changed := recipes.NewNetHTTP().
-				Instrument(nil, node, "http", "__instanaSensor")
+				Instrument(nil, nil, node, "http", "__instanaSensor").Changed()
 
 			assert.False(t, changed)
 
@@ -128,3 +128,68 @@ func TestNetHTTPRecipe_InstrumentedCode(t *testing.T) {
 		})
 	}
 }
+
+func TestNetHTTPRecipe_SubRecipes(t *testing.T) {
+	examples := map[string]struct {
+		Recipe          *recipes.NetHTTP
+		Handler, Client bool
+	}{
+		"server":   {Recipe: recipes.NewNetHTTPServer(), Handler: true},
+		"client":   {Recipe: recipes.NewNetHTTPClient(), Client: true},
+		"combined": {Recipe: recipes.NewNetHTTP(), Handler: true, Client: true},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			handler, err := parser.ParseExpr(`http.HandleFunc("/", http.NotFound)`)
+			require.NoError(t, err)
+
+			client, err := parser.ParseExpr(`http.Client{}`)
+			require.NoError(t, err)
+
+			assert.Equal(t, example.Handler, example.Recipe.Instrument(nil, nil, handler, "http", "__instanaSensor").Changed())
+			assert.Equal(t, example.Client, example.Recipe.Instrument(nil, nil, client, "http", "__instanaSensor").Changed())
+		})
+	}
+}
+
+func TestNetHTTPRecipe_Edits(t *testing.T) {
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, "test.go", `package main
+
+func main() {
+	http.HandleFunc("/", handler)
+	c1 := &http.Client{Timeout: time.Second}
+	c2 := new(http.Client)
+	http.DefaultClient.Get("https://example.com")
+}
+`, 0)
+	require.NoError(t, err)
+
+	edits := recipes.NewNetHTTP().Instrument(fset, nil, node, "http", "__instanaSensor")
+	require.Len(t, edits, 4)
+
+	assert.Equal(t, "wrap-handler", edits[0].Reason)
+	assert.Equal(t, `http.HandleFunc("/", handler)`, edits[0].Original)
+	assert.Equal(t, `http.HandleFunc("/", instana.TracingHandlerFunc(__instanaSensor, "/", handler))`, edits[0].Replacement)
+	assert.False(t, edits[0].Skipped)
+
+	assert.Equal(t, "add-transport", edits[1].Reason)
+	assert.Equal(t, `http.Client{Timeout: time.Second}`, edits[1].Original)
+	assert.Equal(t, `http.Client{Timeout: time.Second, Transport: instana.RoundTripper(__instanaSensor, nil)}`, edits[1].Replacement)
+	assert.False(t, edits[1].Skipped)
+
+	assert.Equal(t, "client-created-with-new", edits[2].Reason)
+	assert.Equal(t, `new(http.Client)`, edits[2].Original)
+	assert.Empty(t, edits[2].Replacement)
+	assert.True(t, edits[2].Skipped)
+
+	assert.Equal(t, "default-client", edits[3].Reason)
+	assert.Equal(t, `http.DefaultClient`, edits[3].Original)
+	assert.True(t, edits[3].Skipped)
+
+	for _, e := range edits {
+		assert.True(t, e.Pos.IsValid())
+		assert.NotEmpty(t, e.Message)
+	}
+}
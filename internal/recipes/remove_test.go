@@ -0,0 +1,385 @@
+// (c) Copyright IBM Corp. 2022
+
+package recipes_test
+
+import (
+	"bytes"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/instana/go-instana/internal/recipes"
+	"github.com/instana/go-instana/internal/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemove(t *testing.T) {
+	examples := map[string]struct {
+		Recipe     registry.Remover
+		TargetPkg  string
+		InstanaPkg string
+		Code       string
+		Expected   string
+	}{
+		"gin": {
+			Recipe:     recipes.NewGin(),
+			TargetPkg:  "gin",
+			InstanaPkg: "instagin",
+			Code: `package main
+
+import instagin "github.com/instana/go-sensor/instrumentation/instagin"
+
+func main() {
+	a := instagin.New(__instanaSensor)
+	b := instagin.Default(__instanaSensor)
+	c := instagin.New(sensor)
+}
+`,
+			Expected: `package main
+
+import (
+	"github.com/gin-gonic/gin"
+	instagin "github.com/instana/go-sensor/instrumentation/instagin"
+)
+
+func main() {
+	a := gin.New()
+	b := gin.Default()
+	c := instagin.New(sensor)
+}
+`,
+		},
+		"mongo": {
+			Recipe:     recipes.NewMongo(),
+			TargetPkg:  "mongo",
+			InstanaPkg: "instamongo",
+			Code: `package main
+
+import (
+	"go.mongodb.org/mongo-driver/mongo"
+	instamongo "github.com/instana/go-sensor/instrumentation/instamongo"
+)
+
+func main() {
+	client, err := instamongo.Connect(ctx, __instanaSensor, opts1, opts2)
+}
+`,
+			Expected: `package main
+
+import (
+	instamongo "github.com/instana/go-sensor/instrumentation/instamongo"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func main() {
+	client, err := mongo.Connect(ctx, opts1, opts2)
+}
+`,
+		},
+		"database/sql": {
+			Recipe:     recipes.NewDatabaseSQL(),
+			TargetPkg:  "db",
+			InstanaPkg: "instana",
+			Code: `package main
+
+import (
+	db "database/sql"
+	instana "github.com/instana/go-sensor"
+)
+
+func main() {
+	db, err := instana.SQLInstrumentAndOpen(__instanaSensor, "postgres", "")
+}
+`,
+			Expected: `package main
+
+import (
+	db "database/sql"
+	instana "github.com/instana/go-sensor"
+)
+
+func main() {
+	db, err := db.Open("postgres", "")
+}
+`,
+		},
+		"httprouter": {
+			Recipe:     recipes.NewHttpRouter(),
+			TargetPkg:  "httprouter",
+			InstanaPkg: "instahttprouter",
+			Code: `package main
+
+import instahttprouter "github.com/instana/go-sensor/instrumentation/instahttprouter"
+
+var router *instahttprouter.WrappedRouter
+
+func main() {
+	router = instahttprouter.Wrap(httprouter.New(), __instanaSensor)
+}
+`,
+			Expected: `package main
+
+import (
+	instahttprouter "github.com/instana/go-sensor/instrumentation/instahttprouter"
+	"github.com/julienschmidt/httprouter"
+)
+
+var router *httprouter.Router
+
+func main() {
+	router = httprouter.New()
+}
+`,
+		},
+		"net/http": {
+			Recipe:     recipes.NewNetHTTP(),
+			TargetPkg:  "http",
+			InstanaPkg: "instana",
+			Code: `package main
+
+import (
+	"net/http"
+
+	instana "github.com/instana/go-sensor"
+)
+
+func main() {
+	http.HandleFunc("/", instana.TracingHandlerFunc(__instanaSensor, "/", handler))
+	http.HandleFunc("/mux", instana.TracingHandlerFunc(__instanaSensor, "/mux", mux.ServeHTTP))
+
+	c1 := &http.Client{Transport: instana.RoundTripper(__instanaSensor, nil)}
+	c2 := &http.Client{Timeout: 1, Transport: instana.RoundTripper(__instanaSensor, transport)}
+}
+`,
+			Expected: `package main
+
+import (
+	"net/http"
+
+	instana "github.com/instana/go-sensor"
+)
+
+func main() {
+	http.HandleFunc("/", handler)
+	http.Handle("/mux", mux)
+
+	c1 := &http.Client{}
+	c2 := &http.Client{Timeout: 1, Transport: transport}
+}
+`,
+		},
+		"grpc": {
+			Recipe:     recipes.NewGRPC(),
+			TargetPkg:  "grpc",
+			InstanaPkg: "instagrpc",
+			Code: `package main
+
+import (
+	instagrpc "github.com/instana/go-sensor/instrumentation/instagrpc"
+	"google.golang.org/grpc"
+)
+
+func main() {
+	srv := grpc.NewServer(grpc.ChainStreamInterceptor(instagrpc.StreamServerInterceptor(__instanaSensor)), grpc.ChainUnaryInterceptor(instagrpc.UnaryServerInterceptor(__instanaSensor)), opt)
+	conn, err := grpc.Dial(target, grpc.WithChainStreamInterceptor(instagrpc.StreamClientInterceptor(__instanaSensor)), grpc.WithChainUnaryInterceptor(instagrpc.UnaryClientInterceptor(__instanaSensor)))
+}
+`,
+			Expected: `package main
+
+import (
+	instagrpc "github.com/instana/go-sensor/instrumentation/instagrpc"
+	"google.golang.org/grpc"
+)
+
+func main() {
+	srv := grpc.NewServer(opt)
+	conn, err := grpc.Dial(target)
+}
+`,
+		},
+		"lambda": {
+			Recipe:     recipes.NewLambda(),
+			TargetPkg:  "lambda",
+			InstanaPkg: "instalambda",
+			Code: `package main
+
+import (
+	"github.com/aws/aws-lambda-go/lambda"
+	instalambda "github.com/instana/go-sensor/instrumentation/instalambda"
+)
+
+func main() {
+	lambda.Start(instalambda.NewHandler(handler, __instanaSensor))
+	lambda.StartHandlerWithContext(ctx, instalambda.WrapHandler(h, __instanaSensor))
+}
+`,
+			Expected: `package main
+
+import (
+	"github.com/aws/aws-lambda-go/lambda"
+	instalambda "github.com/instana/go-sensor/instrumentation/instalambda"
+)
+
+func main() {
+	lambda.Start(handler)
+	lambda.StartHandlerWithContext(ctx, h)
+}
+`,
+		},
+		"sarama": {
+			Recipe:     recipes.NewSarama(),
+			TargetPkg:  "sarama",
+			InstanaPkg: "instasarama",
+			Code: `package main
+
+import (
+	"context"
+
+	"github.com/Shopify/sarama"
+	instasarama "github.com/instana/go-sensor/instrumentation/instasarama"
+)
+
+func send(ctx context.Context) {
+	producer, _ := instasarama.NewSyncProducer(addrs, config, __instanaSensor)
+	producer.SendMessage(instasarama.ProducerMessageWithSpanFromContext(ctx, &sarama.ProducerMessage{}))
+}
+`,
+			Expected: `package main
+
+import (
+	"context"
+
+	"github.com/Shopify/sarama"
+	instasarama "github.com/instana/go-sensor/instrumentation/instasarama"
+)
+
+func send(ctx context.Context) {
+	producer, _ := sarama.NewSyncProducer(addrs, config)
+	producer.SendMessage(&sarama.ProducerMessage{})
+}
+`,
+		},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			fset := token.NewFileSet()
+			node, err := parser.ParseFile(fset, "test", example.Code, parser.AllErrors)
+			require.NoError(t, err)
+
+			edits := example.Recipe.Remove(fset, nil, node, example.TargetPkg, example.InstanaPkg, "__instanaSensor")
+			assert.True(t, edits.Changed())
+
+			buf := bytes.NewBuffer(nil)
+			require.NoError(t, format.Node(buf, fset, node))
+
+			assert.Equal(t, example.Expected, buf.String())
+		})
+	}
+}
+
+func TestRemove_RoundTrip(t *testing.T) {
+	code := `package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/julienschmidt/httprouter"
+)
+
+func main() {
+	engine := gin.Default()
+	router := httprouter.New()
+
+	http.Handle("/gin", engine)
+	http.Handle("/router", router)
+
+	client := &http.Client{}
+	client.Get("https://example.com")
+}
+`
+
+	examples := map[string]struct {
+		Recipe     registry.Recipe
+		TargetPkg  string
+		InstanaPkg string
+	}{
+		"gin":        {recipes.NewGin(), "gin", "instagin"},
+		"httprouter": {recipes.NewHttpRouter(), "httprouter", "instahttprouter"},
+		"net/http":   {recipes.NewNetHTTP(), "http", "instana"},
+	}
+
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, "test", code, parser.AllErrors)
+	require.NoError(t, err)
+
+	order := []string{"gin", "httprouter", "net/http"}
+	for _, name := range order {
+		require.True(t, examples[name].Recipe.Instrument(fset, nil, node, examples[name].TargetPkg, "__instanaSensor").Changed(), name)
+	}
+
+	for i := len(order) - 1; i >= 0; i-- {
+		example := examples[order[i]]
+		remover := example.Recipe.(registry.Remover)
+
+		require.True(t, remover.Remove(fset, nil, node, example.TargetPkg, example.InstanaPkg, "__instanaSensor").Changed(), order[i])
+	}
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, format.Node(buf, fset, node))
+
+	assert.Equal(t, `package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	instana "github.com/instana/go-sensor"
+	instagin "github.com/instana/go-sensor/instrumentation/instagin"
+	instahttprouter "github.com/instana/go-sensor/instrumentation/instahttprouter"
+	"github.com/julienschmidt/httprouter"
+)
+
+func main() {
+	engine := gin.Default()
+	router := httprouter.New()
+
+	http.Handle("/gin", engine)
+	http.Handle("/router", router)
+
+	client := &http.Client{}
+	client.Get("https://example.com")
+}
+`, buf.String())
+}
+
+func TestRemove_NotInstrumented(t *testing.T) {
+	code := `package main
+
+import (
+	"net/http"
+
+	instana "github.com/instana/go-sensor"
+)
+
+func main() {
+	http.HandleFunc("/", instana.TracingHandlerFunc(sensor, "/", handler))
+
+	c := &http.Client{Transport: instana.RoundTripper(sensor, nil)}
+}
+`
+
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, "test", code, parser.AllErrors)
+	require.NoError(t, err)
+
+	assert.Empty(t, recipes.NewNetHTTP().Remove(fset, nil, node, "http", "instana", "__instanaSensor"))
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, format.Node(buf, fset, node))
+
+	assert.Equal(t, code, buf.String(), "the code not produced by the recipe is expected to be left intact")
+}
@@ -5,26 +5,63 @@ package recipes
 import (
 	"fmt"
 	"github.com/instana/go-instana/internal/registry"
-	"github.com/rs/zerolog/log"
 	"go/ast"
 	"go/token"
+	"go/types"
 	"golang.org/x/tools/go/ast/astutil"
 	"strings"
 )
 
+// saramaPkg is the import path of the instrumented package
+const saramaPkg = "github.com/Shopify/sarama"
+
+// saramaConstructorsRecipe is the name of the recipe replacing sarama constructors
+var saramaConstructorsRecipe = registry.RecipeName(saramaPkg, "constructors")
+
+// saramaMethods are the functions of the instrumented package replaced with their Instana counterparts
+var saramaMethods = map[string]insertOption{
+	"NewAsyncProducer":           {sensorPosition: lastInsertPosition},
+	"NewAsyncProducerFromClient": {sensorPosition: lastInsertPosition},
+	"NewConsumer":                {sensorPosition: lastInsertPosition},
+	"NewConsumerFromClient":      {sensorPosition: lastInsertPosition},
+	"NewSyncProducer":            {sensorPosition: lastInsertPosition},
+	"NewSyncProducerFromClient":  {sensorPosition: lastInsertPosition},
+	"NewConsumerGroup":           {sensorPosition: lastInsertPosition},
+	"NewConsumerGroupFromClient": {sensorPosition: lastInsertPosition},
+}
+
 func init() {
-	registry.Default.Register("github.com/Shopify/sarama", NewSarama())
+	registry.Default.Register(saramaConstructorsRecipe, NewSaramaConstructors())
+	registry.Default.Register(registry.RecipeName(saramaPkg, "producer-context"), NewSaramaProducerContext(), registry.RunsAfter(saramaConstructorsRecipe))
 }
 
 // NewSarama returns Sarama recipe
 func NewSarama() *Sarama {
-	return &Sarama{InstanaPkg: "instasarama", defaultRecipe: defaultRecipe{}}
+	return &Sarama{InstanaPkg: "instasarama", defaultRecipe: defaultRecipe{}, constructors: true, producerContext: true}
+}
+
+// NewSaramaConstructors returns the recipe replacing sarama producer and consumer constructors only
+func NewSaramaConstructors() *Sarama {
+	recipe := NewSarama()
+	recipe.producerContext = false
+
+	return recipe
+}
+
+// NewSaramaProducerContext returns the recipe propagating the trace context to produced messages only
+func NewSaramaProducerContext() *Sarama {
+	recipe := NewSarama()
+	recipe.constructors = false
+
+	return recipe
 }
 
 // Sarama instruments github.com/Shopify/sarama package with Instana
 type Sarama struct {
 	InstanaPkg    string
 	defaultRecipe defaultRecipe
+
+	constructors, producerContext bool
 }
 
 // ImportPath returns instrumentation import path
@@ -32,33 +69,81 @@ func (recipe *Sarama) ImportPath() string {
 	return "github.com/instana/go-sensor/instrumentation/instasarama"
 }
 
+// Functions returns the functions and methods of the instrumented package transformed by the recipe
+func (recipe *Sarama) Functions() []string {
+	var fns []string
+	if recipe.producerContext {
+		fns = append(fns, "(SyncProducer).SendMessage")
+	}
+
+	if recipe.constructors {
+		fns = append(fns, functionNames(saramaMethods)...)
+	}
+
+	return fns
+}
+
 // Instrument applies recipe to the ast Node
-func (recipe *Sarama) Instrument(fset *token.FileSet, f ast.Node, targetPkg, sensorVar string) (changed bool) {
-	m := map[string]insertOption{
-		"NewAsyncProducer":           {sensorPosition: lastInsertPosition},
-		"NewAsyncProducerFromClient": {sensorPosition: lastInsertPosition},
-		"NewConsumer":                {sensorPosition: lastInsertPosition},
-		"NewConsumerFromClient":      {sensorPosition: lastInsertPosition},
-		"NewSyncProducer":            {sensorPosition: lastInsertPosition},
-		"NewSyncProducerFromClient":  {sensorPosition: lastInsertPosition},
-		"NewConsumerGroup":           {sensorPosition: lastInsertPosition},
-		"NewConsumerGroupFromClient": {sensorPosition: lastInsertPosition},
+func (recipe *Sarama) Instrument(fset *token.FileSet, info *types.Info, f ast.Node, targetPkg, sensorVar string) (edits registry.Edits) {
+	if recipe.constructors {
+		edits = recipe.defaultRecipe.instrument(fset, info, f, saramaPkg, targetPkg, sensorVar, recipe.InstanaPkg, recipe.ImportPath(), saramaMethods)
+	}
+
+	if recipe.producerContext {
+		edits = append(edits, recipe.instrumentMessagesAndSending(fset, info, f, targetPkg)...)
 	}
-	changed = recipe.defaultRecipe.instrument(fset, f, targetPkg, sensorVar, recipe.InstanaPkg, recipe.ImportPath(), m)
-	changed = recipe.instrumentMessagesAndSending(fset, f) || changed
 
-	if changed {
+	if edits.Changed() {
 		addNamedImport(fset, f, recipe.InstanaPkg, recipe.ImportPath())
 	}
 
-	return changed
+	return edits
+}
+
+// Remove reverts the changes made by Instrument()
+func (recipe *Sarama) Remove(fset *token.FileSet, info *types.Info, f ast.Node, targetPkg, instanaPkg, sensorVar string) (edits registry.Edits) {
+	if recipe.constructors {
+		edits = recipe.defaultRecipe.remove(fset, info, f, saramaPkg, targetPkg, sensorVar, instanaPkg, recipe.ImportPath(), saramaMethods)
+	}
+
+	if recipe.producerContext {
+		edits = append(edits, recipe.removeMessageWrappers(info, f, instanaPkg)...)
+	}
+
+	return edits
+}
+
+// removeMessageWrappers replaces "instasarama.ProducerMessageWithSpanFromContext(ctx, msg)" calls with the message
+func (recipe *Sarama) removeMessageWrappers(info *types.Info, f ast.Node, instanaPkg string) (edits registry.Edits) {
+	apply(f, func(cursor *astutil.Cursor) bool {
+		return true
+	}, func(cursor *astutil.Cursor) bool {
+		call, ok := cursor.Node().(*ast.CallExpr)
+		if !ok || len(call.Args) != 2 {
+			return true
+		}
+
+		if fnName, ok := packageFunctionName(info, call, recipe.ImportPath(), instanaPkg); !ok || fnName != "ProducerMessageWithSpanFromContext" {
+			return true
+		}
+
+		edits = append(edits, changeNode(call, "unwrap-message", "the message is unwrapped from "+instanaPkg+".ProducerMessageWithSpanFromContext()", func() ast.Node {
+			cursor.Replace(call.Args[1])
+
+			return call.Args[1]
+		}))
+
+		return true
+	})
+
+	return edits
 }
 
 // instrumentMessagesAndSending iterates over ast tree and track current function declaration. If the current function
 // has a "context.Context" type, it tries to instrument "sarama.ProducerMessage" type creation and/or "SendMessage" call
-// if that is done by "sarama.SyncProducer". Important: this auto instrumentation assumes that sarama library
-// ("github.com/Shopify/sarama") is imported as "sarama" and "context" is not imported via "_" or ".".
-func (recipe *Sarama) instrumentMessagesAndSending(fset *token.FileSet, f ast.Node) (changed bool) {
+// if that is done by "sarama.SyncProducer". Important: if there is no type information available, this auto
+// instrumentation assumes that "context" is not imported via "_" or ".".
+func (recipe *Sarama) instrumentMessagesAndSending(fset *token.FileSet, info *types.Info, f ast.Node, targetPkg string) (edits registry.Edits) {
 	// stack to store current function declaration
 	funcDeclStack := &stack[ast.FuncDecl]{}
 
@@ -66,14 +151,13 @@ func (recipe *Sarama) instrumentMessagesAndSending(fset *token.FileSet, f ast.No
 		// try to get context variable name
 		contextImportName, err := GetPackageImportName(fset, v, "context")
 
-		// if no proper context import found
-		if err != nil {
-			log.Debug().Msgf("sarama instrumentation : %s", err.Error())
-			return false
+		// the calls can't be instrumented if the context package is imported, but can't be referred to by its name
+		if err != nil && importsPackage(v, "context") {
+			return nil
 		}
 
 		// traverse a tree
-		astutil.Apply(f, func(cursor *astutil.Cursor) bool {
+		apply(f, func(cursor *astutil.Cursor) bool {
 
 			// stop checking children
 			if cursor.Node() == nil {
@@ -88,10 +172,14 @@ func (recipe *Sarama) instrumentMessagesAndSending(fset *token.FileSet, f ast.No
 			}
 
 			// try to instrument "sarama.ProducerMessage" type creation
-			changed = recipe.tryToInstrumentProducerMessageCreation(cursor, contextImportName, funcDeclStack) || changed
+			if e, ok := recipe.tryToInstrumentProducerMessageCreation(info, cursor, targetPkg, contextImportName, funcDeclStack); ok {
+				edits = append(edits, e)
+			}
 
 			// try to instrument "SendMessage" call
-			changed = recipe.tryToInstrumentSendingMessage(cursor, contextImportName, funcDeclStack) || changed
+			if e, ok := recipe.tryToInstrumentSendingMessage(info, cursor, targetPkg, contextImportName, funcDeclStack); ok {
+				edits = append(edits, e)
+			}
 
 			return true
 		}, func(cursor *astutil.Cursor) bool {
@@ -108,52 +196,44 @@ func (recipe *Sarama) instrumentMessagesAndSending(fset *token.FileSet, f ast.No
 		})
 	}
 
-	return changed
+	return edits
 }
 
 // tryToInstrumentSendingMessage instruments first and only argument of the "sarama.SyncProducer" "SendMessage" call
-func (recipe *Sarama) tryToInstrumentSendingMessage(cursor *astutil.Cursor, contextImportName string, funcDeclStack *stack[ast.FuncDecl]) bool {
+func (recipe *Sarama) tryToInstrumentSendingMessage(info *types.Info, cursor *astutil.Cursor, targetPkg, contextImportName string, funcDeclStack *stack[ast.FuncDecl]) (registry.Edit, bool) {
 	if callExpr, ok := (cursor.Node()).(*ast.CallExpr); ok {
-		if recipe.isItCorrectSendMessageCall(callExpr) {
+		if recipe.isItCorrectSendMessageCall(info, callExpr, targetPkg) {
 			if len(callExpr.Args) == 1 {
 				// check if already instrumented
-				if ce, ok := callExpr.Args[0].(*ast.CallExpr); ok {
-					if recipe.getObjType(ce) == "instasarama.ProducerMessageWithSpanFromContext" {
-						return false
-					}
+				if recipe.isProducerMessageWithSpanFromContextCall(info, callExpr.Args[0]) {
+					return registry.Edit{}, false
 				}
 
-				// check the name of the context variable name in the function declaration
-				if ctxName, ok := recipe.tryGetContextVariableNameInTheFunctionDeclaration(contextImportName, funcDeclStack.Top()); ok {
-					callExpr.Args[0] = &ast.CallExpr{
-						Fun: &ast.SelectorExpr{
-							X:   &ast.Ident{Name: "instasarama"},
-							Sel: &ast.Ident{Name: "ProducerMessageWithSpanFromContext"},
-						},
-						Args: []ast.Expr{
-							&ast.Ident{Name: ctxName},
-							callExpr.Args[0],
-						},
-					}
-
-					return true
-				}
+				return recipe.wrapWithContext(info, contextImportName, funcDeclStack.Top(), callExpr.Args[0], func(wrapped ast.Expr) {
+					callExpr.Args[0] = wrapped
+				}), true
 			}
 		}
 	}
 
-	return false
+	return registry.Edit{}, false
 }
 
 // isItCorrectSendMessageCall checks if current call is "SendMessage" and belongs to the kafka publishing.
-// It assumes that instrumentation and sarama library were imported with their default names.
+// If there is no type information available, it assumes that instrumentation was imported with its default name.
 // It does not support async publisher.
-func (recipe *Sarama) isItCorrectSendMessageCall(callExpr *ast.CallExpr) bool {
+func (recipe *Sarama) isItCorrectSendMessageCall(info *types.Info, callExpr *ast.CallExpr, targetPkg string) bool {
 	if selExpr, ok := (callExpr.Fun).(*ast.SelectorExpr); ok {
 		if selExpr.Sel.Name == "SendMessage" {
+			// the receiver can be any expression of sarama.SyncProducer type, including interface-typed variables,
+			// struct fields and values returned by function calls
+			if pkgPath, typeName, ok := methodReceiverType(info, selExpr); ok {
+				return (pkgPath == saramaPkg || pkgPath == recipe.ImportPath()) && typeName == "SyncProducer"
+			}
+
 			if ident, ok := selExpr.X.(*ast.Ident); ok {
-				instasaramaPrefix := "instasarama."
-				saramaPrefix := "sarama."
+				instasaramaPrefix := recipe.InstanaPkg + "."
+				saramaPrefix := targetPkg + "."
 
 				//"sarama.AsyncProducer" doesn't have a SendMessage method
 				saramaProducerTypesAndConstructors := map[string]struct{}{
@@ -213,69 +293,86 @@ func (recipe *Sarama) getObjType(node any) string {
 
 // tryToInstrumentProducerMessageCreation wraps "&sarama.ProducerMessage{...}"
 // with "instasarama.ProducerMessageWithSpanFromContext"
-func (recipe *Sarama) tryToInstrumentProducerMessageCreation(cursor *astutil.Cursor, contextImportName string, funcDeclStack *stack[ast.FuncDecl]) bool {
+func (recipe *Sarama) tryToInstrumentProducerMessageCreation(info *types.Info, cursor *astutil.Cursor, targetPkg, contextImportName string, funcDeclStack *stack[ast.FuncDecl]) (registry.Edit, bool) {
 	// check if it is unary expression that creates "&sarama.ProducerMessage"
-	if unaryExp := recipe.isProducerMessageCreation(cursor.Node()); unaryExp != nil {
+	if unaryExp := recipe.isProducerMessageCreation(info, cursor.Node(), targetPkg); unaryExp != nil {
+		// check if is already instrumented
+		if parent, ok := cursor.Parent().(ast.Expr); ok && recipe.isProducerMessageWithSpanFromContextCall(info, parent) {
+			return registry.Edit{}, false
+		}
 
-		// search for the "context.Context" variable name in the current function declaration
-		if ctxName, ok := recipe.tryGetContextVariableNameInTheFunctionDeclaration(contextImportName, funcDeclStack.Top()); ok {
-			// check if is already instrumented
-			if recipe.getObjType(cursor.Parent()) == "instasarama.ProducerMessageWithSpanFromContext" {
-				return false
-			}
+		// wrap message creation
+		return recipe.wrapWithContext(info, contextImportName, funcDeclStack.Top(), unaryExp, func(wrapped ast.Expr) {
+			cursor.Replace(wrapped)
+		}), true
+	}
 
-			// wrap message creation
-			cursor.Replace(
-				&ast.CallExpr{
-					Fun: &ast.SelectorExpr{
-						X:   &ast.Ident{Name: "instasarama"},
-						Sel: &ast.Ident{Name: "ProducerMessageWithSpanFromContext"},
-					},
-					Args: []ast.Expr{
-						&ast.Ident{Name: ctxName},
-						unaryExp,
-					},
-				})
+	return registry.Edit{}, false
+}
 
-			return true
-		}
-	}
+// wrapWithContext wraps the message with "instasarama.ProducerMessageWithSpanFromContext" using the "context.Context"
+// parameter of the function declaration. The edit is skipped, if there is no such parameter or there are several
+// of them.
+func (recipe *Sarama) wrapWithContext(info *types.Info, contextImportName string, fdcl *ast.FuncDecl, msg ast.Expr, replace func(ast.Expr)) registry.Edit {
+	// search for the "context.Context" variable name in the current function declaration
+	ctxNames := recipe.contextVariableNamesInTheFunctionDeclaration(info, contextImportName, fdcl)
+
+	switch len(ctxNames) {
+	case 0:
+		return skipNode(msg, "no-context", "the enclosing function has no context.Context parameter to take the trace context from")
+	case 1:
+		message := "the message is wrapped with " + recipe.InstanaPkg + ".ProducerMessageWithSpanFromContext()"
+
+		return changeNode(msg, "wrap-message", message, func() ast.Node {
+			wrapped := &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   &ast.Ident{Name: recipe.InstanaPkg},
+					Sel: &ast.Ident{Name: "ProducerMessageWithSpanFromContext"},
+				},
+				Args: []ast.Expr{
+					&ast.Ident{Name: ctxNames[0]},
+					msg,
+				},
+			}
+			replace(wrapped)
 
-	return false
+			return wrapped
+		})
+	default:
+		return skipNode(msg, "multiple-contexts", "the enclosing function has more than one context.Context parameter: "+strings.Join(ctxNames, ", "))
+	}
 }
 
 // isProducerMessageCreation checking if current node is unary expression like `msg := &sarama.ProducerMessage{...`
-func (recipe *Sarama) isProducerMessageCreation(node ast.Node) *ast.UnaryExpr {
-	if unaryExp, ok := node.(*ast.UnaryExpr); ok {
+func (recipe *Sarama) isProducerMessageCreation(info *types.Info, node ast.Node, targetPkg string) *ast.UnaryExpr {
+	if unaryExp, ok := node.(*ast.UnaryExpr); ok && unaryExp.Op == token.AND {
 		if compositeLit, ok := (unaryExp.X).(*ast.CompositeLit); ok {
-			if selExp, ok := (compositeLit.Type).(*ast.SelectorExpr); ok {
-				xName := ""
-				selName := ""
-				if ident, ok := selExp.X.(*ast.Ident); ok {
-					xName = ident.Name
-				}
-
-				if selExp.Sel != nil {
-					selName = selExp.Sel.Name
-				}
-
-				if xName == "sarama" && selName == "ProducerMessage" && unaryExp.Op == token.AND {
-					return unaryExp
-				}
+			if name, ok := compositeLitTypeName(info, compositeLit, saramaPkg, targetPkg); ok && name == "ProducerMessage" {
+				return unaryExp
 			}
 		}
-		return nil
 	}
 
 	return nil
 }
 
-// tryGetContextVariableNameInTheFunctionDeclaration check if current FuncDecl has "context.Context" type among
-// the parameters and returns its name.
-func (recipe *Sarama) tryGetContextVariableNameInTheFunctionDeclaration(contextImportName string, fdcl *ast.FuncDecl) (string, bool) {
+// isProducerMessageWithSpanFromContextCall checks if the expression is the instasarama.ProducerMessageWithSpanFromContext() call
+func (recipe *Sarama) isProducerMessageWithSpanFromContextCall(info *types.Info, expr ast.Expr) bool {
+	call, ok := expr.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+
+	fnName, ok := packageFunctionName(info, call, recipe.ImportPath(), recipe.InstanaPkg)
+
+	return ok && fnName == "ProducerMessageWithSpanFromContext"
+}
+
+// contextVariableNamesInTheFunctionDeclaration returns the names of "context.Context" parameters of the current FuncDecl
+func (recipe *Sarama) contextVariableNamesInTheFunctionDeclaration(info *types.Info, contextImportName string, fdcl *ast.FuncDecl) []string {
 	// if there is no function declaration, returns
 	if fdcl == nil {
-		return "", false
+		return nil
 	}
 
 	// store all context variables from the declaration
@@ -283,21 +380,18 @@ func (recipe *Sarama) tryGetContextVariableNameInTheFunctionDeclaration(contextI
 
 	if fdcl.Type != nil && fdcl.Type.Params != nil {
 		for _, field := range fdcl.Type.Params.List {
-			if len(field.Names) != 1 {
-				log.Warn().Msg("declaration has more than 1 field.Names. Skipping...")
+			if name, ok := packageTypeName(info, field.Type, "context", contextImportName); !ok || name != "Context" {
 				continue
 			}
 
-			if recipe.getObjType(field) == contextImportName+".Context" {
-				ctxNames = append(ctxNames, field.Names[0].Name)
+			// parameters declared as `ctx1, ctx2 context.Context` share the same field
+			for _, ident := range field.Names {
+				if ident.Name != "_" {
+					ctxNames = append(ctxNames, ident.Name)
+				}
 			}
 		}
 	}
 
-	if len(ctxNames) != 1 {
-		log.Warn().Msg("expecting only one context in the function declaration. Skipping...")
-		return "", false
-	}
-
-	return ctxNames[0], true
+	return ctxNames
 }
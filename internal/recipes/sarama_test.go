@@ -3,6 +3,7 @@ package recipes_test
 import (
 	"bytes"
 	"github.com/instana/go-instana/internal/recipes"
+	"github.com/instana/go-instana/internal/registry"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go/format"
@@ -365,7 +366,7 @@ func main() {
 			require.NoError(t, err)
 
 			changed := recipes.NewSarama().
-				Instrument(token.NewFileSet(), node, example.TargetPkg, "__instanaSensor")
+				Instrument(token.NewFileSet(), nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 			assert.False(t, changed)
 
@@ -390,7 +391,7 @@ func assertSaramaInstrumentation(t *testing.T, examples map[string]struct {
 			require.NoError(t, err)
 
 			changed := recipes.NewSarama().
-				Instrument(token.NewFileSet(), node, example.TargetPkg, "__instanaSensor")
+				Instrument(token.NewFileSet(), nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 			assert.True(t, changed)
 
@@ -582,7 +583,7 @@ func Produce(ctx context.Context) {
 			require.NoError(t, err)
 
 			changed := recipes.NewSarama().
-				Instrument(token.NewFileSet(), node, example.TargetPkg, "__instanaSensor")
+				Instrument(token.NewFileSet(), nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 			assert.True(t, changed)
 
@@ -676,7 +677,7 @@ func Produce(ctx context.Context) {
 			require.NoError(t, err)
 
 			changed := recipes.NewSarama().
-				Instrument(token.NewFileSet(), node, example.TargetPkg, "__instanaSensor")
+				Instrument(token.NewFileSet(), nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 			assert.False(t, changed)
 
@@ -792,7 +793,7 @@ func Produce(ctx Context, useless int) {
 			require.NoError(t, err)
 
 			changed := recipes.NewSarama().
-				Instrument(token.NewFileSet(), node, example.TargetPkg, "__instanaSensor")
+				Instrument(token.NewFileSet(), nil, node, example.TargetPkg, "__instanaSensor").Changed()
 
 			assert.True(t, changed)
 
@@ -805,3 +806,131 @@ func Produce(ctx Context, useless int) {
 		})
 	}
 }
+
+func TestSarama_SubRecipes(t *testing.T) {
+	code := `package main
+
+import (
+	"context"
+	"github.com/Shopify/sarama"
+)
+
+func Produce(ctx context.Context) {
+	producer, _ := sarama.NewSyncProducer([]string{"localhost:9092"}, sarama.NewConfig())
+	producer.SendMessage(&sarama.ProducerMessage{Topic: "test-topic-1"})
+}
+`
+
+	examples := map[string]struct {
+		Recipe   *recipes.Sarama
+		Expected string
+	}{
+		"constructors": {
+			Recipe: recipes.NewSaramaConstructors(),
+			Expected: `package main
+
+import (
+	"context"
+	"github.com/Shopify/sarama"
+	instasarama "github.com/instana/go-sensor/instrumentation/instasarama"
+)
+
+func Produce(ctx context.Context) {
+	producer, _ := instasarama.NewSyncProducer([]string{"localhost:9092"}, sarama.NewConfig(), __instanaSensor)
+	producer.SendMessage(&sarama.ProducerMessage{Topic: "test-topic-1"})
+}
+`,
+		},
+		"producer context": {
+			Recipe: recipes.NewSaramaProducerContext(),
+			Expected: `package main
+
+import (
+	"context"
+	"github.com/Shopify/sarama"
+	instasarama "github.com/instana/go-sensor/instrumentation/instasarama"
+)
+
+func Produce(ctx context.Context) {
+	producer, _ := sarama.NewSyncProducer([]string{"localhost:9092"}, sarama.NewConfig())
+	producer.SendMessage(instasarama.ProducerMessageWithSpanFromContext(ctx, &sarama.ProducerMessage{Topic: "test-topic-1"}))
+}
+`,
+		},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			node, err := parser.ParseFile(token.NewFileSet(), "test", code, parser.AllErrors)
+			require.NoError(t, err)
+
+			assert.True(t, example.Recipe.Instrument(token.NewFileSet(), nil, node, "sarama", "__instanaSensor").Changed())
+
+			buf := bytes.NewBuffer(nil)
+			require.NoError(t, format.Node(buf, token.NewFileSet(), node))
+
+			assert.Equal(t, example.Expected, buf.String())
+		})
+	}
+}
+
+func TestSarama_InstrumentUsingContext_Skipped(t *testing.T) {
+	code := `package main
+
+import (
+	"context"
+	"github.com/Shopify/sarama"
+)
+
+var defaultMsg = &sarama.ProducerMessage{Topic: "default"}
+
+func Produce(ctx1, ctx2 context.Context, producer sarama.SyncProducer) {
+	producer.SendMessage(defaultMsg)
+}
+
+func ProduceWithContexts(ctx1 context.Context, ctx2 context.Context, producer sarama.SyncProducer) {
+	producer.SendMessage(&sarama.ProducerMessage{Topic: "test-topic-1"})
+}
+`
+
+	node, err := parser.ParseFile(token.NewFileSet(), "test", code, parser.AllErrors)
+	require.NoError(t, err)
+
+	edits := recipes.NewSaramaProducerContext().Instrument(token.NewFileSet(), nil, node, "sarama", "__instanaSensor")
+	assert.False(t, edits.Changed())
+
+	var reasons []string
+	for _, e := range edits {
+		reasons = append(reasons, e.Reason)
+	}
+
+	assert.Equal(t, []string{"no-context", "multiple-contexts", "multiple-contexts", "multiple-contexts"}, reasons)
+}
+
+func TestSarama_ExcludeSubRecipes(t *testing.T) {
+	subRecipes := []string{
+		"github.com/Shopify/sarama:constructors",
+		"github.com/Shopify/sarama:producer-context",
+	}
+
+	for _, excluded := range subRecipes {
+		t.Run(excluded, func(t *testing.T) {
+			r := registry.NewRegistry()
+			for _, name := range registry.Default.ListNames() {
+				r.Register(name, registry.Default.InstrumentationRecipe(name))
+			}
+
+			require.True(t, r.Unregister(excluded))
+
+			for _, name := range subRecipes {
+				if name == excluded {
+					assert.Nil(t, r.InstrumentationRecipe(name))
+				} else {
+					assert.NotNil(t, r.InstrumentationRecipe(name))
+				}
+			}
+
+			assert.Equal(t, recipes.NewSarama().ImportPath(), r.InstrumentationImportPath("github.com/Shopify/sarama"))
+		})
+	}
+}
@@ -0,0 +1,274 @@
+// (c) Copyright IBM Corp. 2022
+
+package recipes_test
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+
+	"github.com/instana/go-instana/internal/recipes"
+	"github.com/instana/go-instana/internal/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubPackages contains the minimal declarations of instrumented packages used to type-check the test code
+var stubPackages = map[string]string{
+	"context": `package context
+
+type Context interface{}
+`,
+	"net/http": `package http
+
+type ResponseWriter interface{}
+
+type Request struct{}
+
+type Handler interface {
+	ServeHTTP(ResponseWriter, *Request)
+}
+
+type RoundTripper interface{}
+
+type Client struct {
+	Transport RoundTripper
+}
+
+type ServeMux struct{}
+
+func (mux *ServeMux) Handle(pattern string, handler Handler) {}
+
+func (mux *ServeMux) HandleFunc(pattern string, handler func(ResponseWriter, *Request)) {}
+
+func NewServeMux() *ServeMux { return nil }
+
+func HandleFunc(pattern string, handler func(ResponseWriter, *Request)) {}
+
+func NotFound(w ResponseWriter, r *Request) {}
+`,
+	"github.com/Shopify/sarama": `package sarama
+
+type Config struct{}
+
+type ProducerMessage struct {
+	Topic string
+}
+
+type SyncProducer interface {
+	SendMessage(msg *ProducerMessage) (int32, int64, error)
+}
+
+func NewSyncProducer(addrs []string, config *Config) (SyncProducer, error) { return nil, nil }
+`,
+	"github.com/julienschmidt/httprouter": `package httprouter
+
+type Router struct{}
+
+func New() *Router { return nil }
+`,
+}
+
+// stubImporter type-checks the stub packages on demand
+type stubImporter struct {
+	fset *token.FileSet
+	pkgs map[string]*types.Package
+}
+
+func (imp *stubImporter) Import(path string) (*types.Package, error) {
+	if pkg, ok := imp.pkgs[path]; ok {
+		return pkg, nil
+	}
+
+	src, ok := stubPackages[path]
+	if !ok {
+		return nil, fmt.Errorf("no stub for %s", path)
+	}
+
+	f, err := parser.ParseFile(imp.fset, path+".go", src, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := types.Config{Importer: imp}
+
+	pkg, err := conf.Check(path, imp.fset, []*ast.File{f}, nil)
+	if err != nil {
+		return nil, err
+	}
+	imp.pkgs[path] = pkg
+
+	return pkg, nil
+}
+
+func typeCheck(t *testing.T, fset *token.FileSet, f *ast.File) *types.Info {
+	t.Helper()
+
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+	}
+
+	conf := types.Config{
+		Importer: &stubImporter{fset: fset, pkgs: make(map[string]*types.Package)},
+		// the instrumented code is not required to be complete
+		Error: func(err error) {},
+	}
+	conf.Check("main", fset, []*ast.File{f}, info)
+
+	return info
+}
+
+func TestRecipes_TypeInfo(t *testing.T) {
+	examples := map[string]struct {
+		Recipe    registry.Recipe
+		TargetPkg string
+		Code      string
+		Expected  string
+	}{
+		"net/http shadowed package name": {
+			Recipe:    recipes.NewNetHTTP(),
+			TargetPkg: "http",
+			Code: `package main
+
+import "net/http"
+
+type registry struct{}
+
+func (registry) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {}
+
+func register(http registry) {
+	http.HandleFunc("/", nil)
+}
+`,
+			Expected: `package main
+
+import "net/http"
+
+type registry struct{}
+
+func (registry) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {}
+
+func register(http registry) {
+	http.HandleFunc("/", nil)
+}
+`,
+		},
+		"net/http serve mux and aliased client type": {
+			Recipe:    recipes.NewNetHTTP(),
+			TargetPkg: "http",
+			Code: `package main
+
+import "net/http"
+
+type client = http.Client
+
+func main() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", http.NotFound)
+
+	_ = client{}
+}
+`,
+			Expected: `package main
+
+import (
+	"net/http"
+
+	instana "github.com/instana/go-sensor"
+)
+
+type client = http.Client
+
+func main() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", instana.TracingHandlerFunc(__instanaSensor, "/", http.NotFound))
+
+	_ = client{Transport: instana.RoundTripper(__instanaSensor, nil)}
+}
+`,
+		},
+		"sarama aliased imports and struct field producer": {
+			Recipe:    recipes.NewSarama(),
+			TargetPkg: "kafka",
+			Code: `package main
+
+import (
+	stdctx "context"
+
+	kafka "github.com/Shopify/sarama"
+)
+
+type service struct {
+	producer kafka.SyncProducer
+}
+
+func (s *service) send(ctx stdctx.Context, msg *kafka.ProducerMessage) {
+	s.producer.SendMessage(msg)
+}
+`,
+			Expected: `package main
+
+import (
+	stdctx "context"
+
+	kafka "github.com/Shopify/sarama"
+	instasarama "github.com/instana/go-sensor/instrumentation/instasarama"
+)
+
+type service struct {
+	producer kafka.SyncProducer
+}
+
+func (s *service) send(ctx stdctx.Context, msg *kafka.ProducerMessage) {
+	s.producer.SendMessage(instasarama.ProducerMessageWithSpanFromContext(ctx, msg))
+}
+`,
+		},
+		"httprouter aliased import": {
+			Recipe:    recipes.NewHttpRouter(),
+			TargetPkg: "router",
+			Code: `package main
+
+import router "github.com/julienschmidt/httprouter"
+
+var r *router.Router = router.New()
+`,
+			Expected: `package main
+
+import (
+	instahttprouter "github.com/instana/go-sensor/instrumentation/instahttprouter"
+	router "github.com/julienschmidt/httprouter"
+)
+
+var r *instahttprouter.WrappedRouter = instahttprouter.Wrap(router.New(), __instanaSensor)
+`,
+		},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			fset := token.NewFileSet()
+
+			f, err := parser.ParseFile(fset, "main.go", example.Code, parser.ParseComments)
+			require.NoError(t, err)
+
+			info := typeCheck(t, fset, f)
+
+			changed := example.Recipe.Instrument(fset, info, f, example.TargetPkg, "__instanaSensor").Changed()
+			assert.Equal(t, example.Code != example.Expected, changed)
+
+			buf := bytes.NewBuffer(nil)
+			require.NoError(t, format.Node(buf, fset, f))
+
+			assert.Equal(t, example.Expected, buf.String())
+		})
+	}
+}
@@ -4,14 +4,18 @@
 package recipes
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
-	"github.com/rs/zerolog/log"
+	"github.com/instana/go-instana/internal/registry"
 	"go/ast"
+	"go/format"
 	"go/token"
+	"go/types"
 	"golang.org/x/tools/go/ast/astutil"
 	"path"
 	"regexp"
+	"runtime/debug"
 	"strconv"
 	"strings"
 )
@@ -60,6 +64,119 @@ func extractSelectorPackageAndName(typ ast.Expr) (string, string, bool) {
 	return "", "", false
 }
 
+// isImportedPackage returns whether the expression refers to the package imported with `pkgPath`. If there is
+// no type information available for the expression, it is compared with the local package name `pkgName`.
+// An identifier shadowing the package import, e.g. a local variable, never matches.
+func isImportedPackage(info *types.Info, x ast.Expr, pkgPath, pkgName string) bool {
+	ident, ok := x.(*ast.Ident)
+	if !ok {
+		return false
+	}
+
+	if info != nil {
+		if obj, ok := info.Uses[ident]; ok {
+			pkg, ok := obj.(*types.PkgName)
+
+			return ok && pkg.Imported().Path() == pkgPath
+		}
+	}
+
+	return ident.Name == pkgName
+}
+
+// packageFunctionName returns the name of the function called with `call`, if it belongs to the package
+// imported with `pkgPath` as `pkgName`
+func packageFunctionName(info *types.Info, call *ast.CallExpr, pkgPath, pkgName string) (string, bool) {
+	fn, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || !isImportedPackage(info, fn.X, pkgPath, pkgName) {
+		return "", false
+	}
+
+	return fn.Sel.Name, true
+}
+
+// packageTypeName returns the name of the type declared in the package imported with `pkgPath` as `pkgName`
+// for the type expression `typ`, which might be a pointer. The type aliases are resolved, if there is type
+// information available.
+func packageTypeName(info *types.Info, typ ast.Expr, pkgPath, pkgName string) (string, bool) {
+	if info != nil {
+		if path, name, ok := namedType(info.TypeOf(typ)); ok {
+			return name, path == pkgPath
+		}
+	}
+
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+
+	sel, ok := typ.(*ast.SelectorExpr)
+	if !ok || !isImportedPackage(info, sel.X, pkgPath, pkgName) {
+		return "", false
+	}
+
+	return sel.Sel.Name, true
+}
+
+// compositeLitTypeName returns the name of the type declared in the package imported with `pkgPath` as `pkgName`
+// initialized with the composite literal
+func compositeLitTypeName(info *types.Info, lit *ast.CompositeLit, pkgPath, pkgName string) (string, bool) {
+	if info != nil {
+		if path, name, ok := namedType(info.TypeOf(lit)); ok {
+			return name, path == pkgPath
+		}
+	}
+
+	if lit.Type == nil {
+		return "", false
+	}
+
+	return packageTypeName(nil, lit.Type, pkgPath, pkgName)
+}
+
+// methodReceiverType returns the package path and the name of the receiver type for a method selector,
+// such as `client.Do`. This works for method values, values of interface types and embedded fields.
+func methodReceiverType(info *types.Info, sel *ast.SelectorExpr) (string, string, bool) {
+	if info == nil {
+		return "", "", false
+	}
+
+	selection, ok := info.Selections[sel]
+	if !ok || selection.Kind() != types.MethodVal {
+		return "", "", false
+	}
+
+	return namedType(selection.Recv())
+}
+
+// namedType returns the package path and the name of the named type, a pointer to it or an alias of it.
+func namedType(t types.Type) (string, string, bool) {
+	t = unalias(t)
+
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = unalias(ptr.Elem())
+	}
+
+	named, ok := t.(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return "", "", false
+	}
+
+	return named.Obj().Pkg().Path(), named.Obj().Name(), true
+}
+
+// unalias returns the type denoted by the type alias. Starting from go1.22 type aliases are represented by
+// *types.Alias, that is not available in older versions of go/types, so an interface is used instead.
+func unalias(t types.Type) types.Type {
+	for {
+		alias, ok := t.(interface{ Rhs() types.Type })
+		if !ok {
+			return t
+		}
+
+		t = alias.Rhs()
+	}
+}
+
 type stack[E any] []*E
 
 func (s *stack[E]) Push(val *E) {
@@ -122,10 +239,178 @@ func GetPackageImportName(fset *token.FileSet, f *ast.File, importPath string) (
 	return "", errors.New("no import found for " + importPath)
 }
 
+// importsPackage returns whether the file imports the package with provided path
+func importsPackage(f *ast.File, importPath string) bool {
+	for _, imp := range f.Imports {
+		if p, err := strconv.Unquote(imp.Path.Value); err == nil && p == importPath {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addNamedImport adds the import of the package with provided path and name. A package outside of the standard
+// library, that is imported along with the standard library packages only, is placed in a separate group following
+// them, the same way goimports does.
 func addNamedImport(fset *token.FileSet, f ast.Node, instanaPkg string, importPath string) {
-	if val, ok := f.(*ast.File); ok {
-		if astutil.AddNamedImport(fset, val, instanaPkg, importPath) {
-			log.Debug().Msgf("add named import: %s %s", instanaPkg, importPath)
+	val, ok := f.(*ast.File)
+	if !ok || !astutil.AddNamedImport(fset, val, instanaPkg, importPath) {
+		return
+	}
+
+	if !isStdlibPackage(importPath) {
+		separateImportGroup(fset, val, importPath)
+	}
+}
+
+// separateImportGroup moves the import of the package with provided path to the end of its import declaration,
+// separating it from the preceding imports with a blank line, unless there are other packages outside the standard
+// library imported by this declaration
+func separateImportGroup(fset *token.FileSet, f *ast.File, importPath string) {
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT || !gen.Lparen.IsValid() {
+			continue
+		}
+
+		idx := -1
+		for i, spec := range gen.Specs {
+			p, err := strconv.Unquote(spec.(*ast.ImportSpec).Path.Value)
+			if err != nil {
+				continue
+			}
+
+			switch {
+			case p == importPath:
+				idx = i
+			case !isStdlibPackage(p):
+				return
+			}
+		}
+
+		if idx < 0 || len(gen.Specs) < 2 {
+			continue
+		}
+
+		spec := gen.Specs[idx].(*ast.ImportSpec)
+		specs := append(gen.Specs[:idx:idx], gen.Specs[idx+1:]...)
+
+		// the printer separates the specs with a blank line, if there is a line between them
+		file := fset.File(gen.Pos())
+		if file == nil {
+			return
 		}
+
+		line := file.Line(specs[len(specs)-1].End()) + 2
+		if line > file.LineCount() {
+			return
+		}
+
+		pos := file.LineStart(line)
+		if spec.Name != nil {
+			spec.Name.NamePos = pos
+		}
+		spec.Path.ValuePos = pos
+		spec.EndPos = 0
+
+		gen.Specs = append(specs, spec)
+
+		return
+	}
+}
+
+// isStdlibPackage returns whether the import path belongs to the standard library, i.e. its first element does not
+// contain a dot
+func isStdlibPackage(importPath string) bool {
+	first, _, _ := strings.Cut(importPath, "/")
+
+	return !strings.Contains(first, ".")
+}
+
+// addImport adds the import of the package with provided path, using `pkgName` as an alias only if it differs from
+// the default name of the package
+func addImport(fset *token.FileSet, f ast.Node, pkgName, importPath string) {
+	if pkgName == ExtractLocalImportName(importPath) {
+		pkgName = ""
 	}
+
+	addNamedImport(fset, f, pkgName, importPath)
+}
+
+// isSensorVar returns whether the expression is the sensor variable
+func isSensorVar(expr ast.Expr, sensorVar string) bool {
+	ident, ok := expr.(*ast.Ident)
+
+	return ok && ident.Name == sensorVar
+}
+
+// apply traverses the node with astutil.Apply(). A panic raised while processing a node is re-raised as
+// registry.NodePanic annotated with the position of this node, so that the failing code can be reported.
+func apply(root ast.Node, pre, post astutil.ApplyFunc) ast.Node {
+	return astutil.Apply(root, guardApplyFunc(pre), guardApplyFunc(post))
+}
+
+// guardApplyFunc wraps the astutil.ApplyFunc to annotate panics with the position of the current node
+func guardApplyFunc(fn astutil.ApplyFunc) astutil.ApplyFunc {
+	if fn == nil {
+		return nil
+	}
+
+	return func(c *astutil.Cursor) bool {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+
+			if _, ok := r.(registry.NodePanic); ok {
+				panic(r)
+			}
+
+			p := registry.NodePanic{Value: r, Stack: debug.Stack()}
+			if c.Node() != nil {
+				p.Pos = c.Node().Pos()
+			}
+
+			panic(p)
+		}()
+
+		return fn(c)
+	}
+}
+
+// changeNode applies the change to the node and returns the edit describing it. The change function returns
+// the node replacing the original one.
+func changeNode(node ast.Node, reason, message string, change func() ast.Node) registry.Edit {
+	e := registry.Edit{
+		Pos:      node.Pos(),
+		Original: renderCode(node),
+		Reason:   reason,
+		Message:  message,
+	}
+	e.Replacement = renderCode(change())
+
+	return e
+}
+
+// skipNode returns the edit describing why the node has not been changed
+func skipNode(node ast.Node, reason, message string) registry.Edit {
+	return registry.Edit{
+		Pos:      node.Pos(),
+		Original: renderCode(node),
+		Reason:   reason,
+		Message:  message,
+		Skipped:  true,
+	}
+}
+
+// renderCode returns the source code of the node
+func renderCode(node ast.Node) string {
+	buf := bytes.NewBuffer(nil)
+	if err := format.Node(buf, token.NewFileSet(), node); err != nil {
+		return ""
+	}
+
+	return buf.String()
 }
@@ -5,6 +5,7 @@ import (
 	"github.com/instana/go-instana/internal/recipes"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go/format"
 	"go/parser"
 	"go/token"
 	"os"
@@ -87,3 +88,109 @@ func main() {
 	_, err = recipes.GetPackageImportName(fset, node, "???")
 	assert.Error(t, err)
 }
+
+func TestNetHTTPRecipe_ImportGroups(t *testing.T) {
+	examples := map[string]struct {
+		Code, Expected string
+	}{
+		"standard library imports only": {
+			Code: `package main
+
+import (
+	"io"
+	"net/http"
+)
+
+var _ io.Reader
+
+func main() {
+	http.HandleFunc("/", http.NotFound)
+}
+`,
+			Expected: `package main
+
+import (
+	"io"
+	"net/http"
+
+	instana "github.com/instana/go-sensor"
+)
+
+var _ io.Reader
+
+func main() {
+	http.HandleFunc("/", instana.TracingHandlerFunc(__instanaSensor, "/", http.NotFound))
+}
+`,
+		},
+		"single import": {
+			Code: `package main
+
+import "net/http"
+
+func main() {
+	http.HandleFunc("/", http.NotFound)
+}
+`,
+			Expected: `package main
+
+import (
+	"net/http"
+
+	instana "github.com/instana/go-sensor"
+)
+
+func main() {
+	http.HandleFunc("/", instana.TracingHandlerFunc(__instanaSensor, "/", http.NotFound))
+}
+`,
+		},
+		"existing third-party group": {
+			Code: `package main
+
+import (
+	"net/http"
+
+	"github.com/example/lib"
+)
+
+func main() {
+	http.HandleFunc("/", lib.Handler)
+}
+`,
+			Expected: `package main
+
+import (
+	"net/http"
+
+	"github.com/example/lib"
+	instana "github.com/instana/go-sensor"
+)
+
+func main() {
+	http.HandleFunc("/", instana.TracingHandlerFunc(__instanaSensor, "/", lib.Handler))
+}
+`,
+		},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			fset := token.NewFileSet()
+
+			f, err := parser.ParseFile(fset, "main.go", example.Code, parser.ParseComments)
+			require.NoError(t, err)
+
+			require.True(t, recipes.NewNetHTTPServer().Instrument(fset, nil, f, "http", "__instanaSensor").Changed())
+
+			buf := bytes.NewBuffer(nil)
+			require.NoError(t, format.Node(buf, fset, f))
+
+			// the imports are sorted within their groups once the file is formatted
+			data, err := format.Source(buf.Bytes())
+			require.NoError(t, err)
+
+			assert.Equal(t, example.Expected, string(data))
+		})
+	}
+}
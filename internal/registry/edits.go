@@ -0,0 +1,59 @@
+// (c) Copyright IBM Corp. 2022
+
+package registry
+
+import "go/token"
+
+// Edit describes a change made by an instrumentation recipe, or a change that has been considered by the recipe,
+// but skipped, e.g. because the code does not follow a supported pattern
+type Edit struct {
+	// Recipe is the name of the recipe that made the edit. It is set by the caller of Recipe.Instrument()
+	Recipe string
+	// Pos is the position of the changed expression in the original code
+	Pos token.Pos
+	// Original is the changed expression as it was before instrumentation
+	Original string
+	// Replacement is the instrumented expression, or an empty string for skipped edits
+	Replacement string
+	// Reason is a short code explaining why the edit was made or skipped, e.g. wrap-handler
+	Reason string
+	// Message is a human-readable description of the edit
+	Message string
+	// Skipped is true if the recipe has not changed the code
+	Skipped bool
+}
+
+// Edits is a list of edits made by instrumentation recipes
+type Edits []Edit
+
+// Changed returns whether any of edits has changed the code
+func (edits Edits) Changed() bool {
+	for _, e := range edits {
+		if !e.Skipped {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Applied returns the edits that have changed the code
+func (edits Edits) Applied() Edits {
+	return edits.filter(false)
+}
+
+// Skipped returns the edits that have been skipped
+func (edits Edits) Skipped() Edits {
+	return edits.filter(true)
+}
+
+func (edits Edits) filter(skipped bool) Edits {
+	var res Edits
+	for _, e := range edits {
+		if e.Skipped == skipped {
+			res = append(res, e)
+		}
+	}
+
+	return res
+}
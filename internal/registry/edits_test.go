@@ -0,0 +1,23 @@
+// (c) Copyright IBM Corp. 2022
+
+package registry_test
+
+import (
+	"testing"
+
+	"github.com/instana/go-instana/internal/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEdits_Changed(t *testing.T) {
+	applied := registry.Edit{Reason: "wrap-handler"}
+	skipped := registry.Edit{Reason: "client-created-with-new", Skipped: true}
+
+	assert.False(t, registry.Edits(nil).Changed())
+	assert.False(t, registry.Edits{skipped}.Changed())
+	assert.True(t, registry.Edits{skipped, applied}.Changed())
+
+	edits := registry.Edits{applied, skipped, applied}
+	assert.Equal(t, registry.Edits{applied, applied}, edits.Applied())
+	assert.Equal(t, registry.Edits{skipped}, edits.Skipped())
+}
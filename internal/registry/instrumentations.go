@@ -6,71 +6,239 @@ package registry
 import (
 	"go/ast"
 	"go/token"
+	"go/types"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 var Default = NewRegistry()
 
+// subRecipeSeparator separates the target package path from the sub-recipe name in a recipe name
+const subRecipeSeparator = ":"
+
+// RecipeName returns the name of a sub-recipe of the target package, e.g. net/http:client. Sub-recipes allow to switch
+// the parts of the package instrumentation independently.
+func RecipeName(targetPkg, subRecipe string) string {
+	return targetPkg + subRecipeSeparator + subRecipe
+}
+
+// TargetPackage returns the target package path of the recipe with provided name
+func TargetPackage(name string) string {
+	targetPkg, _, _ := strings.Cut(name, subRecipeSeparator)
+
+	return targetPkg
+}
+
 // NewRegistry returns Registry instance
 func NewRegistry() *Registry {
-	return &Registry{
+	r := &Registry{
 		instrumentation: make(map[string]Recipe),
+		options:         make(map[string]recipeOptions),
 	}
+	r.importPaths.Store(map[string]string{})
+
+	return r
 }
 
-// Registry is responsible for keeping mapping between packages and their instrumentation.
+// Registry is responsible for keeping mapping between packages and their instrumentation. The recipes are registered
+// under the target package path, or under a sub-recipe name, if there are multiple recipes for the same package.
+// The registry is safe for concurrent use. Recipes are expected to be registered once on startup, so the lookups
+// only take a read lock, and the order of recipes is calculated once and reused until the set of recipes changes.
+// The instrumentation import paths are looked up for each import of every processed file, so they are kept in
+// a map replaced on each change and read without taking the lock.
 type Registry struct {
-	mu              sync.Mutex
+	mu              sync.RWMutex
 	instrumentation map[string]Recipe
+	options         map[string]recipeOptions
+	ordered         []string
+	importPaths     atomic.Value // map[string]string
 }
 
-// Register creates a mapping between targetPkg and instrumentation. It should be invoked with `init()` function
-func (r *Registry) Register(targetPkg string, instrumentation Recipe) {
+// recipeOptions defines when the recipe is applied relative to other recipes
+type recipeOptions struct {
+	priority  int
+	runsAfter []string
+}
+
+// RecipeOption is an option of a registered recipe
+type RecipeOption func(*recipeOptions)
+
+// Priority sets the priority of the recipe. Recipes with higher priority are applied first. The default priority is 0.
+func Priority(priority int) RecipeOption {
+	return func(opts *recipeOptions) {
+		opts.priority = priority
+	}
+}
+
+// RunsAfter requires the recipe to be applied after the recipes with provided names, regardless of their priorities.
+// A target package path refers to all recipes registered for this package.
+func RunsAfter(names ...string) RecipeOption {
+	return func(opts *recipeOptions) {
+		opts.runsAfter = append(opts.runsAfter, names...)
+	}
+}
+
+// Register creates a mapping between the recipe name and instrumentation. The name is either the target package
+// path or a sub-recipe name returned by RecipeName(). If there are multiple recipes for the same package, all of them
+// are expected to be registered as sub-recipes, so that each one can be unregistered separately. It should be invoked
+// with `init()` function
+func (r *Registry) Register(name string, instrumentation Recipe, opts ...RecipeOption) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.instrumentation[targetPkg] = instrumentation
+	var options recipeOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	r.instrumentation[name] = instrumentation
+	r.options[name] = options
+	r.ordered = nil
+	r.updateImportPaths()
 }
 
 // InstrumentationImportPath returns instrumentation import path for targetPkg, if any registered or empty string otherwise.
 func (r *Registry) InstrumentationImportPath(targetPkg string) string {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	return r.importPaths.Load().(map[string]string)[targetPkg]
+}
 
-	if instrumentation, ok := r.instrumentation[targetPkg]; ok {
-		return instrumentation.ImportPath()
+// updateImportPaths rebuilds the mapping between target packages and their instrumentation import paths. If there are
+// multiple recipes for the same package, the import path of the first one in alphabetical order is used. The caller is
+// expected to hold the lock.
+func (r *Registry) updateImportPaths() {
+	importPaths := make(map[string]string, len(r.instrumentation))
+	for _, name := range r.sortedNames() {
+		if _, ok := importPaths[TargetPackage(name)]; !ok {
+			importPaths[TargetPackage(name)] = r.instrumentation[name].ImportPath()
+		}
 	}
 
-	return ""
+	r.importPaths.Store(importPaths)
 }
 
-// InstrumentationRecipe returns recipe for the targetPkg if any registered.
-func (r *Registry) InstrumentationRecipe(targetPkg string) Recipe {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+// InstrumentationRecipe returns recipe registered under provided name, if any.
+func (r *Registry) InstrumentationRecipe(name string) Recipe {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	return r.instrumentation[targetPkg]
+	return r.instrumentation[name]
 }
 
-// ListNames returns list of the package names, that has registered instrumentations.
+// ListNames returns sorted list of the registered recipe names.
 func (r *Registry) ListNames() []string {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	var res []string
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.sortedNames()
+}
 
+// Ordered returns the registered recipe names in the order the recipes are applied. A recipe is applied after
+// all recipes it runs after, otherwise the recipes are ordered by priority and then by name. The dependencies
+// on recipes that are not registered are ignored. If the dependencies form a cycle, the recipe having the highest
+// priority among the ones involved is applied first.
+func (r *Registry) Ordered() []string {
+	r.mu.RLock()
+	ordered := r.ordered
+	r.mu.RUnlock()
+
+	if ordered == nil {
+		r.mu.Lock()
+		if r.ordered == nil {
+			r.ordered = r.order()
+		}
+		ordered = r.ordered
+		r.mu.Unlock()
+	}
+
+	// the cached order is shared between callers, so each of them gets its own copy
+	return append([]string(nil), ordered...)
+}
+
+// order calculates the order of recipes returned by Ordered(). The caller is expected to hold the lock.
+func (r *Registry) order() []string {
+	names := r.sortedNames()
+	sort.SliceStable(names, func(i, j int) bool {
+		return r.options[names[i]].priority > r.options[names[j]].priority
+	})
+
+	applied := make(map[string]bool, len(names))
+	ready := func(name string) bool {
+		for _, dep := range r.options[name].runsAfter {
+			for _, depName := range names {
+				if depName == name || applied[depName] {
+					continue
+				}
+
+				if depName == dep || TargetPackage(depName) == dep {
+					return false
+				}
+			}
+		}
+
+		return true
+	}
+
+	res := make([]string, 0, len(names))
+	for len(res) < len(names) {
+		next := ""
+		for _, name := range names {
+			if applied[name] {
+				continue
+			}
+
+			if next == "" {
+				next = name // used to break the dependency cycle, if there is one
+			}
+
+			if ready(name) {
+				next = name
+				break
+			}
+		}
+
+		applied[next] = true
+		res = append(res, next)
+	}
+
+	return res
+}
+
+// sortedNames returns the registered recipe names sorted alphabetically. The caller is expected to hold the lock.
+func (r *Registry) sortedNames() []string {
+	res := make([]string, 0, len(r.instrumentation))
 	for name := range r.instrumentation {
 		res = append(res, name)
 	}
+	sort.Strings(res)
 
 	return res
 }
 
-// Unregister the recipe by package name.
-func (r *Registry) Unregister(targetPkg string) {
+// Unregister the recipe by name. If the target package path is provided, all recipes registered for this package
+// are removed. It returns false if there were no matching recipes.
+func (r *Registry) Unregister(name string) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	delete(r.instrumentation, targetPkg)
+	var found bool
+	for _, registered := range r.sortedNames() {
+		if registered != name && TargetPackage(registered) != name {
+			continue
+		}
+
+		delete(r.instrumentation, registered)
+		delete(r.options, registered)
+		r.ordered = nil
+		found = true
+	}
+
+	if found {
+		r.updateImportPaths()
+	}
+
+	return found
 }
 
 type Instrumentation interface {
@@ -78,7 +246,27 @@ type Instrumentation interface {
 	ImportPath() string
 }
 
+// Recipe applies instrumentation to the code using the target package
 type Recipe interface {
-	Instrument(fset *token.FileSet, f ast.Node, pkgName, sensorVar string) bool
+	// Instrument applies the instrumentation to the node, where `pkgName` is the local name of the target package
+	// import. The type information of the package is provided with `info`, that might be nil or incomplete, if the
+	// package could not be type-checked. In this case recipes match the code by identifier names. It returns the list
+	// of edits made to the node, including the ones that have been skipped.
+	Instrument(fset *token.FileSet, info *types.Info, f ast.Node, pkgName, sensorVar string) Edits
 	Instrumentation
 }
+
+// Describer is implemented by the recipes that are able to list the code they transform
+type Describer interface {
+	// Functions returns the sorted list of functions and methods of the target package transformed by the recipe,
+	// e.g. New or (*ServeMux).Handle
+	Functions() []string
+}
+
+// Remover is implemented by the recipes that are able to revert the changes made by Recipe.Instrument()
+type Remover interface {
+	// Remove reverts the instrumentation of the node, where `pkgName` is the local name of the target package
+	// import, that is added by the recipe if missing, and `instanaPkgName` is the local name of the instrumentation
+	// package import. It returns the list of edits made to the node.
+	Remove(fset *token.FileSet, info *types.Info, f ast.Node, pkgName, instanaPkgName, sensorVar string) Edits
+}
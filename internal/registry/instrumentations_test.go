@@ -6,6 +6,7 @@ package registry_test
 import (
 	"github.com/instana/go-instana/internal/recipes"
 	"github.com/instana/go-instana/internal/registry"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -22,3 +23,133 @@ func TestRegistry(t *testing.T) {
 	recipe := r.InstrumentationRecipe(targetPkg)
 	assert.Equal(t, expectedRecipe, recipe)
 }
+
+func TestRegistry_ListNames(t *testing.T) {
+	r := registry.NewRegistry()
+	r.Register("net/http", recipes.NewNetHTTP())
+	r.Register("github.com/gin-gonic/gin", recipes.NewGin())
+	r.Register("database/sql", recipes.NewDatabaseSQL())
+
+	assert.Equal(t, []string{"database/sql", "github.com/gin-gonic/gin", "net/http"}, r.ListNames())
+}
+
+func TestRegistry_Ordered(t *testing.T) {
+	r := registry.NewRegistry()
+	r.Register("net/http", recipes.NewNetHTTP(), registry.RunsAfter("github.com/gin-gonic/gin", "github.com/unknown/pkg"))
+	r.Register("github.com/gin-gonic/gin", recipes.NewGin())
+	r.Register("database/sql", recipes.NewDatabaseSQL())
+	r.Register("github.com/Shopify/sarama", recipes.NewSarama(), registry.Priority(10))
+	r.Register("github.com/labstack/echo/v4", recipes.NewEcho(), registry.Priority(-1))
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, []string{
+			"github.com/Shopify/sarama",
+			"database/sql",
+			"github.com/gin-gonic/gin",
+			"net/http",
+			"github.com/labstack/echo/v4",
+		}, r.Ordered())
+	}
+
+	r.Unregister("github.com/gin-gonic/gin")
+	assert.Equal(t, []string{
+		"github.com/Shopify/sarama",
+		"database/sql",
+		"net/http",
+		"github.com/labstack/echo/v4",
+	}, r.Ordered())
+}
+
+func TestRegistry_Ordered_RunsAfterLowerPriority(t *testing.T) {
+	r := registry.NewRegistry()
+	r.Register("net/http", recipes.NewNetHTTP(), registry.Priority(10), registry.RunsAfter("github.com/gin-gonic/gin"))
+	r.Register("github.com/gin-gonic/gin", recipes.NewGin(), registry.Priority(-10))
+	r.Register("database/sql", recipes.NewDatabaseSQL())
+
+	assert.Equal(t, []string{"database/sql", "github.com/gin-gonic/gin", "net/http"}, r.Ordered())
+}
+
+func TestRegistry_Ordered_Cycle(t *testing.T) {
+	r := registry.NewRegistry()
+	r.Register("net/http", recipes.NewNetHTTP(), registry.RunsAfter("github.com/gin-gonic/gin"))
+	r.Register("github.com/gin-gonic/gin", recipes.NewGin(), registry.RunsAfter("net/http"), registry.Priority(1))
+	r.Register("database/sql", recipes.NewDatabaseSQL(), registry.RunsAfter("database/sql"))
+
+	assert.Equal(t, []string{"database/sql", "github.com/gin-gonic/gin", "net/http"}, r.Ordered())
+}
+
+func TestRecipeName(t *testing.T) {
+	name := registry.RecipeName("net/http", "client")
+
+	assert.Equal(t, "net/http:client", name)
+	assert.Equal(t, "net/http", registry.TargetPackage(name))
+	assert.Equal(t, "net/http", registry.TargetPackage("net/http"))
+}
+
+func TestRegistry_SubRecipes(t *testing.T) {
+	r := registry.NewRegistry()
+	r.Register(registry.RecipeName("net/http", "server"), recipes.NewNetHTTPServer())
+	r.Register(registry.RecipeName("net/http", "client"), recipes.NewNetHTTPClient())
+	r.Register(registry.RecipeName("google.golang.org/grpc", "server"), recipes.NewGRPCServer())
+	r.Register(registry.RecipeName("google.golang.org/grpc", "client"), recipes.NewGRPCClient())
+
+	assert.Equal(t, recipes.NewNetHTTP().ImportPath(), r.InstrumentationImportPath("net/http"))
+	assert.Empty(t, r.InstrumentationImportPath("net/http:client"))
+
+	assert.True(t, r.Unregister("net/http:client"))
+	assert.False(t, r.Unregister("net/http:client"))
+	assert.Equal(t, []string{"google.golang.org/grpc:client", "google.golang.org/grpc:server", "net/http:server"}, r.ListNames())
+
+	assert.True(t, r.Unregister("google.golang.org/grpc"))
+	assert.False(t, r.Unregister("google.golang.org/grpc"))
+	assert.Equal(t, []string{"net/http:server"}, r.ListNames())
+	assert.Empty(t, r.InstrumentationImportPath("google.golang.org/grpc"))
+	assert.Equal(t, recipes.NewNetHTTP().ImportPath(), r.InstrumentationImportPath("net/http"))
+
+	assert.True(t, r.Unregister("net/http"))
+	assert.Empty(t, r.InstrumentationImportPath("net/http"))
+}
+
+func TestRegistry_Ordered_RunsAfterSubRecipe(t *testing.T) {
+	r := registry.NewRegistry()
+	r.Register(registry.RecipeName("github.com/Shopify/sarama", "constructors"), recipes.NewSaramaConstructors())
+	r.Register(registry.RecipeName("github.com/Shopify/sarama", "producer-context"), recipes.NewSaramaProducerContext(), registry.RunsAfter("github.com/Shopify/sarama:constructors"), registry.Priority(10))
+	r.Register("database/sql", recipes.NewDatabaseSQL(), registry.RunsAfter("net/http"), registry.Priority(5))
+	r.Register(registry.RecipeName("net/http", "client"), recipes.NewNetHTTPClient())
+
+	assert.Equal(t, []string{
+		"github.com/Shopify/sarama:constructors",
+		"github.com/Shopify/sarama:producer-context",
+		"net/http:client",
+		"database/sql",
+	}, r.Ordered())
+}
+
+func TestRegistry_Ordered_Concurrent(t *testing.T) {
+	r := registry.NewRegistry()
+	r.Register("net/http", recipes.NewNetHTTP(), registry.RunsAfter("github.com/gin-gonic/gin"))
+	r.Register("github.com/gin-gonic/gin", recipes.NewGin())
+
+	expected := []string{"github.com/gin-gonic/gin", "net/http"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			names := r.Ordered()
+			assert.Equal(t, expected, names)
+
+			// the callers must not be able to change the order returned to others
+			names[0] = "modified"
+			assert.NotNil(t, r.InstrumentationRecipe("net/http"))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, expected, r.Ordered())
+
+	r.Register("database/sql", recipes.NewDatabaseSQL())
+	assert.Equal(t, []string{"database/sql", "github.com/gin-gonic/gin", "net/http"}, r.Ordered())
+}
@@ -0,0 +1,20 @@
+// (c) Copyright IBM Corp. 2022
+
+package registry
+
+import (
+	"fmt"
+	"go/token"
+)
+
+// NodePanic annotates a panic raised by a recipe with the position of the node that was being processed
+type NodePanic struct {
+	Pos   token.Pos
+	Value interface{}
+	Stack []byte
+}
+
+// String returns the panic value
+func (p NodePanic) String() string {
+	return fmt.Sprint(p.Value)
+}
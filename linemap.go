@@ -0,0 +1,208 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// positionMapFileSuffix is appended to the name of an instrumented copy to get the name of its position map
+const positionMapFileSuffix = ".posmap.json"
+
+// positionMap describes how the lines of an instrumented copy map to the lines of its source file
+type positionMap struct {
+	// Source is the name of the original source file
+	Source string `json:"source"`
+	// File is the name of the instrumented copy
+	File string `json:"file"`
+	// Lines contains the source line for each line of the instrumented copy. Lines added by go-instana,
+	// including the //line directives, are mapped to 0.
+	Lines []int `json:"lines"`
+}
+
+// writeInstrumentedCopy writes the instrumented code of `src` to `dst` annotated with //line directives, so that
+// the compiled positions point to the original source file, along with the position map of the written file. If
+// the source file does not exist, or already contains line directives, e.g. when it is generated by cgo, the code
+// is written as is.
+func writeInstrumentedCopy(logger zerolog.Logger, dst, src string, data []byte) error {
+	original, err := ioutil.ReadFile(src)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", src, err)
+	}
+
+	if original == nil || hasLineDirectives(original) {
+		logger.Debug().Msgf("%s: line directives are not added", src)
+		return writeNodeToFile(logger, dst, data)
+	}
+
+	annotated, lines := addLineDirectives(src, original, data)
+	if err := writeNodeToFile(logger, dst, annotated); err != nil {
+		return err
+	}
+
+	posMap, err := json.Marshal(positionMap{Source: src, File: dst, Lines: lines})
+	if err != nil {
+		return fmt.Errorf("failed to encode position map of %s: %w", dst, err)
+	}
+
+	return writeNodeToFile(logger, dst+positionMapFileSuffix, posMap)
+}
+
+// hasLineDirectives checks whether the source code contains any //line or /*line */ directives
+func hasLineDirectives(src []byte) bool {
+	return bytes.HasPrefix(src, []byte("//line ")) ||
+		bytes.Contains(src, []byte("\n//line ")) ||
+		bytes.Contains(src, []byte("/*line "))
+}
+
+// addLineDirectives inserts //line directives into the instrumented code wherever its lines get out of sync with
+// the original source, and returns the annotated code along with the source line of each of its lines. A chunk of
+// lines added by instrumentation recipes starts at the first source line it replaces, or at the preceding source line
+// if nothing was replaced. The code always starts with a directive, so that the lines preceding the first change, or
+// all lines if no line has been added or removed, are attributed to the source file rather than to the copy.
+func addLineDirectives(fName string, original, instrumented []byte) ([]byte, []int) {
+	targets, exact := sourceLines(string(original), string(instrumented))
+	outLines := strings.SplitAfter(string(instrumented), "\n")
+	if outLines[len(outLines)-1] == "" {
+		outLines = outLines[:len(outLines)-1]
+	}
+
+	insertable := directiveLines(instrumented, len(outLines))
+
+	var (
+		buf       bytes.Buffer
+		lines     = make([]int, 0, len(outLines)+1)
+		effective int  // the line number assigned by the compiler to the current line
+		pending   bool // whether a directive is required but could not be inserted yet
+	)
+
+	fmt.Fprintf(&buf, "//line %s:1:1\n", fName)
+	lines = append(lines, 0)
+
+	for i, line := range outLines {
+		effective++
+
+		// the lines added by instrumentation keep on counting from the first line of the added chunk
+		if exact[i] || i == 0 || exact[i-1] || pending {
+			pending = targets[i] != effective
+		}
+
+		if pending && insertable[i] {
+			fmt.Fprintf(&buf, "//line %s:%d:1\n", fName, targets[i])
+			lines = append(lines, 0)
+			effective, pending = targets[i], false
+		}
+
+		buf.WriteString(line)
+		if exact[i] {
+			lines = append(lines, targets[i])
+		} else {
+			lines = append(lines, 0)
+		}
+	}
+
+	return buf.Bytes(), lines
+}
+
+// sourceLines diffs the instrumented code against the original one line by line and returns the source line
+// each instrumented line should be attributed to. The second returned slice tells whether the line is unchanged.
+// All lines of an added chunk are attributed to the first replaced source line, or to the preceding one.
+func sourceLines(original, instrumented string) ([]int, []bool) {
+	split := func(s string) []string {
+		lines := strings.SplitAfter(s, "\n")
+		if lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+
+		return lines
+	}
+
+	diffs := diffSequences(split(original), split(instrumented))
+
+	var (
+		targets []int
+		exact   []bool
+		srcLine int // the last consumed source line
+
+		deleted []int // source lines removed by the current hunk
+	)
+	for _, d := range diffs {
+		n := len([]rune(d.Text))
+
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			for i := 0; i < n; i++ {
+				srcLine++
+				targets = append(targets, srcLine)
+				exact = append(exact, true)
+			}
+			deleted = deleted[:0]
+		case diffmatchpatch.DiffDelete:
+			for i := 0; i < n; i++ {
+				srcLine++
+				deleted = append(deleted, srcLine)
+			}
+		case diffmatchpatch.DiffInsert:
+			target := srcLine
+			if len(deleted) > 0 {
+				target = deleted[0]
+			}
+
+			if target < 1 {
+				target = 1
+			}
+
+			for i := 0; i < n; i++ {
+				targets = append(targets, target)
+				exact = append(exact, false)
+			}
+		}
+	}
+
+	return targets, exact
+}
+
+// directiveLines returns for each of `n` lines of the source code whether a //line directive can be inserted
+// before it. A directive cannot be placed inside a raw string literal or a block comment spanning multiple lines.
+func directiveLines(src []byte, n int) []bool {
+	insertable := make([]bool, n)
+	for i := range insertable {
+		insertable[i] = true
+	}
+
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+
+	var s scanner.Scanner
+	s.Init(file, src, nil, scanner.ScanComments)
+
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+
+		if tok != token.STRING && tok != token.COMMENT {
+			continue
+		}
+
+		start := file.Line(pos)
+		for i := 1; i <= strings.Count(lit, "\n"); i++ {
+			if line := start + i; line <= n {
+				insertable[line-1] = false
+			}
+		}
+	}
+
+	return insertable
+}
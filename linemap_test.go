@@ -0,0 +1,160 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddLineDirectives(t *testing.T) {
+	original := `package main
+
+import "net/http"
+
+func main() {
+	http.HandleFunc("/", http.NotFound)
+	panic("here")
+}
+`
+
+	instrumented := `package main
+
+import (
+	"net/http"
+
+	instana "github.com/instana/go-sensor"
+)
+
+func main() {
+	http.HandleFunc("/", instana.TracingHandlerFunc(__instanaSensor, "/", http.NotFound))
+	panic("here")
+}
+`
+
+	annotated, lines := addLineDirectives("/src/main.go", []byte(original), []byte(instrumented))
+
+	assert.Equal(t, `//line /src/main.go:1:1
+package main
+
+import (
+	"net/http"
+
+	instana "github.com/instana/go-sensor"
+)
+//line /src/main.go:4:1
+
+func main() {
+	http.HandleFunc("/", instana.TracingHandlerFunc(__instanaSensor, "/", http.NotFound))
+	panic("here")
+}
+`, string(annotated))
+
+	assert.Equal(t, []int{0, 1, 2, 0, 0, 0, 0, 0, 0, 4, 5, 0, 7, 8}, lines)
+
+	// the compiled positions are expected to match the original source
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "/out/main.go", annotated, 0)
+	require.NoError(t, err)
+
+	fn := f.Decls[1].(*ast.FuncDecl)
+	assert.Equal(t, "/src/main.go:5:1", fset.Position(fn.Pos()).String())
+	assert.Equal(t, "/src/main.go:7:2", fset.Position(fn.Body.List[1].Pos()).String())
+}
+
+func TestAddLineDirectives_RawString(t *testing.T) {
+	original := "package main\n\nvar s = f(`a\nb`,\n1)\n"
+	instrumented := "package main\n\nvar s = f(\n\t2, `a\nb`,\n1)\n"
+
+	annotated, lines := addLineDirectives("/src/main.go", []byte(original), []byte(instrumented))
+
+	// the directive cannot be placed inside the raw string literal, so it is postponed to the next line
+	assert.Equal(t, "//line /src/main.go:1:1\npackage main\n\nvar s = f(\n\t2, `a\nb`,\n//line /src/main.go:5:1\n1)\n", string(annotated))
+	assert.Equal(t, []int{0, 1, 2, 0, 0, 4, 0, 5}, lines)
+}
+
+func TestAddLineDirectives_SameLineCount(t *testing.T) {
+	original := `package main
+
+import "net/http"
+
+func main() {
+	http.HandleFunc("/", http.NotFound)
+	panic("here")
+}
+`
+
+	instrumented := `package main
+
+import "net/http"
+
+func main() {
+	http.HandleFunc("/", instana.TracingHandlerFunc(__instanaSensor, "/", http.NotFound))
+	panic("here")
+}
+`
+
+	annotated, lines := addLineDirectives("/src/main.go", []byte(original), []byte(instrumented))
+
+	assert.Equal(t, "//line /src/main.go:1:1\n"+instrumented, string(annotated))
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 0, 7, 8}, lines)
+
+	// the positions are expected to point to the source file even though no lines have been added or removed
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "/out/main.go", annotated, 0)
+	require.NoError(t, err)
+
+	fn := f.Decls[1].(*ast.FuncDecl)
+	assert.Equal(t, "/src/main.go:5:1", fset.Position(fn.Pos()).String())
+	assert.Equal(t, "/src/main.go:6:2", fset.Position(fn.Body.List[0].Pos()).String())
+	assert.Equal(t, "/src/main.go:7:2", fset.Position(fn.Body.List[1].Pos()).String())
+}
+
+func TestDirectiveLines(t *testing.T) {
+	src := "package main\n\nvar s = `a\nb\nc`\n\n/* d\ne */\nvar x = \"f\"\n"
+
+	assert.Equal(t, []bool{true, true, true, false, false, true, true, false, true}, directiveLines([]byte(src), 9))
+}
+
+func TestWriteInstrumentedCopy(t *testing.T) {
+	srcDir, outDir := t.TempDir(), t.TempDir()
+
+	src, dst := filepath.Join(srcDir, "main.go"), filepath.Join(outDir, "main.go")
+	writeFile(t, src, "package main\n\nfunc main() {}\n")
+
+	require.NoError(t, writeInstrumentedCopy(log.Logger, dst, src, []byte("package main\n\nimport \"fmt\"\n\nfunc main() {}\n")))
+
+	data, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "//line "+src+":1:1\npackage main\n\n//line "+src+":2:1\nimport \"fmt\"\n\n//line "+src+":3:1\nfunc main() {}\n", string(data))
+
+	data, err = os.ReadFile(dst + positionMapFileSuffix)
+	require.NoError(t, err)
+
+	var posMap positionMap
+	require.NoError(t, json.Unmarshal(data, &posMap))
+	assert.Equal(t, positionMap{Source: src, File: dst, Lines: []int{0, 1, 2, 0, 0, 0, 0, 3}}, posMap)
+}
+
+func TestWriteInstrumentedCopy_ExistingLineDirectives(t *testing.T) {
+	srcDir, outDir := t.TempDir(), t.TempDir()
+
+	src, dst := filepath.Join(srcDir, "main.cgo1.go"), filepath.Join(outDir, "main.cgo1.go")
+	writeFile(t, src, "//line /src/main.go:1:1\npackage main\n")
+
+	require.NoError(t, writeInstrumentedCopy(log.Logger, dst, src, []byte("//line /src/main.go:1:1\npackage main\n\nimport \"C\"\n")))
+
+	data, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "//line /src/main.go:1:1\npackage main\n\nimport \"C\"\n", string(data))
+	assert.NoFileExists(t, dst+positionMapFileSuffix)
+}
@@ -0,0 +1,104 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// buildContext returns the build context used to select package files. It respects GOOS, GOARCH and
+// CGO_ENABLED environment variables along with the build tags provided with the -tags flag.
+func buildContext() build.Context {
+	ctx := build.Default
+
+	for _, tag := range strings.FieldsFunc(args.BuildTags, func(r rune) bool { return r == ',' || r == ' ' }) {
+		ctx.BuildTags = append(ctx.BuildTags, tag)
+	}
+
+	return ctx
+}
+
+// loadPackage parses non-test source files of the package located in `dir` that match the current build
+// context. Files excluded by build constraints or file name suffixes, such as `//go:build ignore` code
+// generators or files for other platforms, are skipped.
+func loadPackage(logger zerolog.Logger, fset *token.FileSet, dir string) (*ast.Package, error) {
+	files, err := packageFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseFiles(logger, fset, dir, files)
+}
+
+// packageFiles returns the names of non-test source files of the package located in `dir` that match
+// the current build context
+func packageFiles(dir string) ([]string, error) {
+	ctx := buildContext()
+
+	bp, err := ctx.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, fName := range append(bp.GoFiles, bp.CgoFiles...) {
+		files = append(files, filepath.Join(dir, fName))
+	}
+
+	return files, nil
+}
+
+// parseFiles parses provided source files, that are expected to belong to the same package located in `dir`
+func parseFiles(logger zerolog.Logger, fset *token.FileSet, dir string, files []string) (*ast.Package, error) {
+	if len(files) == 0 {
+		return nil, &build.NoGoError{Dir: dir}
+	}
+
+	pkgs := make(map[string]*ast.Package)
+
+	for _, fName := range files {
+		f, err := parser.ParseFile(fset, fName, nil, parser.ParseComments)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse source files in %q: %w", dir, err)
+		}
+
+		pkg, ok := pkgs[f.Name.Name]
+		if !ok {
+			pkg = &ast.Package{
+				Name:  f.Name.Name,
+				Files: make(map[string]*ast.File),
+			}
+			pkgs[f.Name.Name] = pkg
+		}
+
+		pkg.Files[fName] = f
+	}
+
+	if len(pkgs) != 1 {
+		return nil, multiplePackageError(dir, pkgs)
+	}
+
+	// get single element from map
+	var pkg *ast.Package
+	for _, pkg = range pkgs {
+		logger.Debug().Msgf("found package %s with %d file(s)", pkg.Name, len(pkg.Files))
+	}
+
+	return pkg, nil
+}
+
+// isNoGoError returns whether the error is returned for a directory without buildable Go source files
+func isNoGoError(err error) bool {
+	var noGoErr *build.NoGoError
+
+	return errors.As(err, &noGoErr)
+}
@@ -0,0 +1,90 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"go/token"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPackage(t *testing.T) {
+	dir := t.TempDir()
+
+	otherOS := "windows"
+	if runtime.GOOS == otherOS {
+		otherOS = "linux"
+	}
+
+	files := map[string]string{
+		"lib.go":                 "package lib\n",
+		"lib_" + otherOS + ".go": "package lib\n",
+		"lib_test.go":            "package lib_test\n",
+		"tagged.go":              "//go:build custom\n\npackage lib\n",
+		"gen.go":                 "//go:build ignore\n\npackage main\n",
+	}
+
+	for fName, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, fName), []byte(content), 0644))
+	}
+
+	defer func(tags string) {
+		args.BuildTags = tags
+	}(args.BuildTags)
+
+	examples := map[string]struct {
+		Tags     string
+		Expected []string
+	}{
+		"no tags": {
+			Expected: []string{"lib.go"},
+		},
+		"custom tag": {
+			Tags:     "custom",
+			Expected: []string{"lib.go", "tagged.go"},
+		},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			args.BuildTags = example.Tags
+
+			pkg, err := loadPackage(log.Logger, token.NewFileSet(), dir)
+			require.NoError(t, err)
+
+			assert.Equal(t, "lib", pkg.Name)
+
+			var loaded []string
+			for fName := range pkg.Files {
+				loaded = append(loaded, filepath.Base(fName))
+			}
+			sort.Strings(loaded)
+
+			assert.Equal(t, example.Expected, loaded)
+		})
+	}
+}
+
+func TestLoadPackage_NoGoFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "gen.go"), []byte("//go:build ignore\n\npackage main\n"), 0644))
+
+	_, err := loadPackage(log.Logger, token.NewFileSet(), dir)
+	assert.True(t, isNoGoError(err))
+}
+
+func TestParseFiles_MultiplePackages(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.go"), []byte("package a\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.go"), []byte("package b\n"), 0644))
+
+	_, err := parseFiles(log.Logger, token.NewFileSet(), dir, []string{filepath.Join(dir, "a.go"), filepath.Join(dir, "b.go")})
+	assert.Error(t, err)
+}
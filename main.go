@@ -4,6 +4,7 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"flag"
 	"fmt"
@@ -12,18 +13,19 @@ import (
 	"github.com/instana/go-instana/internal/registry"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"github.com/sergi/go-diff/diffmatchpatch"
 	"go/ast"
 	"go/format"
 	"go/parser"
 	"go/token"
+	"go/types"
 	"golang.org/x/tools/go/ast/astutil"
-	"golang.org/x/tools/imports"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
 )
 
@@ -31,6 +33,16 @@ const SensorPackage = "github.com/instana/go-sensor"
 
 var args struct {
 	ExcludedPackages arrayFlags
+	BuildTags        string
+	Dependencies     arrayFlags
+	VendoredModules  arrayFlags
+	Strict           bool
+	Jobs             int
+	NoCache          bool
+	DryRun           bool
+	Patch            string
+	Format           string
+	JSON             bool
 }
 
 type arrayFlags []string
@@ -49,7 +61,25 @@ func Usage() {
 
 Commands:
 * add [pattern1 pattern2 ...] - add Instana sensor and instrumentation imports to all packages matching the set of patterns.
-                                 If no patterns are provided, add to all packages.
+                                 If no patterns are provided, add to all packages including the ones of workspace
+                                 and locally replaced modules.
+* instrument                   - apply instrumentation recipes to all packages of the module in the current directory.
+                                 Vendored modules provided with the -vendor flag are processed by add and instrument.
+* diff                         - print the changes made by instrument as a unified diff without writing them,
+                                 same as -dry-run instrument.
+* remove                       - revert the changes made by instrument and remove the files generated by add from all
+                                 packages of the module in the current directory.
+* check                        - report the packages that are not fully instrumented without changing any files. The exit
+                                 code combines the flags of problems found: 2 - packages without instana_go_dependency.go,
+                                 4 - files to be changed by add or instrument, 8 - instrumentation modules missing from
+                                 go.mod, 16 - vendored modules provided with -vendor are not instrumented.
+* report                       - report the call sites that are not instrumented yet, as well as the ones that cannot be
+                                 instrumented automatically, without changing any files. Use -format sarif to produce
+                                 a SARIF report for code scanning tools.
+* list                         - list the packages that can be instrumented.
+* build|test|run [go args]     - run the corresponding go command with sensor and instrumentation added to all packages
+                                 in the current directory, leaving the source files intact. Dependency modules provided
+                                 with the -deps flag are instrumented as well.
 
 Flags:
 `, os.Args[0])
@@ -62,10 +92,25 @@ func main() {
 
 	debug := flag.Bool("debug", false, "sets log level to debug")
 
-	flag.Var(&args.ExcludedPackages, "e", "Exclude package")
+	flag.Var(&args.ExcludedPackages, "e", "Exclude instrumentation recipe, or all recipes of the package (see list command)")
+	flag.StringVar(&args.BuildTags, "tags", "", "a comma-separated list of build tags to consider satisfied when selecting package files")
+	flag.Var(&args.Dependencies, "deps", "Instrument dependency module with provided path (build|test|run only)")
+	flag.Var(&args.VendoredModules, "vendor", "Process vendored module with provided path (add|instrument|check only)")
+	flag.BoolVar(&args.Strict, "strict", false, "fail instead of leaving the file unchanged, if an instrumentation recipe fails")
+	flag.BoolVar(&args.NoCache, "no-cache", false, "do not skip the packages that needed no instrumentation during previous runs (instrument|toolexec only)")
+	flag.BoolVar(&args.DryRun, "dry-run", false, "print the changes as a unified diff instead of writing them (add|instrument|remove only)")
+	flag.StringVar(&args.Patch, "patch", "", "write the changes to the patch file instead of applying them, implies -dry-run (add|instrument|remove only)")
+	flag.BoolVar(&args.JSON, "json", false, "print the results as JSON objects, one per line, and write the logs as JSON (list|add|instrument only)")
+	flag.StringVar(&args.Format, "format", reportFormatText, "the report format, text or sarif (report only)")
+	flag.IntVar(&args.Jobs, "j", runtime.GOMAXPROCS(0), "the number of packages processed in parallel (add|instrument|remove|check|report only)")
 	flag.Parse()
 
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	if args.JSON {
+		results = newResultSet()
+	} else {
+		logOutput = zerolog.ConsoleWriter{Out: os.Stderr}
+		log.Logger = log.Output(logOutput)
+	}
 	if *debug {
 		zerolog.SetGlobalLevel(zerolog.DebugLevel)
 		log.Warn().Msg("DEBUG MODE IS ON")
@@ -75,7 +120,13 @@ func main() {
 
 	for _, packageToExclude := range args.ExcludedPackages {
 		log.Info().Msgf("disable instrumentation for: %s", packageToExclude)
-		registry.Default.Unregister(packageToExclude)
+		if !registry.Default.Unregister(packageToExclude) {
+			log.Warn().Msgf("no instrumentation recipes found for %s, see `%s list` for available ones", packageToExclude, filepath.Base(os.Args[0]))
+		}
+	}
+
+	if err := checkJSONOutput(flag.Arg(0)); err != nil {
+		log.Fatal().Msg(err.Error())
 	}
 
 	switch flag.Arg(0) {
@@ -87,9 +138,26 @@ func main() {
 	case "instrument":
 		instrumentCommand()
 		return
+	case "check":
+		os.Exit(checkCommand())
+	case "report":
+		reportCommand()
+		return
+	case "remove":
+		removeCommand()
+		return
+	case "diff":
+		args.DryRun = true
+		instrumentCommand()
+		return
 	case "list":
 		listCommand()
 		return
+	case "build", "test", "run":
+		if err := overlayCommand(flag.Arg(0), flag.Args()[1:]); err != nil {
+			exitOnCmdError(err)
+		}
+		return
 	}
 
 	nextCmd := parseToolchainCmd(flag.Args())
@@ -97,6 +165,13 @@ func main() {
 		log.Fatal().Msgf("%s is expected to be executed as a part of Go build toolchain", os.Args[0])
 	}
 
+	if isToolVersionCmd(nextCmd) {
+		if err := forwardToolVersionCmd(nextCmd); err != nil {
+			exitOnCmdError(err)
+		}
+		return
+	}
+
 	nextCmdFlags, err := parseToolchainCompileArgs(nextCmd.Args[1:])
 	if err != nil {
 		log.Error().Msgf("error parsing flags: %s", err.Error())
@@ -108,48 +183,67 @@ func main() {
 	}
 
 	if filepath.Base(nextCmd.Path) == "compile" && nextCmdFlags.Complete() {
-		uniqPaths := make(map[string]struct{})
-		for _, f := range nextCmdFlags.Files {
-			uniqPaths[filepath.Dir(f)] = struct{}{}
+		if len(args.Dependencies) > 0 {
+			log.Warn().Msgf("%s: instrumentation of dependencies is only supported by build, test and run commands", nextCmdFlags.Package)
 		}
 
-		for p := range uniqPaths {
-			if !strings.HasPrefix(p, cwd) {
-				continue // ignore files outside of working dir
-			}
+		// instrumented copies of the source files are written to a private directory inside the
+		// $WORK/bNNN dir of this compile action, so that the source tree stays untouched and concurrent
+		// compile invocations never share any written files
+		workDir, err := ioutil.TempDir(filepath.Dir(nextCmdFlags.Output), "go-instana-")
+		if err != nil {
+			log.Fatal().Msgf("failed to create work dir: %s", err.Error())
+		}
 
-			p = strings.TrimPrefix(p, cwd)
-			if strings.HasPrefix(p, "/vendor/") {
-				continue // ignore vendored code
-			}
+		roots := toolexecRootDirs(filepath.Dir(filepath.Dir(nextCmdFlags.Output)), cwd)
 
-			p = strings.TrimLeft(p, "/")
-			if p == "" {
-				p = "."
+		// dependencies are type-checked using the same export data as the compiler does
+		var deps exportData
+		if nextCmdFlags.ImportCfg != "" {
+			if deps, err = readImportCfg(nextCmdFlags.ImportCfg); err != nil {
+				log.Debug().Msgf("%s: %s", nextCmdFlags.Package, err)
 			}
+		}
 
-			if err := instrumentCode(p); err != nil {
-				log.Error().Msgf("%s : failed apply instrumentation changes: %s", p, err)
+		replacements, err := instrumentCompileFiles(roots, workDir, nextCmdFlags, deps, openInstrumentationCache())
+		if err != nil {
+			var recipeErrs recipeErrors
+			if errors.As(err, &recipeErrs) {
+				log.Fatal().Msgf("%s: instrumentation failed in strict mode:\n%s", nextCmdFlags.Package, err)
 			}
+
+			log.Error().Msgf("%s : failed apply instrumentation changes: %s", nextCmdFlags.Package, err)
 		}
+
+		nextCmd.Args = append(nextCmd.Args[:1], replaceCompileFiles(nextCmd.Args[1:], replacements)...)
 	}
 
 	forwardCmd(nextCmd)
 }
 
 func forwardCmd(cmd *exec.Cmd) {
+	if err := runCmd(cmd); err != nil {
+		exitOnCmdError(err)
+	}
+}
+
+// runCmd runs the command connecting it to the standard input and output of the current process
+func runCmd(cmd *exec.Cmd) error {
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	if err := cmd.Run(); err != nil {
-		var exitErr *exec.ExitError
-		if errors.As(err, &exitErr) {
-			os.Exit(exitErr.ExitCode())
-		}
+	return cmd.Run()
+}
 
-		log.Fatal().Msg(err.Error())
+// exitOnCmdError terminates the process with the exit code of a failed command, if there is one
+func exitOnCmdError(err error) {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.ExitCode())
 	}
+
+	log.Fatal().Msg(err.Error())
 }
 
 func instanaPackageImports(fset *token.FileSet, files map[string]*ast.File) map[string]string {
@@ -171,128 +265,449 @@ func instanaPackageImports(fset *token.FileSet, files map[string]*ast.File) map[
 	return result
 }
 
-func instrumentCode(path string) error {
+// instrumentCode applies instrumentation recipes to the package located at `path` and
+// writes the changes back to the source files. If `patches` is not nil, the changes are added
+// to it instead.
+func instrumentCode(logger zerolog.Logger, path string, deps exportData, cache *instrumentationCache, patches *patchSet) error {
+	if patches == nil {
+		_, err := instrumentCodeTo(logger, path, "", deps, cache)
+		return err
+	}
+
+	changes, err := packageChanges(logger, path, deps, cache)
+	if err != nil {
+		return err
+	}
+
+	for _, fName := range sortedKeys(changes) {
+		if err := patches.Add(fName, changes[fName]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// instrumentCodeTo applies instrumentation recipes to the package located at `path`. If `outDir` is
+// empty, the changes are written back to the source files, otherwise the instrumented copies of changed
+// files are written to `outDir` leaving the original files intact. It returns the mapping between the
+// changed source files and their instrumented versions. The export data of dependencies is used to type-check
+// the package. The packages recorded in the cache as the ones that need no instrumentation are skipped.
+func instrumentCodeTo(logger zerolog.Logger, path, outDir string, deps exportData, cache *instrumentationCache) (map[string]string, error) {
+	changes, err := packageChanges(logger, path, deps, cache)
+	if err != nil {
+		return nil, err
+	}
+
+	return writeInstrumentedFiles(logger, changes, outDir), nil
+}
+
+// packageChanges applies instrumentation recipes to the package located at `path` and returns the instrumented
+// code of files that have been changed. The packages that need no instrumentation are recorded in the cache.
+func packageChanges(logger zerolog.Logger, path string, deps exportData, cache *instrumentationCache) (map[string][]byte, error) {
+	fset := token.NewFileSet()
+	logger.Info().Msgf("processing path ./%s", path)
+
+	files, err := packageFiles(path)
+	if isNoGoError(err) {
+		logger.Debug().Msgf("skip path ./%s : %s", path, err)
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	cacheKey := cache.Key(files, deps)
+	if cache.Contains(cacheKey) {
+		logger.Debug().Msgf("skip path ./%s : no changes since the last run", path)
+		return nil, nil
+	}
+
+	pkg, err := parseFiles(logger, fset, path, files)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := instrumentPackage(logger, fset, pkg, deps)
+	if err != nil {
+		return nil, err
+	}
+
+	if changes == nil {
+		cache.Add(cacheKey)
+	}
+
+	return changes, nil
+}
+
+// instrumentCompileFiles applies instrumentation recipes to the exact set of files passed to the compiler,
+// writes the instrumented copies to `outDir` and returns the mapping between the original and instrumented
+// files. Test files, files outside the `roots` dirs and vendored code are left intact. The files generated
+// by cgo are instrumented directly, if their source files satisfy these conditions. The package is type-checked
+// using the export data of dependencies passed to the compiler along with the types generated by cgo. The packages
+// recorded in the cache as the ones that need no instrumentation are skipped.
+func instrumentCompileFiles(roots []string, outDir string, compileArgs toolchainCompileArgs, deps exportData, cache *instrumentationCache) (map[string]string, error) {
+	pkgFiles := packageCompileFiles(roots, compileArgs)
+	if len(pkgFiles) == 0 {
+		return nil, nil
+	}
+
+	cacheKey := cache.Key(pkgFiles, deps)
+	if cache.Contains(cacheKey) {
+		log.Debug().Msgf("skip %s : no changes since the last run", filepath.Dir(pkgFiles[0]))
+		return nil, nil
+	}
+
 	fset := token.NewFileSet()
-	log.Info().Msgf("processing path ./%s", path)
 
-	pkgs, err := parser.ParseDir(fset, path, func(fInfo os.FileInfo) bool {
-		return !strings.HasSuffix(fInfo.Name(), "_test.go")
-	}, parser.ParseComments)
+	pkg, err := parseFiles(log.Logger, fset, filepath.Dir(pkgFiles[0]), pkgFiles)
 	if err != nil {
-		return fmt.Errorf("failed to parse source files in %q: %w", path, err)
+		return nil, err
 	}
 
-	for _, pkg := range pkgs {
-		log.Debug().Msgf("found package %s with %d file(s)", pkg.Name, len(pkg.Files))
+	changes, err := instrumentPackage(log.Logger, fset, pkg, deps)
+	if err != nil {
+		return nil, err
+	}
 
-		importedInstrumentationPackages := instanaPackageImports(fset, pkg.Files)
-		if len(importedInstrumentationPackages) == 0 {
-			log.Info().Msgf("skip package %s : imported instrumentation packages not found", pkg.Name)
+	if changes == nil {
+		cache.Add(cacheKey)
+	}
+
+	return writeInstrumentedFiles(log.Logger, changes, outDir), nil
+}
+
+// packageCompileFiles returns the files passed to the compiler that belong to the instrumented package. If there are
+// any, the file with types generated by cgo is added to them as well, since the generated package files can't be
+// type-checked without it.
+func packageCompileFiles(roots []string, compileArgs toolchainCompileArgs) []string {
+	var pkgFiles, cgoTypesFiles []string
+	for _, f := range compileArgs.Files {
+		if isCgoTypesFile(f) {
+			cgoTypesFiles = append(cgoTypesFiles, f)
 			continue
 		}
 
-		sensorName := lookupInstanaSensorInPackage(pkg)
-		if sensorName == "" {
-			log.Warn().Msgf("%s: could not find Instana sensor, skipping", pkg.Name)
+		src := f
+		if cgoSrc, ok := cgoSourceFile(f, compileArgs.TrimPath); ok {
+			src = cgoSrc
+		} else if strings.HasSuffix(f, cgoGeneratedFileSuffix) {
+			log.Warn().Msgf("%s: can't find the source file of %s generated by cgo, the file is not instrumented", compileArgs.Package, f)
 			continue
 		}
 
-		for fName, f := range pkg.Files {
-			log.Debug().Msgf("processing file %s", fName)
+		root, ok := findRootDir(roots, src)
+		if !ok {
+			continue // ignore files outside of working dir and local modules
+		}
+
+		if rel, _ := filepath.Rel(root, src); strings.HasPrefix(rel, "vendor"+string(filepath.Separator)) {
+			continue // ignore vendored code
+		}
+
+		if strings.HasSuffix(src, "_test.go") {
+			continue // test files are compiled as is
+		}
+
+		pkgFiles = append(pkgFiles, f)
+	}
+
+	if len(pkgFiles) == 0 {
+		return nil
+	}
+
+	return append(pkgFiles, cgoTypesFiles...)
+}
 
-			if err := writeNodeToFile(fset, fName, instrument(fset, fName, f, sensorName, importedInstrumentationPackages)); err != nil {
-				log.Warn().Msgf("failed to process %s: %s", fName, err)
-				continue
+// writeInstrumentedFiles writes the instrumented code either back to source files, if `outDir` is empty,
+// or to the `outDir` and returns the mapping between the source files and the written ones. The copies
+// written to `outDir` are annotated with //line directives pointing to the source files.
+func writeInstrumentedFiles(logger zerolog.Logger, changes map[string][]byte, outDir string) map[string]string {
+	fNames := make([]string, 0, len(changes))
+	for fName := range changes {
+		fNames = append(fNames, fName)
+	}
+	sort.Strings(fNames)
+
+	instrumented := make(map[string]string)
+	usedNames := make(map[string]struct{})
+	for _, fName := range fNames {
+		data := changes[fName]
+
+		dst, write := fName, writeNodeToFile
+		if outDir != "" {
+			dst = outputFileName(outDir, fName, usedNames)
+			write = func(logger zerolog.Logger, dst string, data []byte) error {
+				return writeInstrumentedCopy(logger, dst, fName, data)
 			}
 		}
+
+		if err := write(logger, dst, data); err != nil {
+			logger.Warn().Msgf("failed to process %s: %s", fName, err)
+			continue
+		}
+
+		instrumented[fName] = dst
 	}
 
-	return nil
+	return instrumented
 }
 
-func writeNodeToFile(fset *token.FileSet, fName string, node ast.Node) error {
-	tmpFile := fName + ".tmp"
+// instrumentPackage applies instrumentation recipes to the package files and returns the instrumented
+// code of files that have been changed. It returns nil if the package needs no instrumentation.
+func instrumentPackage(logger zerolog.Logger, fset *token.FileSet, pkg *ast.Package, deps exportData) (map[string][]byte, error) {
+	logger.Debug().Msgf("found package %s with %d file(s)", pkg.Name, len(pkg.Files))
 
-	fd, err := os.Create(tmpFile)
-	if err != nil {
-		log.Fatal().Msgf("failed to open %s for writing: %s", tmpFile, err)
+	importedInstrumentationPackages := instanaPackageImports(fset, pkg.Files)
+	if len(importedInstrumentationPackages) == 0 {
+		logger.Info().Msgf("skip package %s : imported instrumentation packages not found", pkg.Name)
+		return nil, nil
 	}
-	log.Debug().Msgf("temporary file %s was created", tmpFile)
 
-	err = format.Node(fd, fset, node)
-	fd.Close()
-	if err != nil {
-		return fmt.Errorf("failed to format instrumented code: %w", err)
+	sensorName := lookupInstanaSensorInPackage(pkg)
+	if sensorName == "" {
+		logger.Warn().Msgf("%s: could not find Instana sensor, skipping", pkg.Name)
+		return nil, nil
 	}
 
-	if err := fixImports(tmpFile); err != nil {
-		return err
+	return instrumentPackageWithSensor(logger, fset, pkg, deps, sensorName, importedInstrumentationPackages)
+}
+
+// instrumentPackageWithSensor applies instrumentation recipes using provided sensor variable and returns
+// the instrumented code of files that have been changed. Before applying recipes, the package is type-checked
+// with the export data of its dependencies. The instrumented package is type-checked again, and the changes
+// of files that do not compile anymore are discarded. The files, where a recipe has failed, are left unchanged.
+// In strict mode the recipe failures are returned as recipeErrors. It returns nil only if the package needs no
+// instrumentation, i.e. no recipe has failed or changed the code, otherwise the result is not nil even if all
+// changes have been discarded.
+func instrumentPackageWithSensor(logger zerolog.Logger, fset *token.FileSet, pkg *ast.Package, deps exportData, sensorName string, importedInstrumentationPackages map[string]string) (map[string][]byte, error) {
+	imp := deps.Importer(logger, fset)
+	info, typeErrs := typeCheckPackage(logger, fset, pkg, imp)
+
+	var recipeErrs recipeErrors
+
+	changes := make(map[string]instrumentedFile)
+	for fName, f := range pkg.Files {
+		if isCgoTypesFile(fName) {
+			continue // only needed to type-check the package
+		}
+
+		logger.Debug().Msgf("processing file %s", fName)
+
+		edits, err := instrument(logger, fset, info, fName, f, sensorName, importedInstrumentationPackages)
+		if err != nil {
+			logger.Error().Msgf("%s, the file is left unchanged", err)
+
+			var recipeErr *recipeError
+			if errors.As(err, &recipeErr) {
+				recipeErrs = append(recipeErrs, recipeErr)
+			}
+
+			// the AST might have been partially changed by the recipes, so the original code is used instead
+			// to type-check the package
+			if orig, err := parser.ParseFile(fset, fName, nil, parser.ParseComments); err == nil {
+				pkg.Files[fName] = orig
+			}
+
+			continue
+		}
+
+		oldData, data, err := renderChangedFile(logger, fset, fName, f)
+		if err != nil {
+			logger.Warn().Msgf("failed to process %s: %s", fName, err)
+			continue
+		}
+
+		if string(oldData) == string(data) {
+			continue
+		}
+
+		logger.Debug().Msgf("CHANGES:\n%s", unifiedDiff(patchPath(fName), oldData, data))
+
+		changes[fName] = instrumentedFile{Original: oldData, Instrumented: data, Edits: edits}
 	}
 
-	oldF, err := ioutil.ReadFile(fName)
-	if err != nil {
-		return err
+	if args.Strict && len(recipeErrs) > 0 {
+		return nil, recipeErrs
+	}
+
+	if len(changes) == 0 {
+		if len(recipeErrs) > 0 {
+			return map[string][]byte{}, nil
+		}
+
+		return nil, nil
 	}
-	newF, err := ioutil.ReadFile(tmpFile)
+
+	verified := verifyInstrumentedPackage(logger, fset, pkg, imp, typeErrs, changes)
+	for fName := range verified {
+		results.Add(fName, newFileResult(fset, fName, changes[fName].Edits))
+	}
+
+	return verified, nil
+}
+
+// renderChangedFile returns the original code of the file read from disk along with the code of the changed AST.
+// The changes are applied to the original code, so that the comments and the layout are preserved.
+func renderChangedFile(logger zerolog.Logger, fset *token.FileSet, fName string, f *ast.File) ([]byte, []byte, error) {
+	data, err := renderNode(fset, fName, f)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	if string(oldF) != string(newF) {
-		dmp := diffmatchpatch.New()
-		diffs := dmp.DiffMain(string(oldF), string(newF), false)
+	oldData, err := ioutil.ReadFile(fName)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
 
-		log.Debug().Msgf("CHANGES:\n%s", dmp.DiffPrettyText(diffs))
+	if len(oldData) > 0 {
+		if rewritten, err := rewriteFile(fset, fName, f, oldData); err != nil {
+			logger.Debug().Msgf("%s: falling back to the printed code: %s", fName, err)
+		} else {
+			data = rewritten
+		}
 	}
 
-	if err := os.Rename(tmpFile, fName); err != nil {
-		return err
-	} else {
-		log.Debug().Msgf("temporary file %s was removed", tmpFile)
-		return nil
+	return oldData, data, nil
+}
+
+// renderNode formats the instrumented node
+func renderNode(fset *token.FileSet, fName string, node ast.Node) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := format.Node(buf, fset, node); err != nil {
+		return nil, fmt.Errorf("failed to format instrumented code of %s: %w", fName, err)
 	}
+
+	return buf.Bytes(), nil
 }
 
-func fixImports(tmpFile string) error {
-	fixedImports, err := imports.Process(tmpFile, nil, &imports.Options{AllErrors: true, Comments: true})
+// outputFileName returns a name for the instrumented copy of `fName` inside `outDir`. The base name of the
+// original file is kept unless it's already taken by another file from the same set.
+func outputFileName(outDir, fName string, usedNames map[string]struct{}) string {
+	name := filepath.Base(fName)
+	for i := 1; ; i++ {
+		if _, ok := usedNames[name]; !ok {
+			break
+		}
+
+		name = fmt.Sprintf("%d_%s", i, filepath.Base(fName))
+	}
+	usedNames[name] = struct{}{}
+
+	return filepath.Join(outDir, name)
+}
+
+// writeNodeToFile writes the instrumented code to `dst` via a uniquely named temporary file, so that
+// the destination is never left in a partially written state and concurrent writers never share
+// any intermediate files
+func writeNodeToFile(logger zerolog.Logger, dst string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	fd, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
 	if err != nil {
-		return fmt.Errorf("fixing imports failed for %s : %w", tmpFile, err)
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpFile := fd.Name()
+
+	_, err = fd.Write(data)
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
 	}
 
-	fd, err := os.Create(tmpFile)
 	if err != nil {
-		log.Fatal().Msgf("failed to open %s for writing: %s", tmpFile, err)
+		os.Remove(tmpFile)
+		return fmt.Errorf("failed to write %s: %w", tmpFile, err)
+	}
+	logger.Debug().Msgf("temporary file %s was created", tmpFile)
+
+	if fi, err := os.Stat(dst); err == nil {
+		// keep the permissions of the file being replaced
+		if err := os.Chmod(tmpFile, fi.Mode().Perm()); err != nil {
+			os.Remove(tmpFile)
+			return err
+		}
+	} else if err := os.Chmod(tmpFile, 0644); err != nil {
+		os.Remove(tmpFile)
+		return err
 	}
 
-	if _, err := fd.Write(fixedImports); err != nil {
-		return fmt.Errorf("failed to write code with fixed imports: %w", err)
+	if err := os.Rename(tmpFile, dst); err != nil {
+		os.Remove(tmpFile)
+		return err
 	}
+	logger.Debug().Msgf("temporary file %s was moved to %s", tmpFile, dst)
 
-	return fd.Close()
+	return nil
 }
 
-// instrument processes an ast.File and applies instrumentation recipes to it
-func instrument(fset *token.FileSet, fName string, f *ast.File, sensorVar string, availableInstrumentationPackages map[string]string) ast.Node {
-	for pkgName, targetPkg := range buildImportsMap(f) {
-		if _, ok := availableInstrumentationPackages[registry.Default.InstrumentationImportPath(targetPkg)]; !ok {
+// instrument processes an ast.File and applies instrumentation recipes to it using the type information of the package.
+// The recipes are applied in the order defined by the registry, so that the same input always results in the same output.
+// It returns the edits made by the recipes, including the skipped ones. If a recipe fails, the instrumentation of the file
+// is stopped and *recipeError is returned.
+func instrument(logger zerolog.Logger, fset *token.FileSet, info *types.Info, fName string, f *ast.File, sensorVar string, availableInstrumentationPackages map[string]string) (registry.Edits, error) {
+	imports := buildImportsMap(f)
+	usedBefore := usedImports(f, info)
+
+	var edits registry.Edits
+	for _, name := range registry.Default.Ordered() {
+		pkgNames := imports[registry.TargetPackage(name)]
+		if len(pkgNames) == 0 {
 			continue
 		}
 
-		if recipe := registry.Default.InstrumentationRecipe(targetPkg); recipe != nil {
-			changed := recipe.Instrument(fset, f, pkgName, sensorVar)
-			if changed {
-				log.Info().Msgf("[CHANGED] file %s ", fName)
-			} else {
-				log.Debug().Msgf("[UNCHANGED] file %s ", fName)
+		recipe := registry.Default.InstrumentationRecipe(name)
+		if recipe == nil {
+			continue
+		}
+
+		if _, ok := availableInstrumentationPackages[recipe.ImportPath()]; !ok {
+			continue
+		}
+
+		for _, pkgName := range pkgNames {
+			recipeEdits, err := applyRecipe(logger, fset, info, name, recipe, fName, f, pkgName, sensorVar)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, e := range recipeEdits {
+				e.Recipe = name
+				logEdit(logger, fset, e)
+
+				edits = append(edits, e)
 			}
 		}
 	}
 
-	return f
+	if !edits.Changed() {
+		logger.Debug().Msgf("[UNCHANGED] file %s ", fName)
+		return edits, nil
+	}
+
+	// the recipes add the imports they need, so only the imports made unused by them are to be removed
+	removeUnusedImports(logger, fset, f, info, usedBefore)
+
+	return edits, nil
+}
+
+// logEdit reports the edit made by an instrumentation recipe
+func logEdit(logger zerolog.Logger, fset *token.FileSet, e registry.Edit) {
+	if e.Skipped {
+		logger.Info().Msgf("[SKIPPED] %s: %s: %s (%s)", fset.Position(e.Pos), e.Recipe, e.Message, e.Reason)
+		return
+	}
+
+	logger.Info().Msgf("[CHANGED] %s: %s: %s (%s)", fset.Position(e.Pos), e.Recipe, e.Message, e.Reason)
+	logger.Debug().Msgf("%s\n=>\n%s", e.Original, e.Replacement)
 }
 
-func buildImportsMap(f *ast.File) map[string]string {
-	m := make(map[string]string)
+// buildImportsMap returns the local names of packages imported by the file grouped by import path in order of
+// their appearance
+func buildImportsMap(f *ast.File) map[string][]string {
+	m := make(map[string][]string)
 	for _, imp := range f.Imports {
 		if imp.Path == nil {
 			log.Warn().Msgf("missing .Path in %#v", imp)
@@ -306,7 +721,7 @@ func buildImportsMap(f *ast.File) map[string]string {
 			localName = imp.Name.Name
 		}
 
-		m[localName] = impPath
+		m[impPath] = append(m[impPath], localName)
 	}
 
 	return m
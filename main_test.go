@@ -7,8 +7,12 @@ import (
 	"go/format"
 	"go/parser"
 	"go/token"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 
+	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -37,7 +41,6 @@ func main() {
 	instrumentedCode := `package main
 
 import (
-	"github.com/gin-gonic/gin"
 	instagin "github.com/instana/go-sensor/instrumentation/instagin"
 	instahttprouter "github.com/instana/go-sensor/instrumentation/instahttprouter"
 	"github.com/julienschmidt/httprouter"
@@ -55,11 +58,248 @@ func main() {
 
 	require.NoError(t, err)
 
-	instrument(fset, "test.go", f, "__instanaSensor", availableInstrumentationPkgs)
+	edits, err := instrument(log.Logger, fset, nil, "test.go", f, "__instanaSensor", availableInstrumentationPkgs)
+	require.NoError(t, err)
 
 	buf := bytes.NewBuffer(nil)
 
 	assert.NoError(t, format.Node(buf, fset, f))
 
 	assert.Equal(t, instrumentedCode, buf.String())
+
+	require.Len(t, edits, 2)
+
+	assert.Equal(t, "github.com/gin-gonic/gin", edits[0].Recipe)
+	assert.Equal(t, "replace-call", edits[0].Reason)
+	assert.Equal(t, "gin.New()", edits[0].Original)
+	assert.Equal(t, "instagin.New(__instanaSensor)", edits[0].Replacement)
+	assert.Equal(t, 9, fset.Position(edits[0].Pos).Line)
+
+	assert.Equal(t, "github.com/julienschmidt/httprouter", edits[1].Recipe)
+	assert.Equal(t, "wrap-router", edits[1].Reason)
+	assert.Equal(t, 11, fset.Position(edits[1].Pos).Line)
+}
+
+func TestInstrument_Deterministic(t *testing.T) {
+	availableInstrumentationPkgs := map[string]string{
+		"github.com/instana/go-sensor":                                 "_",
+		"github.com/instana/go-sensor/instrumentation/instagin":        "_",
+		"github.com/instana/go-sensor/instrumentation/instahttprouter": "_",
+		"github.com/instana/go-sensor/instrumentation/instasarama":     "_",
+	}
+
+	originalCode := `package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/Shopify/sarama"
+	"github.com/gin-gonic/gin"
+	"github.com/julienschmidt/httprouter"
+)
+
+func main() {
+	engine := gin.New()
+	router := httprouter.New()
+
+	http.Handle("/", router)
+	http.Handle("/gin", engine)
+
+	sql.Open("postgres", "")
+	sarama.NewSyncProducer(nil, nil)
+}
+`
+
+	var expected string
+	for i := 0; i < 20; i++ {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, "", originalCode, parser.AllErrors)
+		require.NoError(t, err)
+
+		_, err = instrument(log.Logger, fset, nil, "test.go", f, "__instanaSensor", availableInstrumentationPkgs)
+		require.NoError(t, err)
+
+		buf := bytes.NewBuffer(nil)
+		require.NoError(t, format.Node(buf, fset, f))
+
+		if i == 0 {
+			expected = buf.String()
+			continue
+		}
+
+		require.Equal(t, expected, buf.String(), "the output is expected to be the same on every run")
+	}
+}
+
+func TestBuildImportsMap(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "", `package main
+
+import (
+	"net/http"
+	web "net/http"
+	_ "database/sql"
+	"github.com/labstack/echo/v4"
+)
+`, parser.ImportsOnly)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string][]string{
+		"net/http":                    {"http", "web"},
+		"database/sql":                {"_"},
+		"github.com/labstack/echo/v4": {"echo"},
+	}, buildImportsMap(f))
+}
+
+func TestInstrumentCodeTo_KeepsSourcesIntact(t *testing.T) {
+	srcDir := t.TempDir()
+	outDir := t.TempDir()
+
+	originalCode := `package main
+
+import "net/http"
+
+func main() {
+	http.HandleFunc("/", http.NotFound)
+}
+`
+
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "main.go"), []byte(originalCode), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, instanaGoFileName), []byte(`// Code generated by go-instana, DO NOT EDIT.
+
+package main
+
+import instana "github.com/instana/go-sensor"
+
+var __instanaSensor = instana.NewSensor("")
+`), 0644))
+
+	instrumented, err := instrumentCodeTo(log.Logger, srcDir, outDir, nil, nil)
+	require.NoError(t, err)
+
+	srcFile, dstFile := filepath.Join(srcDir, "main.go"), filepath.Join(outDir, "main.go")
+	assert.Equal(t, map[string]string{srcFile: dstFile}, instrumented)
+
+	data, err := os.ReadFile(srcFile)
+	require.NoError(t, err)
+	assert.Equal(t, originalCode, string(data))
+
+	data, err = os.ReadFile(dstFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `instana.TracingHandlerFunc(__instanaSensor, "/", http.NotFound)`)
+
+	assert.NoFileExists(t, filepath.Join(outDir, instanaGoFileName))
+}
+
+func TestInstrumentCompileFiles(t *testing.T) {
+	srcDir := t.TempDir()
+	outDir := t.TempDir()
+
+	code := `package main
+
+import "net/http"
+
+func main() {
+	http.HandleFunc("/", http.NotFound)
+}
+`
+
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "main.go"), []byte(code), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "main_other.go"), []byte(code), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "main_test.go"), []byte(code), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, instanaGoFileName), []byte(`// Code generated by go-instana, DO NOT EDIT.
+
+package main
+
+import instana "github.com/instana/go-sensor"
+
+var __instanaSensor = instana.NewSensor("")
+`), 0644))
+
+	// only files passed to the compiler are expected to be instrumented
+	replacements, err := instrumentCompileFiles([]string{srcDir}, outDir, toolchainCompileArgs{Files: []string{
+		filepath.Join(srcDir, "main.go"),
+		filepath.Join(srcDir, "main_test.go"),
+		filepath.Join(srcDir, instanaGoFileName),
+		"/usr/local/go/src/fmt/print.go",
+	}}, nil, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		filepath.Join(srcDir, "main.go"): filepath.Join(outDir, "main.go"),
+	}, replacements)
+}
+
+func TestInstrumentCompileFiles_Concurrent(t *testing.T) {
+	srcDir := t.TempDir()
+
+	originalCode := `package main
+
+import "net/http"
+
+func main() {
+	http.HandleFunc("/", http.NotFound)
+}
+`
+
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "main.go"), []byte(originalCode), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, instanaGoFileName), []byte(`// Code generated by go-instana, DO NOT EDIT.
+
+package main
+
+import instana "github.com/instana/go-sensor"
+
+var __instanaSensor = instana.NewSensor("")
+`), 0644))
+
+	files := []string{filepath.Join(srcDir, instanaGoFileName), filepath.Join(srcDir, "main.go")}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		outDir := t.TempDir()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			replacements, err := instrumentCompileFiles([]string{srcDir}, outDir, toolchainCompileArgs{Files: files}, nil, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]string{files[1]: filepath.Join(outDir, "main.go")}, replacements)
+		}()
+	}
+	wg.Wait()
+
+	entries, err := os.ReadDir(srcDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "no temporary files are expected to be left in the source dir")
+
+	data, err := os.ReadFile(files[1])
+	require.NoError(t, err)
+	assert.Equal(t, originalCode, string(data))
+}
+
+func TestWriteNodeToFile_Concurrent(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "main.go")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, writeNodeToFile(log.Logger, dst, []byte("package main\n")))
+		}()
+	}
+	wg.Wait()
+
+	entries, err := os.ReadDir(filepath.Dir(dst))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary files are expected to be left")
+}
+
+func TestOutputFileName(t *testing.T) {
+	usedNames := make(map[string]struct{})
+
+	assert.Equal(t, "/out/main.go", outputFileName("/out", "/src/main.go", usedNames))
+	assert.Equal(t, "/out/1_main.go", outputFileName("/out", "/work/b001/main.go", usedNames))
+	assert.Equal(t, "/out/2_main.go", outputFileName("/out", "/work/b002/main.go", usedNames))
 }
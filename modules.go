@@ -0,0 +1,247 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// localModule is a module, which source code is located on the local disk and considered to be
+// a part of the project
+type localModule struct {
+	Path string
+	Dir  string
+}
+
+type goModuleVersion struct {
+	Path    string
+	Version string
+}
+
+type goModReplace struct {
+	Old goModuleVersion
+	New goModuleVersion
+}
+
+// goModJSON is the subset of the `go mod edit -json` output used by go-instana
+type goModJSON struct {
+	Module  goModuleVersion
+	Require []goModuleVersion
+	Replace []goModReplace
+}
+
+// goWorkJSON is the subset of the `go work edit -json` output used by go-instana
+type goWorkJSON struct {
+	Use []struct {
+		DiskPath string
+	}
+	Replace []goModReplace
+}
+
+// localModules returns the list of modules that are instrumentable for the project in `dir`. These
+// are the main modules, i.e. the module containing `dir` or all modules of the go.work workspace, along
+// with the modules replaced with a local directory by a `replace` directive. The returned list is sorted
+// by module directory.
+func localModules(dir string) ([]localModule, error) {
+	var env struct {
+		GOMOD  string
+		GOWORK string
+	}
+
+	if err := goJSON(dir, &env, "env", "-json", "GOMOD", "GOWORK"); err != nil {
+		return nil, err
+	}
+
+	var (
+		mainModuleDirs []string
+		replaces       []goModReplace
+		replacesDirs   []string
+	)
+
+	if env.GOWORK != "" && env.GOWORK != "off" {
+		var work goWorkJSON
+		if err := goJSON(dir, &work, "work", "edit", "-json", env.GOWORK); err != nil {
+			return nil, err
+		}
+
+		workDir := filepath.Dir(env.GOWORK)
+		for _, use := range work.Use {
+			mainModuleDirs = append(mainModuleDirs, resolveModuleDir(workDir, use.DiskPath))
+		}
+
+		for _, r := range work.Replace {
+			replaces = append(replaces, r)
+			replacesDirs = append(replacesDirs, workDir)
+		}
+	} else if env.GOMOD != "" && env.GOMOD != os.DevNull {
+		mainModuleDirs = append(mainModuleDirs, filepath.Dir(env.GOMOD))
+	}
+
+	modules := make(map[string]localModule)
+	for _, modDir := range mainModuleDirs {
+		var mod goModJSON
+		if err := goJSON(dir, &mod, "mod", "edit", "-json", filepath.Join(modDir, "go.mod")); err != nil {
+			return nil, err
+		}
+
+		modules[modDir] = localModule{Path: mod.Module.Path, Dir: modDir}
+
+		for _, r := range mod.Replace {
+			replaces = append(replaces, r)
+			replacesDirs = append(replacesDirs, modDir)
+		}
+	}
+
+	for i, r := range replaces {
+		if !isLocalReplace(r) || isInstanaModule(r.Old.Path) {
+			continue
+		}
+
+		modDir := resolveModuleDir(replacesDirs[i], r.New.Path)
+		if _, ok := modules[modDir]; !ok {
+			modules[modDir] = localModule{Path: r.Old.Path, Dir: modDir}
+		}
+	}
+
+	result := make([]localModule, 0, len(modules))
+	for _, mod := range modules {
+		result = append(result, mod)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Dir < result[j].Dir
+	})
+
+	return result, nil
+}
+
+// isLocalReplace returns whether the replace directive points to a directory on the local disk
+func isLocalReplace(r goModReplace) bool {
+	if r.New.Version != "" {
+		return false
+	}
+
+	p := filepath.ToSlash(r.New.Path)
+
+	return filepath.IsAbs(r.New.Path) || p == "." || p == ".." || strings.HasPrefix(p, "./") || strings.HasPrefix(p, "../")
+}
+
+// isInstanaModule returns whether the module path belongs to the Instana Go sensor or one of its
+// instrumentation modules. Local copies of these modules are never instrumented.
+func isInstanaModule(modPath string) bool {
+	return modPath == SensorPackage || strings.HasPrefix(modPath, SensorPackage+"/")
+}
+
+func resolveModuleDir(baseDir, p string) string {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(baseDir, p)
+	}
+
+	return filepath.Clean(p)
+}
+
+// goJSON runs the go command with provided args in `dir` and decodes its JSON output into `v`
+func goJSON(dir string, v interface{}, args ...string) error {
+	stdout, stderr := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("go %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+
+	if err := json.Unmarshal(stdout.Bytes(), v); err != nil {
+		return fmt.Errorf("go %s: failed to parse output: %w", strings.Join(args, " "), err)
+	}
+
+	return nil
+}
+
+// moduleDirs returns the list of directories of provided modules
+func moduleDirs(modules []localModule) []string {
+	dirs := make([]string, 0, len(modules))
+	for _, mod := range modules {
+		dirs = append(dirs, mod.Dir)
+	}
+
+	return dirs
+}
+
+// isInsideDir returns whether the path `p` is located inside `dir`
+func isInsideDir(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// findRootDir returns the innermost dir from the list that contains the path `p`
+func findRootDir(roots []string, p string) (string, bool) {
+	var found string
+	for _, root := range roots {
+		if isInsideDir(root, p) && len(root) > len(found) {
+			found = root
+		}
+	}
+
+	return found, found != ""
+}
+
+// projectRootDirs returns the list of dirs containing the instrumentable code for the project located in `cwd`.
+// This list includes the `cwd` itself along with the directories of all local modules.
+func projectRootDirs(cwd string) []string {
+	roots := []string{cwd}
+
+	absCwd, err := filepath.Abs(cwd)
+	if err != nil {
+		log.Debug().Msgf("failed to get absolute path of %s: %s", cwd, err)
+		return roots
+	}
+
+	modules, err := localModules(absCwd)
+	if err != nil {
+		log.Debug().Msgf("failed to lookup local modules: %s", err)
+		return roots
+	}
+
+	for _, dir := range moduleDirs(modules) {
+		if !isInsideDir(absCwd, dir) {
+			roots = append(roots, dir)
+		}
+	}
+
+	return roots
+}
+
+// toolexecRootDirs returns the list of project root dirs for the toolexec mode. Since the go command invokes
+// go-instana for each package, the list is cached in the $WORK dir of the current build.
+func toolexecRootDirs(goWorkDir, cwd string) []string {
+	cacheFile := filepath.Join(goWorkDir, "go-instana-roots.json")
+
+	var roots []string
+	if data, err := ioutil.ReadFile(cacheFile); err == nil && json.Unmarshal(data, &roots) == nil && len(roots) > 0 {
+		return roots
+	}
+
+	roots = projectRootDirs(cwd)
+
+	if data, err := json.Marshal(roots); err == nil {
+		if err := writeNodeToFile(log.Logger, cacheFile, data); err != nil {
+			log.Debug().Msgf("failed to cache project root dirs: %s", err)
+		}
+	}
+
+	return roots
+}
@@ -0,0 +1,91 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalModules(t *testing.T) {
+	root := t.TempDir()
+
+	writeFile(t, filepath.Join(root, "app", "go.mod"), `module example.com/app
+
+go 1.18
+
+require (
+	example.com/shared v0.0.0
+	example.com/remote v1.0.0
+	github.com/instana/go-sensor v1.24.0
+)
+
+replace example.com/shared => ../shared
+
+replace example.com/remote => example.com/fork v1.0.1
+
+replace github.com/instana/go-sensor => ../go-sensor
+`)
+	writeFile(t, filepath.Join(root, "shared", "go.mod"), "module example.com/shared\n\ngo 1.18\n")
+
+	t.Setenv("GOWORK", "off")
+	t.Setenv("GOFLAGS", "")
+
+	modules, err := localModules(filepath.Join(root, "app"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []localModule{
+		{Path: "example.com/app", Dir: filepath.Join(root, "app")},
+		{Path: "example.com/shared", Dir: filepath.Join(root, "shared")},
+	}, modules)
+}
+
+func TestLocalModules_Workspace(t *testing.T) {
+	root := t.TempDir()
+
+	writeFile(t, filepath.Join(root, "go.work"), "go 1.18\n\nuse (\n\t./app\n\t./lib\n)\n")
+	writeFile(t, filepath.Join(root, "app", "go.mod"), "module example.com/app\n\ngo 1.18\n")
+	writeFile(t, filepath.Join(root, "lib", "go.mod"), "module example.com/lib\n\ngo 1.18\n")
+
+	t.Setenv("GOWORK", "")
+	t.Setenv("GOFLAGS", "")
+
+	modules, err := localModules(filepath.Join(root, "app"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []localModule{
+		{Path: "example.com/app", Dir: filepath.Join(root, "app")},
+		{Path: "example.com/lib", Dir: filepath.Join(root, "lib")},
+	}, modules)
+
+	t.Run("project root dirs", func(t *testing.T) {
+		assert.Equal(t, []string{filepath.Join(root, "app"), filepath.Join(root, "lib")}, projectRootDirs(filepath.Join(root, "app")))
+		assert.Equal(t, []string{root}, projectRootDirs(root))
+	})
+}
+
+func TestFindRootDir(t *testing.T) {
+	roots := []string{"/src/app", "/src/lib", "/src/app/nested"}
+
+	root, ok := findRootDir(roots, "/src/app/main.go")
+	assert.True(t, ok)
+	assert.Equal(t, "/src/app", root)
+
+	root, ok = findRootDir(roots, "/src/app/nested/pkg/main.go")
+	assert.True(t, ok)
+	assert.Equal(t, "/src/app/nested", root)
+
+	_, ok = findRootDir(roots, "/src/application/main.go")
+	assert.False(t, ok)
+}
+
+func writeFile(t *testing.T, fName, content string) {
+	t.Helper()
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(fName), 0755))
+	require.NoError(t, os.WriteFile(fName, []byte(content), 0644))
+}
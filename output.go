@@ -0,0 +1,201 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/instana/go-instana/internal/registry"
+)
+
+// results collects the results of add and instrument commands to be written as JSON once the command is finished.
+// It is nil unless the -json flag is set.
+var results *resultSet
+
+// resultSet collects the results of a command keyed by the path of package or file they describe. It is safe for
+// concurrent use.
+type resultSet struct {
+	mu      sync.Mutex
+	results map[string]interface{}
+}
+
+// newResultSet returns an empty resultSet
+func newResultSet() *resultSet {
+	return &resultSet{
+		results: make(map[string]interface{}),
+	}
+}
+
+// Add adds the result with provided key to the set. It is a no-op for a nil resultSet, so that the results are only
+// collected if requested.
+func (rs *resultSet) Add(key string, res interface{}) {
+	if rs == nil {
+		return
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.results[key] = res
+}
+
+// WriteTo writes the results as JSON objects sorted by their keys, one per line
+func (rs *resultSet) WriteTo(w io.Writer) (int64, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	buf := bytes.NewBuffer(nil)
+	for _, key := range sortedKeys(rs.results) {
+		if err := writeJSON(buf, rs.results[key]); err != nil {
+			return 0, err
+		}
+	}
+
+	return buf.WriteTo(w)
+}
+
+// writeResults writes the collected results to stdout, if they have been requested with the -json flag
+func writeResults() error {
+	if results == nil {
+		return nil
+	}
+
+	_, err := results.WriteTo(os.Stdout)
+
+	return err
+}
+
+// plainOutputCommands are the commands that print their output to stdout as plain text and don't support -json flag
+var plainOutputCommands = map[string]bool{
+	"check":  true,
+	"report": true,
+	"remove": true,
+	"build":  true,
+	"test":   true,
+	"run":    true,
+}
+
+// checkJSONOutput returns an error if the -json flag is provided to a command that prints plain text to stdout,
+// or if the results of the command would be printed to stdout along with the unified diff in dry-run mode. In the
+// latter case the changes are expected to be written to a file with -patch flag instead.
+func checkJSONOutput(cmd string) error {
+	if !args.JSON {
+		return nil
+	}
+
+	if plainOutputCommands[cmd] {
+		return fmt.Errorf("-json is not supported by %s command", cmd)
+	}
+
+	if args.Patch != "" {
+		return nil
+	}
+
+	if cmd == "diff" || (args.DryRun && (cmd == "add" || cmd == "instrument")) {
+		return errors.New("-json can't be used to print the changes to stdout, use -patch to write them to a file")
+	}
+
+	return nil
+}
+
+// writeJSON writes the value as a single-line JSON object followed by a newline
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+
+	return enc.Encode(v)
+}
+
+// recipeResult describes an instrumentation recipe in the output of list command
+type recipeResult struct {
+	// Name is the name of the recipe, that can be used with -e flag
+	Name string `json:"name"`
+	// TargetPackage is the import path of the instrumented package
+	TargetPackage string `json:"targetPackage"`
+	// ImportPath is the import path of the instrumentation package
+	ImportPath string `json:"importPath"`
+	// Functions are the functions and methods of the instrumented package transformed by the recipe
+	Functions []string `json:"functions"`
+}
+
+// newRecipeResult returns the description of the recipe registered with provided name
+func newRecipeResult(name string, recipe registry.Recipe) recipeResult {
+	res := recipeResult{
+		Name:          name,
+		TargetPackage: registry.TargetPackage(name),
+		ImportPath:    recipe.ImportPath(),
+		Functions:     []string{},
+	}
+
+	if describer, ok := recipe.(registry.Describer); ok {
+		res.Functions = describer.Functions()
+	}
+
+	return res
+}
+
+// packageResult describes the changes made to a package by add command
+type packageResult struct {
+	// Package is the path of the package directory relative to the current one
+	Package string `json:"package"`
+	// File is the path of the instanaGoFileName file relative to the current directory
+	File string `json:"file"`
+	// Status is one of created, updated, removed or unchanged
+	Status string `json:"status"`
+	// SensorAdded is true if the file declares a new instance of the sensor
+	SensorAdded bool `json:"sensorAdded"`
+	// Imports are the instrumentation packages imported by the file
+	Imports []string `json:"imports"`
+}
+
+// fileResult describes the changes made to a file by instrument command
+type fileResult struct {
+	// File is the path of the file relative to the current directory
+	File string `json:"file"`
+	// Package is the path of the package directory relative to the current one
+	Package string `json:"package"`
+	// Edits are the edits made or skipped by instrumentation recipes
+	Edits []editResult `json:"edits"`
+}
+
+// editResult describes an edit made or skipped by an instrumentation recipe
+type editResult struct {
+	Recipe  string `json:"recipe"`
+	Reason  string `json:"reason"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+	Skipped bool   `json:"skipped"`
+}
+
+// newFileResult returns the description of the changes made to the file
+func newFileResult(fset *token.FileSet, fName string, edits registry.Edits) fileResult {
+	res := fileResult{
+		File:    patchPath(fName),
+		Package: patchPath(filepath.Dir(fName)),
+		Edits:   []editResult{},
+	}
+
+	for _, e := range edits {
+		pos := fset.Position(e.Pos)
+
+		res.Edits = append(res.Edits, editResult{
+			Recipe:  e.Recipe,
+			Reason:  e.Reason,
+			Line:    pos.Line,
+			Column:  pos.Column,
+			Message: e.Message,
+			Skipped: e.Skipped,
+		})
+	}
+
+	return res
+}
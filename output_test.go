@@ -0,0 +1,140 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/instana/go-instana/internal/registry"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResultSet_WriteTo(t *testing.T) {
+	rs := newResultSet()
+	rs.Add("b", map[string]string{"name": "b"})
+	rs.Add("a", map[string]string{"name": "a&b"})
+
+	buf := bytes.NewBuffer(nil)
+	_, err := rs.WriteTo(buf)
+	require.NoError(t, err)
+
+	assert.Equal(t, "{\"name\":\"a&b\"}\n{\"name\":\"b\"}\n", buf.String())
+}
+
+func TestResultSet_Nil(t *testing.T) {
+	var rs *resultSet
+
+	assert.NotPanics(t, func() {
+		rs.Add("a", "result")
+	})
+}
+
+func TestCheckJSONOutput(t *testing.T) {
+	defer func(json, dryRun bool, patch string) {
+		args.JSON, args.DryRun, args.Patch = json, dryRun, patch
+	}(args.JSON, args.DryRun, args.Patch)
+
+	examples := map[string]struct {
+		Command     string
+		JSON        bool
+		DryRun      bool
+		Patch       string
+		ExpectError bool
+	}{
+		"instrument":                {Command: "instrument", JSON: true},
+		"instrument without json":   {Command: "instrument", DryRun: true},
+		"instrument dry-run":        {Command: "instrument", JSON: true, DryRun: true, ExpectError: true},
+		"instrument dry-run, patch": {Command: "instrument", JSON: true, DryRun: true, Patch: "changes.patch"},
+		"add dry-run":               {Command: "add", JSON: true, DryRun: true, ExpectError: true},
+		"add patch":                 {Command: "add", JSON: true, Patch: "changes.patch"},
+		"diff":                      {Command: "diff", JSON: true, ExpectError: true},
+		"diff patch":                {Command: "diff", JSON: true, Patch: "changes.patch"},
+		"list dry-run":              {Command: "list", JSON: true, DryRun: true},
+		"check":                     {Command: "check", JSON: true, ExpectError: true},
+		"check without json":        {Command: "check"},
+		"report":                    {Command: "report", JSON: true, ExpectError: true},
+		"remove patch":              {Command: "remove", JSON: true, Patch: "changes.patch", ExpectError: true},
+		"build":                     {Command: "build", JSON: true, ExpectError: true},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			args.JSON, args.DryRun, args.Patch = example.JSON, example.DryRun, example.Patch
+
+			err := checkJSONOutput(example.Command)
+			if example.ExpectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestNewRecipeResult(t *testing.T) {
+	name := registry.RecipeName("net/http", "server")
+
+	assert.Equal(t, recipeResult{
+		Name:          "net/http:server",
+		TargetPackage: "net/http",
+		ImportPath:    SensorPackage,
+		Functions:     []string{"(*ServeMux).Handle", "(*ServeMux).HandleFunc", "Handle", "HandleFunc"},
+	}, newRecipeResult(name, registry.Default.InstrumentationRecipe(name)))
+}
+
+func TestAddPackage_Results(t *testing.T) {
+	chdir(t, t.TempDir())
+	collectResults(t)
+
+	writeFile(t, "main.go", "package main\n\nimport \"net/http\"\n\nfunc main() {\n\thttp.HandleFunc(\"/\", http.NotFound)\n}\n")
+
+	require.NoError(t, addPackage(log.Logger, ".", nil))
+	require.NoError(t, addPackage(log.Logger, ".", nil))
+
+	assert.Equal(t, map[string]interface{}{
+		".": packageResult{
+			Package:     ".",
+			File:        instanaGoFileName,
+			Status:      "unchanged",
+			SensorAdded: true,
+			Imports:     []string{SensorPackage},
+		},
+	}, results.results)
+
+	writeFile(t, "main.go", "package main\n\nfunc main() {}\n")
+	require.NoError(t, addPackage(log.Logger, ".", nil))
+
+	assert.Equal(t, "updated", results.results["."].(packageResult).Status)
+	assert.True(t, results.results["."].(packageResult).SensorAdded)
+	assert.Empty(t, results.results["."].(packageResult).Imports)
+}
+
+func TestInstrumentCode_Results(t *testing.T) {
+	chdir(t, t.TempDir())
+	collectResults(t)
+
+	writeFile(t, "main.go", "package main\n\nimport \"net/http\"\n\nfunc main() {\n\thttp.HandleFunc(\"/\", http.NotFound)\n}\n")
+	require.NoError(t, addPackage(log.Logger, ".", nil))
+
+	results = newResultSet()
+	require.NoError(t, instrumentCode(log.Logger, ".", nil, nil, nil))
+
+	buf := bytes.NewBuffer(nil)
+	_, err := results.WriteTo(buf)
+	require.NoError(t, err)
+
+	assert.Equal(t, `{"file":"main.go","package":".","edits":[{"recipe":"net/http:server","reason":"wrap-handler","line":6,"column":2,"message":"the handler is wrapped with instana.TracingHandlerFunc()","skipped":false}]}`+"\n", buf.String())
+}
+
+// collectResults enables the collection of command results for the duration of the test
+func collectResults(t *testing.T) {
+	t.Helper()
+
+	results = newResultSet()
+	t.Cleanup(func() {
+		results = nil
+	})
+}
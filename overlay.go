@@ -0,0 +1,255 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// goOverlay is the format of the file passed to the go tool with the -overlay flag
+type goOverlay struct {
+	Replace map[string]string
+}
+
+// overlayCommand handles the `go-instana build|test|run` execution. It adds Instana sensor and applies
+// instrumentation recipes in memory to all packages in the current directory, writes the instrumented
+// files along with the overlay file to the cache directory and runs the `go <goCmd> -overlay=...`
+// command. The source files of the project are left intact.
+func overlayCommand(goCmd string, goArgs []string) error {
+	log.Info().Msgf(`start "%s" command`, goCmd)
+	defer log.Info().Msgf(`finish "%s" command`, goCmd)
+
+	cwd, err := filepath.Abs(".")
+	if err != nil {
+		return fmt.Errorf("failed to get current working dir: %w", err)
+	}
+
+	cacheDir, err := overlayDir(cwd)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create overlay dir: %w", err)
+	}
+
+	// each invocation uses its own output dir, so that concurrent builds of the same project never
+	// overwrite instrumented files used by each other
+	outDir, err := ioutil.TempDir(cacheDir, "build-")
+	if err != nil {
+		return fmt.Errorf("failed to create overlay dir: %w", err)
+	}
+	defer os.RemoveAll(outDir)
+
+	// packages of the workspace and locally replaced modules are instrumented as well
+	overlay := goOverlay{Replace: make(map[string]string)}
+	for i, root := range projectRootDirs(cwd) {
+		rootOverlay, err := buildOverlay(root, filepath.Join(outDir, strconv.Itoa(i)), []string{"./..."})
+		if err != nil {
+			return err
+		}
+
+		for src, dst := range rootOverlay.Replace {
+			overlay.Replace[src] = dst
+		}
+	}
+
+	// dependency modules are instrumented only if explicitly allowed
+	var modFile string
+	if len(args.Dependencies) > 0 {
+		deps, err := instrumentDependencies(cwd, outDir, args.Dependencies)
+		if err != nil {
+			return err
+		}
+
+		for src, dst := range deps.Overlay.Replace {
+			overlay.Replace[src] = dst
+		}
+
+		modFile = deps.ModFile
+	}
+
+	overlayFile, err := writeOverlayFile(outDir, overlay)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("go", overlayGoArgs(goCmd, overlayFile, modFile, goArgs)...)
+	log.Debug().Msgf("running %s", cmd.String())
+
+	return runCmd(cmd)
+}
+
+// overlayGoArgs returns go tool arguments to run the command with provided overlay file and an optional go.mod file
+func overlayGoArgs(goCmd, overlayFile, modFile string, goArgs []string) []string {
+	result := []string{goCmd, "-overlay=" + overlayFile}
+	if modFile != "" {
+		result = append(result, "-modfile="+modFile)
+	}
+
+	return append(result, goArgs...)
+}
+
+// overlayDir returns the directory to store instrumented files of the project located in `projectDir`
+func overlayDir(projectDir string) (string, error) {
+	cacheDir, err := goInstanaCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.Sum256([]byte(projectDir))
+
+	return filepath.Join(cacheDir, "overlay", hex.EncodeToString(h[:8])), nil
+}
+
+// goInstanaCacheDir returns the root directory for files cached by go-instana
+func goInstanaCacheDir() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine user cache dir: %w", err)
+	}
+
+	return filepath.Join(cacheDir, "go-instana"), nil
+}
+
+// buildOverlay instruments packages under the `root` dir that match provided patterns and writes
+// instrumented files to `outDir`. It returns the overlay that substitutes the original files with
+// the instrumented ones.
+func buildOverlay(root, outDir string, patterns []string) (goOverlay, error) {
+	overlay := goOverlay{Replace: make(map[string]string)}
+
+	paths, err := collectSourcePaths(os.DirFS(root), patterns)
+	if err != nil {
+		return overlay, fmt.Errorf("failed to lookup source code directories: %w", err)
+	}
+
+	deps := loadExportData(root, patterns...)
+
+	for _, path := range paths {
+		log.Info().Msgf("processing path %s", path)
+
+		changes, err := overlayPackage(log.Logger, filepath.Join(root, path), deps)
+		if err != nil {
+			log.Warn().Msgf("%s: skipping package: %s", path, err)
+			continue
+		}
+
+		for fName, data := range changes {
+			rel, err := filepath.Rel(root, fName)
+			if err != nil {
+				return overlay, fmt.Errorf("failed to find relative path of %s: %w", fName, err)
+			}
+
+			dst := filepath.Join(outDir, rel)
+			if err := writeInstrumentedCopy(log.Logger, dst, fName, data); err != nil {
+				return overlay, fmt.Errorf("failed to write %s: %w", dst, err)
+			}
+
+			overlay.Replace[fName] = dst
+		}
+	}
+
+	return overlay, nil
+}
+
+// overlayPackage applies both `add` and `instrument` steps in memory to the package located in `path`
+// and returns the content of files that have been changed or added
+func overlayPackage(logger zerolog.Logger, path string, deps exportData) (map[string][]byte, error) {
+	fset := token.NewFileSet()
+
+	pkg, changes, err := loadAddedPackage(logger, fset, path)
+	if err != nil {
+		return nil, err
+	}
+
+	instrumented, err := instrumentPackage(logger, fset, pkg, deps)
+	if err != nil {
+		return nil, err
+	}
+
+	for fName, data := range instrumented {
+		changes[fName] = data
+	}
+
+	return changes, nil
+}
+
+// loadAddedPackage loads the package located in `path` and applies the `add` step to it in memory. It returns
+// the package along with the content of the instanaGoFileName file, if it has been changed or added.
+func loadAddedPackage(logger zerolog.Logger, fset *token.FileSet, path string) (*ast.Package, map[string][]byte, error) {
+	pkg, err := findPackageInPath(logger, path, fset)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// a file generated by go-instana is regenerated the same way as `go-instana add` does it
+	filePath := filepath.Join(path, instanaGoFileName)
+	if _, ok := pkg.Files[filePath]; ok {
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %w", filePath, err)
+		}
+
+		if isGeneratedByGoInstana(bytes.NewBuffer(data)) {
+			delete(pkg.Files, filePath)
+		}
+	}
+
+	changes := make(map[string][]byte)
+	if _, ok := pkg.Files[filePath]; !ok {
+		content, err := instanaGoFileContent(filePath, pkg)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if content != nil {
+			f, err := parser.ParseFile(fset, filePath, content, parser.ParseComments)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse generated %s: %w", filePath, err)
+			}
+
+			pkg.Files[filePath] = f
+
+			if oldContent, err := ioutil.ReadFile(filePath); err != nil || !bytes.Equal(oldContent, content) {
+				changes[filePath] = content
+			}
+		}
+	}
+
+	return pkg, changes, nil
+}
+
+// writeOverlayFile writes the overlay to a new file in `dir` and returns its name
+func writeOverlayFile(dir string, overlay goOverlay) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create overlay dir: %w", err)
+	}
+
+	fd, err := ioutil.TempFile(dir, "overlay-*.json")
+	if err != nil {
+		return "", fmt.Errorf("failed to create overlay file: %w", err)
+	}
+	defer fd.Close()
+
+	if err := json.NewEncoder(fd).Encode(overlay); err != nil {
+		os.Remove(fd.Name())
+		return "", fmt.Errorf("failed to write overlay file: %w", err)
+	}
+
+	return fd.Name(), nil
+}
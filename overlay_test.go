@@ -0,0 +1,74 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildOverlay(t *testing.T) {
+	root := t.TempDir()
+	outDir := t.TempDir()
+
+	originalCode := `package main
+
+import "net/http"
+
+func main() {
+	http.HandleFunc("/", http.NotFound)
+}
+`
+	require.NoError(t, os.WriteFile(filepath.Join(root, "main.go"), []byte(originalCode), 0644))
+
+	overlay, err := buildOverlay(root, outDir, []string{"./..."})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		filepath.Join(root, "main.go"):         filepath.Join(outDir, "main.go"),
+		filepath.Join(root, instanaGoFileName): filepath.Join(outDir, instanaGoFileName),
+	}, overlay.Replace)
+
+	// the source tree must stay intact
+	data, err := os.ReadFile(filepath.Join(root, "main.go"))
+	require.NoError(t, err)
+	assert.Equal(t, originalCode, string(data))
+	assert.NoFileExists(t, filepath.Join(root, instanaGoFileName))
+
+	data, err = os.ReadFile(filepath.Join(outDir, "main.go"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `instana.TracingHandlerFunc(__instanaSensor, "/", http.NotFound)`)
+
+	data, err = os.ReadFile(filepath.Join(outDir, instanaGoFileName))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `var __instanaSensor = instana.NewSensor("")`)
+
+	t.Run("overlay file", func(t *testing.T) {
+		fName, err := writeOverlayFile(outDir, overlay)
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(fName)
+		require.NoError(t, err)
+
+		var written goOverlay
+		require.NoError(t, json.Unmarshal(data, &written))
+		assert.Equal(t, overlay, written)
+	})
+}
+
+func TestOverlayGoArgs(t *testing.T) {
+	assert.Equal(t,
+		[]string{"run", "-overlay=/tmp/overlay.json", "-race", ".", "-port", "8080"},
+		overlayGoArgs("run", "/tmp/overlay.json", "", []string{"-race", ".", "-port", "8080"}),
+	)
+
+	assert.Equal(t,
+		[]string{"build", "-overlay=/tmp/overlay.json", "-modfile=/tmp/deps/go.mod", "./..."},
+		overlayGoArgs("build", "/tmp/overlay.json", "/tmp/deps/go.mod", []string{"./..."}),
+	)
+}
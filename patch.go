@@ -0,0 +1,325 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// diffContextLines is the number of unchanged lines shown around the changes in unified diffs
+const diffContextLines = 3
+
+// patchSet collects the changes made to files in dry-run mode instead of writing them. It is safe for concurrent use.
+type patchSet struct {
+	mu    sync.Mutex
+	diffs map[string][]byte
+}
+
+// newPatchSet returns an empty patchSet
+func newPatchSet() *patchSet {
+	return &patchSet{
+		diffs: make(map[string][]byte),
+	}
+}
+
+// Add records the change of the file `fName` to `data`, where nil data stands for the removal of the file. The
+// change is compared to the current content of the file, that is missing if the file does not exist.
+func (ps *patchSet) Add(fName string, data []byte) error {
+	oldData, err := ioutil.ReadFile(fName)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", fName, err)
+	}
+
+	diff := unifiedDiff(patchPath(fName), oldData, data)
+	if diff == nil {
+		return nil
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.diffs[fName] = diff
+
+	return nil
+}
+
+// Len returns the number of changed files
+func (ps *patchSet) Len() int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	return len(ps.diffs)
+}
+
+// WriteTo writes the diffs of all changed files ordered by file name
+func (ps *patchSet) WriteTo(w io.Writer) (int64, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	var n int64
+	for _, fName := range sortedKeys(ps.diffs) {
+		written, err := w.Write(ps.diffs[fName])
+		n += int64(written)
+
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// patchPath returns the path of the file used in diff headers, that is relative to the current working directory,
+// so that the patch can be applied with `git apply` from the same directory
+func patchPath(fName string) string {
+	if abs, err := filepath.Abs(fName); err == nil {
+		if cwd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(cwd, abs); err == nil && !strings.HasPrefix(rel, "..") {
+				fName = rel
+			}
+		}
+	}
+
+	return filepath.ToSlash(fName)
+}
+
+// unifiedDiff returns the diff of the file in the unified format with git headers, where nil content stands for
+// a missing file. It returns nil if there are no changes.
+func unifiedDiff(path string, oldData, newData []byte) []byte {
+	if bytes.Equal(oldData, newData) && (oldData == nil) == (newData == nil) {
+		return nil
+	}
+
+	buf := bytes.NewBuffer(nil)
+
+	fmt.Fprintf(buf, "diff --git a/%s b/%s\n", path, path)
+	oldName, newName := "a/"+path, "b/"+path
+	switch {
+	case oldData == nil:
+		fmt.Fprintf(buf, "new file mode 100644\n")
+		oldName = "/dev/null"
+	case newData == nil:
+		fmt.Fprintf(buf, "deleted file mode 100644\n")
+		newName = "/dev/null"
+	}
+
+	oldLines, newLines := splitLines(oldData), splitLines(newData)
+	if len(oldLines) == 0 && len(newLines) == 0 {
+		return buf.Bytes() // an empty file has been created or removed
+	}
+
+	fmt.Fprintf(buf, "--- %s\n+++ %s\n", oldName, newName)
+
+	// the edit script, where each line is prefixed with either ' ', '-' or '+'
+	var lines []string
+	for _, d := range diffSequences(oldLines, newLines) {
+		prefix := " "
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			prefix = "-"
+		case diffmatchpatch.DiffInsert:
+			prefix = "+"
+		}
+
+		// each line is encoded as a single rune, see diffSequences()
+		n := utf8.RuneCountInString(d.Text)
+		for i := 0; i < n; i++ {
+			lines = append(lines, prefix)
+		}
+	}
+
+	// fill the lines of the edit script with text
+	var oi, ni int
+	for i, prefix := range lines {
+		switch prefix {
+		case "-":
+			lines[i] += oldLines[oi]
+			oi++
+		case "+":
+			lines[i] += newLines[ni]
+			ni++
+		default:
+			lines[i] += oldLines[oi]
+			oi, ni = oi+1, ni+1
+		}
+	}
+
+	for _, h := range diffHunks(lines) {
+		writeHunk(buf, lines, h)
+	}
+
+	return buf.Bytes()
+}
+
+// diffHunk is a range of the edit script lines shown as a single hunk along with the positions of its first line
+// in the old and the new file
+type diffHunk struct {
+	Start, End       int
+	OldLine, NewLine int
+}
+
+// diffHunks groups the changed lines of the edit script into hunks, adding diffContextLines of unchanged lines
+// around them. The changes separated with less than twice as many unchanged lines are shown in the same hunk.
+func diffHunks(lines []string) []diffHunk {
+	var (
+		hunks            []diffHunk
+		oldLine, newLine int
+	)
+
+	for i := 0; i < len(lines); {
+		if lines[i][0] == ' ' {
+			i, oldLine, newLine = i+1, oldLine+1, newLine+1
+			continue
+		}
+
+		start := i - diffContextLines
+		if start < 0 {
+			start = 0
+		}
+
+		h := diffHunk{Start: start, OldLine: oldLine - (i - start), NewLine: newLine - (i - start)}
+
+		end := i
+		for end < len(lines) {
+			if lines[end][0] != ' ' {
+				end++
+				continue
+			}
+
+			next := end
+			for next < len(lines) && lines[next][0] == ' ' {
+				next++
+			}
+
+			if next == len(lines) || next-end > 2*diffContextLines {
+				if end += diffContextLines; end > len(lines) {
+					end = len(lines)
+				}
+
+				break
+			}
+
+			end = next
+		}
+		h.End = end
+
+		for _, line := range lines[i:end] {
+			if line[0] != '+' {
+				oldLine++
+			}
+
+			if line[0] != '-' {
+				newLine++
+			}
+		}
+
+		hunks = append(hunks, h)
+		i = end
+	}
+
+	return hunks
+}
+
+// writeHunk writes the hunk header followed by its lines
+func writeHunk(buf *bytes.Buffer, lines []string, h diffHunk) {
+	var oldCount, newCount int
+	for _, line := range lines[h.Start:h.End] {
+		if line[0] != '+' {
+			oldCount++
+		}
+
+		if line[0] != '-' {
+			newCount++
+		}
+	}
+
+	fmt.Fprintf(buf, "@@ -%s +%s @@\n", hunkRange(h.OldLine, oldCount), hunkRange(h.NewLine, newCount))
+	for _, line := range lines[h.Start:h.End] {
+		if strings.HasSuffix(line, "\n") {
+			buf.WriteString(line)
+			continue
+		}
+
+		buf.WriteString(line + "\n\\ No newline at end of file\n")
+	}
+}
+
+// hunkRange formats the range of lines of the hunk, where `line` is the zero-based position of its first line
+func hunkRange(line, count int) string {
+	switch count {
+	case 0:
+		// an empty range refers to the line preceding the hunk
+		return fmt.Sprintf("%d,0", line)
+	case 1:
+		return fmt.Sprintf("%d", line+1)
+	default:
+		return fmt.Sprintf("%d,%d", line+1, count)
+	}
+}
+
+// splitLines splits the content into lines keeping line terminators, so that the last line without a newline
+// differs from the same line followed by one
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, string(data))
+			break
+		}
+
+		lines = append(lines, string(data[:i+1]))
+		data = data[i+1:]
+	}
+
+	return lines
+}
+
+// dryRunPatchSet returns an empty patchSet if the changes are not to be written because of the -dry-run or -patch
+// flags, and nil otherwise
+func dryRunPatchSet() *patchSet {
+	if !args.DryRun && args.Patch == "" {
+		return nil
+	}
+
+	return newPatchSet()
+}
+
+// writePatchSet writes the collected changes either to the file provided with the -patch flag or to stdout
+func writePatchSet(patches *patchSet) error {
+	if patches == nil {
+		return nil
+	}
+
+	if patches.Len() == 0 {
+		log.Info().Msg("dry run: no changes")
+	}
+
+	if args.Patch == "" {
+		_, err := patches.WriteTo(os.Stdout)
+		return err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if _, err := patches.WriteTo(buf); err != nil {
+		return err
+	}
+
+	if err := writeNodeToFile(log.Logger, args.Patch, buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write %s: %w", args.Patch, err)
+	}
+	log.Info().Msgf("dry run: the changes of %d file(s) are written to %s", patches.Len(), args.Patch)
+
+	return nil
+}
@@ -0,0 +1,216 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	examples := map[string]struct {
+		Old, New string
+		Expected string
+	}{
+		"change": {
+			Old: "a\nb\nc\nd\ne\nf\ng\n",
+			New: "a\nb\nc\nD\ne\nf\ng\n",
+			Expected: `diff --git a/main.go b/main.go
+--- a/main.go
++++ b/main.go
+@@ -1,7 +1,7 @@
+ a
+ b
+ c
+-d
++D
+ e
+ f
+ g
+`,
+		},
+		"multiple hunks": {
+			Old: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			New: "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n12\n",
+			Expected: `diff --git a/main.go b/main.go
+--- a/main.go
++++ b/main.go
+@@ -1,3 +1,4 @@
++0
+ 1
+ 2
+ 3
+@@ -8,5 +9,4 @@
+ 8
+ 9
+ 10
+-11
+ 12
+`,
+		},
+		"no newline at end of file": {
+			Old: "a\nb",
+			New: "a\nb\nc\n",
+			Expected: `diff --git a/main.go b/main.go
+--- a/main.go
++++ b/main.go
+@@ -1,2 +1,3 @@
+ a
+-b
+\ No newline at end of file
++b
++c
+`,
+		},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, example.Expected, string(unifiedDiff("main.go", []byte(example.Old), []byte(example.New))))
+		})
+	}
+}
+
+func TestUnifiedDiff_NewFile(t *testing.T) {
+	assert.Equal(t, `diff --git a/pkg/main.go b/pkg/main.go
+new file mode 100644
+--- /dev/null
++++ b/pkg/main.go
+@@ -0,0 +1,2 @@
++package main
++
+`, string(unifiedDiff("pkg/main.go", nil, []byte("package main\n\n"))))
+}
+
+func TestUnifiedDiff_DeletedFile(t *testing.T) {
+	assert.Equal(t, `diff --git a/main.go b/main.go
+deleted file mode 100644
+--- a/main.go
++++ /dev/null
+@@ -1 +0,0 @@
+-package main
+`, string(unifiedDiff("main.go", []byte("package main\n"), nil)))
+}
+
+func TestUnifiedDiff_NoChanges(t *testing.T) {
+	assert.Nil(t, unifiedDiff("main.go", []byte("package main\n"), []byte("package main\n")))
+	assert.Nil(t, unifiedDiff("main.go", nil, nil))
+}
+
+func TestPatchSet_GitApply(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+
+	dir := t.TempDir()
+	chdir(t, dir)
+
+	writeFile(t, "changed.go", "package main\n\nfunc a() {}\n\nfunc b() {}\n\nfunc c() {}\n\nfunc d() {}\n\nfunc e() {}\n")
+	writeFile(t, "removed.go", "package main\n")
+
+	ps := newPatchSet()
+	require.NoError(t, ps.Add("changed.go", []byte("package main\n\nfunc A() {}\n\nfunc b() {}\n\nfunc c() {}\n\nfunc d() {}\n\nfunc E() {}")))
+	require.NoError(t, ps.Add("removed.go", nil))
+	require.NoError(t, ps.Add(filepath.Join(dir, "pkg", "created.go"), []byte("package pkg\n")))
+	require.NoError(t, ps.Add("unchanged.go", nil))
+
+	assert.Equal(t, 3, ps.Len())
+
+	buf := bytes.NewBuffer(nil)
+	_, err := ps.WriteTo(buf)
+	require.NoError(t, err)
+
+	cmd := exec.Command("git", "apply", "-")
+	cmd.Stdin = buf
+
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	data, err := os.ReadFile("changed.go")
+	require.NoError(t, err)
+	assert.Equal(t, "package main\n\nfunc A() {}\n\nfunc b() {}\n\nfunc c() {}\n\nfunc d() {}\n\nfunc E() {}", string(data))
+
+	data, err = os.ReadFile(filepath.Join("pkg", "created.go"))
+	require.NoError(t, err)
+	assert.Equal(t, "package pkg\n", string(data))
+
+	assert.NoFileExists(t, "removed.go")
+}
+
+func TestInstrumentCode_DryRun(t *testing.T) {
+	srcDir := t.TempDir()
+	chdir(t, srcDir)
+
+	writeFile(t, instanaGoFileName, `// Code generated by go-instana, DO NOT EDIT.
+
+package main
+
+import instana "github.com/instana/go-sensor"
+
+var __instanaSensor = instana.NewSensor("")
+`)
+
+	originalCode := `package main
+
+import "net/http"
+
+func main() {
+	http.HandleFunc("/", http.NotFound)
+}
+`
+	writeFile(t, "main.go", originalCode)
+
+	ps := newPatchSet()
+	require.NoError(t, instrumentCode(log.Logger, ".", nil, nil, ps))
+
+	data, err := os.ReadFile("main.go")
+	require.NoError(t, err)
+	assert.Equal(t, originalCode, string(data), "no files are expected to be changed in dry-run mode")
+
+	buf := bytes.NewBuffer(nil)
+	_, err = ps.WriteTo(buf)
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(buf.String(), "diff --git a/main.go b/main.go\n"), buf.String())
+	assert.Contains(t, buf.String(), "\n-\thttp.HandleFunc(\"/\", http.NotFound)\n")
+	assert.Contains(t, buf.String(), "\n+\thttp.HandleFunc(\"/\", instana.TracingHandlerFunc(__instanaSensor, \"/\", http.NotFound))\n")
+}
+
+func TestAddPackage_DryRun(t *testing.T) {
+	chdir(t, t.TempDir())
+
+	writeFile(t, "main.go", "package main\n\nfunc main() {}\n")
+
+	ps := newPatchSet()
+	require.NoError(t, addPackage(log.Logger, ".", ps))
+
+	assert.NoFileExists(t, instanaGoFileName)
+	assert.Equal(t, 1, ps.Len())
+
+	buf := bytes.NewBuffer(nil)
+	_, err := ps.WriteTo(buf)
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(buf.String(), "diff --git a/"+instanaGoFileName+" b/"+instanaGoFileName+"\nnew file mode 100644\n"), buf.String())
+}
+
+// chdir changes the working directory for the duration of the test
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(wd))
+	})
+}
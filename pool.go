@@ -0,0 +1,140 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// logOutput is the writer used by the global logger. The log output of packages processed in parallel is buffered
+// and then written to it.
+var logOutput io.Writer = os.Stderr
+
+// processPackages calls `process` for each of `paths` using up to `workers` goroutines. The logger passed to
+// `process` buffers the log output of the package, that is written once the package has been processed and the
+// output of all preceding packages is written, so that the messages of different packages are never interleaved
+// and the output follows the order of `paths`. No new packages are processed once any of them has failed, and
+// the error of the first failed package in order of `paths` is returned.
+func processPackages(paths []string, workers int, process func(logger zerolog.Logger, path string) error) error {
+	if workers <= 1 || len(paths) <= 1 {
+		for _, path := range paths {
+			if err := process(log.Logger, path); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	type result struct {
+		logs *logBuffer
+		err  error
+	}
+
+	results := make([]chan result, len(paths))
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+
+	var (
+		jobs     = make(chan int)
+		stop     = make(chan struct{})
+		stopOnce sync.Once
+		wg       sync.WaitGroup
+	)
+
+	go func() {
+		defer close(jobs)
+
+		for i := range paths {
+			select {
+			case jobs <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	for n := 0; n < workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				// the packages dispatched after a failure follow the failed one, so their results are never awaited
+				select {
+				case <-stop:
+					continue
+				default:
+				}
+
+				logs := &logBuffer{}
+				err := process(log.Logger.Output(logs), paths[i])
+				if err != nil {
+					stopOnce.Do(func() { close(stop) })
+				}
+
+				results[i] <- result{logs, err}
+			}
+		}()
+	}
+
+	for i := range paths {
+		res := <-results[i]
+		res.logs.WriteTo(logOutput)
+
+		if res.err == nil {
+			continue
+		}
+
+		wg.Wait()
+
+		// the packages that were being processed at the moment of failure have been finished anyway
+		for _, ch := range results[i+1:] {
+			select {
+			case res := <-ch:
+				res.logs.WriteTo(logOutput)
+			default:
+			}
+		}
+
+		return res.err
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+// logBuffer collects the events written by a logger to write them later
+type logBuffer struct {
+	events [][]byte
+}
+
+// Write implements io.Writer
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.events = append(b.events, append([]byte(nil), p...))
+
+	return len(p), nil
+}
+
+// WriteTo writes the collected events to `w` one by one, since the writers like zerolog.ConsoleWriter expect
+// a single event per write
+func (b *logBuffer) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, event := range b.events {
+		written, err := w.Write(event)
+		n += int64(written)
+
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
@@ -0,0 +1,121 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessPackages(t *testing.T) {
+	defer func(w io.Writer) { logOutput = w }(logOutput)
+
+	buf := bytes.NewBuffer(nil)
+	logOutput = buf
+
+	paths := []string{"a", "b", "c", "d", "e", "f"}
+
+	var processed int32
+	require.NoError(t, processPackages(paths, 3, func(logger zerolog.Logger, path string) error {
+		atomic.AddInt32(&processed, 1)
+
+		logger.Info().Msgf("start %s", path)
+		// the packages processed first finish last
+		time.Sleep(time.Duration(len(paths)-strings.Index("abcdef", path)) * time.Millisecond)
+		logger.Info().Msgf("finish %s", path)
+
+		return nil
+	}))
+
+	assert.EqualValues(t, len(paths), processed)
+
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		messages = append(messages, line[strings.Index(line, `"message":`):])
+	}
+
+	var expected []string
+	for _, path := range paths {
+		expected = append(expected, `"message":"start `+path+`"}`, `"message":"finish `+path+`"}`)
+	}
+
+	assert.Equal(t, expected, messages)
+}
+
+func TestProcessPackages_Error(t *testing.T) {
+	defer func(w io.Writer) { logOutput = w }(logOutput)
+
+	// the logs of the failed package are written once the failure has stopped the processing
+	released := make(chan struct{})
+	logOutput = &notifyingWriter{C: released}
+
+	paths := make([]string, 100)
+	for i := range paths {
+		paths[i] = strings.Repeat("a", i+1)
+	}
+
+	started := make(chan struct{})
+
+	var processed int32
+	err := processPackages(paths, 2, func(logger zerolog.Logger, path string) error {
+		atomic.AddInt32(&processed, 1)
+
+		if path == "a" {
+			// the other worker is busy with the next package at the moment of failure
+			<-started
+			logger.Error().Msg("failed")
+
+			return errors.New("failed")
+		}
+
+		if path == "aa" {
+			close(started)
+		}
+
+		<-released
+
+		return nil
+	})
+
+	assert.EqualError(t, err, "failed")
+	assert.EqualValues(t, 2, atomic.LoadInt32(&processed), "no packages are expected to be processed after the failure")
+}
+
+func TestProcessPackages_Sequential(t *testing.T) {
+	var processed []string
+	err := processPackages([]string{"a", "b", "c"}, 1, func(_ zerolog.Logger, path string) error {
+		processed = append(processed, path)
+
+		if path == "b" {
+			return errors.New("failed")
+		}
+
+		return nil
+	})
+
+	assert.EqualError(t, err, "failed")
+	assert.Equal(t, []string{"a", "b"}, processed)
+}
+
+// notifyingWriter closes the channel on the first write
+type notifyingWriter struct {
+	C    chan struct{}
+	once sync.Once
+}
+
+// Write implements io.Writer
+func (w *notifyingWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.C) })
+
+	return len(p), nil
+}
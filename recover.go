@@ -0,0 +1,77 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"runtime/debug"
+	"strings"
+
+	"github.com/instana/go-instana/internal/registry"
+	"github.com/rs/zerolog"
+)
+
+// recipeError is returned when an instrumentation recipe panics while processing a file
+type recipeError struct {
+	Recipe string
+	File   string
+	Pos    token.Position
+	Value  interface{}
+}
+
+// Error implements error
+func (e *recipeError) Error() string {
+	loc := e.File
+	if e.Pos.IsValid() {
+		loc = e.Pos.String()
+	}
+
+	return fmt.Sprintf("%s: recipe %s failed: %v", loc, e.Recipe, e.Value)
+}
+
+// recipeErrors is a list of recipe errors occurred while instrumenting a package
+type recipeErrors []*recipeError
+
+// Error implements error
+func (errs recipeErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// applyRecipe applies the recipe registered with `name` to the file. A panic raised by the recipe is recovered
+// and returned as *recipeError, so that a single faulty recipe does not break the whole build.
+func applyRecipe(logger zerolog.Logger, fset *token.FileSet, info *types.Info, name string, recipe registry.Recipe, fName string, f *ast.File, pkgName, sensorVar string) (registry.Edits, error) {
+	return guardRecipe(logger, fset, name, fName, func() registry.Edits {
+		return recipe.Instrument(fset, info, f, pkgName, sensorVar)
+	})
+}
+
+// guardRecipe calls `fn` running the recipe registered with `name` against the file and returns the panic raised
+// by the recipe as *recipeError
+func guardRecipe(logger zerolog.Logger, fset *token.FileSet, name, fName string, fn func() registry.Edits) (edits registry.Edits, err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+
+		recipeErr := &recipeError{Recipe: name, File: fName, Value: r}
+
+		stack := debug.Stack()
+		if p, ok := r.(registry.NodePanic); ok {
+			recipeErr.Value, recipeErr.Pos, stack = p.Value, fset.Position(p.Pos), p.Stack
+		}
+		logger.Debug().Msgf("%s\n%s", recipeErr, stack)
+
+		edits, err = nil, recipeErr
+	}()
+
+	return fn(), nil
+}
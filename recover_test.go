@@ -0,0 +1,69 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+
+	"github.com/instana/go-instana/internal/registry"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type panickingRecipe struct {
+	registry.Recipe
+	value interface{}
+}
+
+func (r panickingRecipe) Instrument(_ *token.FileSet, _ *types.Info, f ast.Node, _, _ string) registry.Edits {
+	panic(r.value)
+}
+
+func TestApplyRecipe(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "main.go", "package main\n\nfunc main() {\n\tprintln()\n}\n", 0)
+	require.NoError(t, err)
+
+	call := f.Decls[0].(*ast.FuncDecl).Body.List[0]
+
+	examples := map[string]struct {
+		Value    interface{}
+		Expected string
+	}{
+		"panic with node position": {
+			Value:    registry.NodePanic{Pos: call.Pos(), Value: "index out of range"},
+			Expected: "main.go:4:2: recipe test failed: index out of range",
+		},
+		"panic without position": {
+			Value:    "unexpected node",
+			Expected: "main.go: recipe test failed: unexpected node",
+		},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			edits, err := applyRecipe(log.Logger, fset, nil, "test", panickingRecipe{value: example.Value}, "main.go", f, "main", "__instanaSensor")
+			assert.Empty(t, edits)
+
+			var recipeErr *recipeError
+			require.ErrorAs(t, err, &recipeErr)
+
+			assert.Equal(t, "test", recipeErr.Recipe)
+			assert.EqualError(t, err, example.Expected)
+		})
+	}
+}
+
+func TestRecipeErrors_Error(t *testing.T) {
+	errs := recipeErrors{
+		{Recipe: "first", File: "a.go", Value: "boom"},
+		{Recipe: "second", File: "b.go", Pos: token.Position{Filename: "b.go", Line: 3, Column: 1}, Value: "bang"},
+	}
+
+	assert.EqualError(t, errs, "a.go: recipe first failed: boom\nb.go:3:1: recipe second failed: bang")
+}
@@ -0,0 +1,322 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/instana/go-instana/internal/recipes"
+	"github.com/instana/go-instana/internal/registry"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// removeCommand handles the `go-instana remove` execution
+func removeCommand() {
+	log.Info().Msg(`start "remove" command`)
+	defer log.Info().Msg(`finish "remove" command`)
+
+	if err := checkModuleRoot("."); err != nil {
+		log.Fatal().Msg(err.Error())
+	}
+
+	patches := dryRunPatchSet()
+
+	for _, root := range projectRootDirs(".") {
+		paths, err := collectPackageDirs(root)
+		if err != nil {
+			log.Fatal().Msgf("can't collect paths error: %s", err.Error())
+		}
+
+		deps := loadExportData(root, "./...")
+
+		err = processPackages(paths, args.Jobs, func(logger zerolog.Logger, path string) error {
+			return removeInstrumentation(logger, path, deps, patches)
+		})
+		if err != nil {
+			log.Fatal().Msgf("instrumentation removal error: %s", err.Error())
+		}
+	}
+
+	if len(args.VendoredModules) > 0 {
+		log.Warn().Msg("vendored modules are not processed by remove, run `go mod vendor` to restore them")
+	}
+
+	if err := writePatchSet(patches); err != nil {
+		log.Fatal().Msgf("failed to write patch: %s", err)
+	}
+}
+
+// removeInstrumentation reverts the changes made by instrumentation recipes to the package located at `path` and
+// removes the `instanaGoFileName` file generated by go-instana. If `patches` is not nil, the changes are added
+// to it instead of being written.
+func removeInstrumentation(logger zerolog.Logger, path string, deps exportData, patches *patchSet) error {
+	logger.Info().Msgf("processing path %s", path)
+
+	fset := token.NewFileSet()
+
+	files, err := packageFiles(path)
+
+	var pkg *ast.Package
+	if err == nil {
+		pkg, err = parseFiles(logger, fset, path, files)
+	}
+
+	if isNoGoError(err) {
+		logger.Info().Msgf("skip path %s : %s", path, err)
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to load package in %s: %w", path, err)
+	}
+
+	changes, err := uninstrumentPackage(logger, fset, pkg, deps)
+	if err != nil {
+		return err
+	}
+
+	for _, fName := range sortedKeys(changes) {
+		if err := writeChange(logger, fName, changes[fName], patches); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// uninstrumentPackage reverts the changes made by instrumentation recipes to the package files and returns the code
+// of files that have been changed, where nil code means that the file is to be removed. The generated
+// `instanaGoFileName` file is removed unless the sensor declared in it is still used by the package, e.g. because
+// some of the changes could not be reverted. The changes are verified the same way as the instrumented code, so that
+// the files that do not compile anymore are left intact.
+func uninstrumentPackage(logger zerolog.Logger, fset *token.FileSet, pkg *ast.Package, deps exportData) (map[string][]byte, error) {
+	var generatedFile string
+	for fName := range pkg.Files {
+		if filepath.Base(fName) == instanaGoFileName && isGeneratedFile(fName) {
+			generatedFile = fName
+		}
+	}
+
+	sensorName := lookupInstanaSensorInPackage(pkg)
+	if sensorName == "" && generatedFile == "" {
+		logger.Debug().Msgf("skip package %s : no Instana sensor found", pkg.Name)
+		return nil, nil
+	}
+
+	changes := make(map[string][]byte)
+	if sensorName != "" {
+		imp := deps.Importer(logger, fset)
+		info, typeErrs := typeCheckPackage(logger, fset, pkg, imp)
+
+		changed := make(map[string]instrumentedFile)
+		for _, fName := range sortedKeys(pkg.Files) {
+			if fName == generatedFile {
+				continue
+			}
+
+			f := pkg.Files[fName]
+
+			edits, err := uninstrument(logger, fset, info, fName, f, sensorName)
+			if err != nil {
+				logger.Error().Msgf("%s, the file is left unchanged", err)
+
+				if orig, err := parser.ParseFile(fset, fName, nil, parser.ParseComments); err == nil {
+					pkg.Files[fName] = orig
+				}
+
+				continue
+			}
+
+			if !edits.Changed() {
+				continue
+			}
+
+			oldData, data, err := renderChangedFile(logger, fset, fName, f)
+			if err != nil {
+				logger.Warn().Msgf("failed to process %s: %s", fName, err)
+				continue
+			}
+
+			if string(oldData) == string(data) {
+				continue
+			}
+
+			logger.Debug().Msgf("CHANGES:\n%s", unifiedDiff(patchPath(fName), oldData, data))
+
+			changed[fName] = instrumentedFile{Original: oldData, Instrumented: data, Edits: edits}
+		}
+
+		changes = verifyInstrumentedPackage(logger, fset, pkg, imp, typeErrs, changed)
+	}
+
+	if generatedFile == "" {
+		return changes, nil
+	}
+
+	// the sensor declared by the generated file might still be used by the code, that has not been reverted
+	if sensorName != "" && pkg.Files[generatedFile].Scope.Lookup(sensorName) != nil {
+		if users := sensorUsers(pkg, changes, generatedFile, sensorName); len(users) > 0 {
+			logger.Warn().Msgf("%s is kept, since %s is still used in %s", generatedFile, sensorName, strings.Join(users, ", "))
+			return changes, nil
+		}
+	}
+
+	changes[generatedFile] = nil
+
+	return changes, nil
+}
+
+// uninstrument reverts the changes made by instrumentation recipes to the file. The recipes implementing
+// registry.Remover are applied in reverse order, so that the changes made on top of the others are reverted first.
+// Once done, the imports of instrumentation packages that are no longer used are removed.
+func uninstrument(logger zerolog.Logger, fset *token.FileSet, info *types.Info, fName string, f *ast.File, sensorVar string) (registry.Edits, error) {
+	imports := buildImportsMap(f)
+	usedBefore := usedImports(f, info)
+
+	names := registry.Default.Ordered()
+
+	var edits registry.Edits
+	for i := len(names) - 1; i >= 0; i-- {
+		name := names[i]
+
+		recipe := registry.Default.InstrumentationRecipe(name)
+		remover, ok := recipe.(registry.Remover)
+		if !ok {
+			continue
+		}
+
+		instanaPkgNames := imports[recipe.ImportPath()]
+		if len(instanaPkgNames) == 0 {
+			continue
+		}
+
+		// the target package import might have been removed during instrumentation, in which case it's added back
+		// by the recipe using the default package name
+		targetPkg := registry.TargetPackage(name)
+		pkgName := recipes.ExtractLocalImportName(targetPkg)
+		if pkgNames := imports[targetPkg]; len(pkgNames) > 0 {
+			pkgName = pkgNames[0]
+		}
+
+		for _, instanaPkgName := range instanaPkgNames {
+			recipeEdits, err := applyRemover(logger, fset, info, name, remover, fName, f, pkgName, instanaPkgName, sensorVar)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, e := range recipeEdits {
+				e.Recipe = name
+				logEdit(logger, fset, e)
+
+				edits = append(edits, e)
+			}
+		}
+	}
+
+	if !edits.Changed() {
+		logger.Debug().Msgf("[UNCHANGED] file %s ", fName)
+		return edits, nil
+	}
+
+	removeUnusedImports(logger, fset, f, info, usedBefore)
+
+	return edits, nil
+}
+
+// applyRemover reverts the changes made by the recipe registered with `name` to the file. A panic raised by
+// the recipe is recovered and returned as *recipeError.
+func applyRemover(logger zerolog.Logger, fset *token.FileSet, info *types.Info, name string, remover registry.Remover, fName string, f *ast.File, pkgName, instanaPkgName, sensorVar string) (registry.Edits, error) {
+	return guardRecipe(logger, fset, name, fName, func() registry.Edits {
+		return remover.Remove(fset, info, f, pkgName, instanaPkgName, sensorVar)
+	})
+}
+
+// sensorUsers returns the sorted list of package files except `generatedFile` referencing the sensor variable
+// after the `changes` are applied
+func sensorUsers(pkg *ast.Package, changes map[string][]byte, generatedFile, sensorName string) []string {
+	var users []string
+	for _, fName := range sortedKeys(pkg.Files) {
+		if fName == generatedFile {
+			continue
+		}
+
+		data, ok := changes[fName]
+		if !ok {
+			var err error
+			if data, err = ioutil.ReadFile(fName); err != nil {
+				continue
+			}
+		}
+
+		f, err := parser.ParseFile(token.NewFileSet(), fName, data, 0)
+		if err != nil {
+			continue
+		}
+
+		if referencesIdent(f, sensorName) {
+			users = append(users, fName)
+		}
+	}
+
+	return users
+}
+
+// referencesIdent returns whether the file contains an unresolved identifier with provided name, i.e. the one
+// declared in another file of the package
+func referencesIdent(f *ast.File, name string) bool {
+	var found bool
+	ast.Inspect(f, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Ident); ok && ident.Name == name && ident.Obj == nil {
+			found = true
+		}
+
+		return !found
+	})
+
+	return found
+}
+
+// isGeneratedFile returns whether the file has been generated by go-instana
+func isGeneratedFile(fName string) bool {
+	data, err := ioutil.ReadFile(fName)
+	if err != nil {
+		return false
+	}
+
+	return isGeneratedByGoInstana(bytes.NewBuffer(data))
+}
+
+// writeChange writes the changed code of the file, where nil code means that the file is to be removed. If `patches`
+// is not nil, the change is added to it instead.
+func writeChange(logger zerolog.Logger, fName string, data []byte, patches *patchSet) error {
+	if patches != nil {
+		return patches.Add(fName, data)
+	}
+
+	if data == nil {
+		if err := os.Remove(fName); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", fName, err)
+		}
+		logger.Info().Msgf("removed %s", fName)
+
+		return nil
+	}
+
+	if err := writeNodeToFile(logger, fName, data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", fName, err)
+	}
+	logger.Info().Msgf("updated %s", fName)
+
+	return nil
+}
@@ -0,0 +1,119 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const generatedInstanaGoFile = `// Code generated by go-instana, DO NOT EDIT.
+
+package main
+
+import instana "github.com/instana/go-sensor"
+
+var __instanaSensor = instana.NewSensor("")
+`
+
+func TestRemoveInstrumentation(t *testing.T) {
+	srcDir := t.TempDir()
+
+	originalCode := `package main
+
+import (
+	"net/http"
+)
+
+func main() {
+	// the handler
+	http.HandleFunc("/", http.NotFound)
+
+	client := &http.Client{}
+	client.Get("https://example.com")
+}
+`
+
+	writeFile(t, filepath.Join(srcDir, instanaGoFileName), generatedInstanaGoFile)
+	writeFile(t, filepath.Join(srcDir, "main.go"), originalCode)
+
+	require.NoError(t, instrumentCode(log.Logger, srcDir, nil, nil, nil))
+
+	data, err := os.ReadFile(filepath.Join(srcDir, "main.go"))
+	require.NoError(t, err)
+	require.NotEqual(t, originalCode, string(data))
+
+	require.NoError(t, removeInstrumentation(log.Logger, srcDir, nil, nil))
+
+	data, err = os.ReadFile(filepath.Join(srcDir, "main.go"))
+	require.NoError(t, err)
+	assert.Equal(t, originalCode, string(data))
+
+	assert.NoFileExists(t, filepath.Join(srcDir, instanaGoFileName))
+}
+
+func TestRemoveInstrumentation_SensorStillUsed(t *testing.T) {
+	srcDir := t.TempDir()
+
+	code := `package main
+
+import (
+	"net/http"
+
+	instana "github.com/instana/go-sensor"
+)
+
+func main() {
+	http.HandleFunc("/", instana.TracingHandlerFunc(__instanaSensor, "/", http.NotFound))
+
+	__instanaSensor.Logger().Info("started")
+}
+`
+
+	writeFile(t, filepath.Join(srcDir, instanaGoFileName), generatedInstanaGoFile)
+	writeFile(t, filepath.Join(srcDir, "main.go"), code)
+
+	require.NoError(t, removeInstrumentation(log.Logger, srcDir, nil, nil))
+
+	data, err := os.ReadFile(filepath.Join(srcDir, "main.go"))
+	require.NoError(t, err)
+	assert.Equal(t, `package main
+
+import (
+	"net/http"
+)
+
+func main() {
+	http.HandleFunc("/", http.NotFound)
+
+	__instanaSensor.Logger().Info("started")
+}
+`, string(data))
+
+	assert.FileExists(t, filepath.Join(srcDir, instanaGoFileName), "the sensor declaration is expected to be kept")
+}
+
+func TestRemoveInstrumentation_UserDefinedFile(t *testing.T) {
+	srcDir := t.TempDir()
+
+	userCode := `package main
+
+import instana "github.com/instana/go-sensor"
+
+var sensor = instana.NewSensor("")
+`
+
+	writeFile(t, filepath.Join(srcDir, instanaGoFileName), userCode)
+	writeFile(t, filepath.Join(srcDir, "main.go"), "package main\n\nfunc main() {}\n")
+
+	require.NoError(t, removeInstrumentation(log.Logger, srcDir, nil, nil))
+
+	data, err := os.ReadFile(filepath.Join(srcDir, instanaGoFileName))
+	require.NoError(t, err)
+	assert.Equal(t, userCode, string(data), "the files not generated by go-instana are expected to be kept")
+}
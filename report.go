@@ -0,0 +1,350 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/instana/go-instana/internal/registry"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// The report formats supported by the report command
+const (
+	reportFormatText  = "text"
+	reportFormatSARIF = "sarif"
+)
+
+// reportCommand handles the `go-instana report` execution. It applies both `add` and `instrument` steps in memory
+// and reports the call sites that have not been instrumented yet, as well as the ones that cannot be instrumented
+// automatically, without changing any files.
+func reportCommand() {
+	log.Info().Msg(`start "report" command`)
+	defer log.Info().Msg(`finish "report" command`)
+
+	if args.Format != reportFormatText && args.Format != reportFormatSARIF {
+		log.Fatal().Msgf("unsupported report format %q, expected %s or %s", args.Format, reportFormatText, reportFormatSARIF)
+	}
+
+	if err := checkModuleRoot("."); err != nil {
+		log.Fatal().Msg(err.Error())
+	}
+
+	report := newSiteReport()
+
+	for _, root := range projectRootDirs(".") {
+		paths, err := collectPackageDirs(root)
+		if err != nil {
+			log.Fatal().Msgf("can't collect paths error: %s", err.Error())
+		}
+
+		deps := loadExportData(root, "./...")
+
+		err = processPackages(paths, args.Jobs, func(logger zerolog.Logger, path string) error {
+			return reportPackage(logger, path, deps, report)
+		})
+		if err != nil {
+			log.Fatal().Msgf("report error: %s", err.Error())
+		}
+	}
+
+	var err error
+	if args.Format == reportFormatSARIF {
+		err = report.WriteSARIF(os.Stdout)
+	} else {
+		_, err = report.WriteTo(os.Stdout)
+	}
+
+	if err != nil {
+		log.Fatal().Msgf("failed to write report: %s", err)
+	}
+}
+
+// reportPackage applies both `add` and `instrument` steps to the package located in `path` in memory and adds
+// the edits made or skipped by instrumentation recipes to the report
+func reportPackage(logger zerolog.Logger, path string, deps exportData, report *siteReport) error {
+	logger.Info().Msgf("processing path %s", path)
+
+	fset := token.NewFileSet()
+
+	pkg, _, err := loadAddedPackage(logger, fset, path)
+	if isNoGoError(err) {
+		logger.Info().Msgf("skip path %s : %s", path, err)
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to load package in %s: %w", path, err)
+	}
+
+	importedInstrumentationPackages := instanaPackageImports(fset, pkg.Files)
+	if len(importedInstrumentationPackages) == 0 {
+		logger.Info().Msgf("skip package %s : imported instrumentation packages not found", pkg.Name)
+		return nil
+	}
+
+	sensorName := lookupInstanaSensorInPackage(pkg)
+	if sensorName == "" {
+		logger.Warn().Msgf("%s: could not find Instana sensor, skipping", pkg.Name)
+		return nil
+	}
+
+	info, _ := typeCheckPackage(logger, fset, pkg, deps.Importer(logger, fset))
+
+	for _, fName := range sortedKeys(pkg.Files) {
+		edits, err := instrument(logger, fset, info, fName, pkg.Files[fName], sensorName, importedInstrumentationPackages)
+		if err != nil {
+			logger.Error().Msgf("%s, the file is not reported", err)
+			continue
+		}
+
+		for _, e := range edits {
+			report.Add(fset.Position(e.Pos), e)
+		}
+	}
+
+	return nil
+}
+
+// reportedSite is a call site changed or skipped by an instrumentation recipe
+type reportedSite struct {
+	Position token.Position
+	Edit     registry.Edit
+}
+
+// RuleID returns the identifier of the rule the site violates, that consists of the recipe name and the reason
+// of the edit, e.g. net/http:client/default-client
+func (s reportedSite) RuleID() string {
+	return s.Edit.Recipe + "/" + s.Edit.Reason
+}
+
+// Description returns the description of the reported problem
+func (s reportedSite) Description() string {
+	if s.Edit.Skipped {
+		return s.Edit.Message
+	}
+
+	return s.Edit.Message + ", run `go-instana instrument` to apply"
+}
+
+// siteReport collects the call sites changed or skipped by instrumentation recipes. The changed sites are the ones
+// that have not been instrumented yet, while the skipped ones cannot be instrumented automatically. It is safe for
+// concurrent use.
+type siteReport struct {
+	mu    sync.Mutex
+	sites []reportedSite
+}
+
+// newSiteReport returns an empty report
+func newSiteReport() *siteReport {
+	return &siteReport{}
+}
+
+// Add reports the edit found at the position
+func (r *siteReport) Add(pos token.Position, e registry.Edit) {
+	pos.Filename = patchPath(pos.Filename)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sites = append(r.sites, reportedSite{Position: pos, Edit: e})
+}
+
+// Sites returns the reported sites sorted by their position
+func (r *siteReport) Sites() []reportedSite {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sites := append([]reportedSite(nil), r.sites...)
+	sort.SliceStable(sites, func(i, j int) bool {
+		a, b := sites[i].Position, sites[j].Position
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+
+		return a.Column < b.Column
+	})
+
+	return sites
+}
+
+// WriteTo writes the report as text, one line per site
+func (r *siteReport) WriteTo(w io.Writer) (int64, error) {
+	var lines []string
+	for _, s := range r.Sites() {
+		status := "not instrumented"
+		if s.Edit.Skipped {
+			status = "cannot be instrumented"
+		}
+
+		lines = append(lines, fmt.Sprintf("%s: %s: %s (%s)", s.Position, status, s.Description(), s.RuleID()))
+	}
+
+	if len(lines) == 0 {
+		return 0, nil
+	}
+
+	n, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+
+	return int64(n), err
+}
+
+// WriteSARIF writes the report in SARIF format, where each site is a result of the rule identified with its recipe
+// and reason
+func (r *siteReport) WriteSARIF(w io.Writer) error {
+	run := sarifRun{
+		Tool: sarifTool{
+			Driver: sarifDriver{
+				Name:           "go-instana",
+				InformationURI: "https://github.com/instana/go-instana",
+				Version:        goInstanaVersion(),
+				Rules:          []sarifRule{},
+			},
+		},
+		Results: []sarifResult{},
+	}
+
+	ruleIndex := make(map[string]int)
+	for _, s := range r.Sites() {
+		id := s.RuleID()
+
+		idx, ok := ruleIndex[id]
+		if !ok {
+			idx = len(run.Tool.Driver.Rules)
+			ruleIndex[id] = idx
+
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, newSARIFRule(s))
+		}
+
+		level := "note"
+		if s.Edit.Skipped {
+			level = "warning"
+		}
+
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    id,
+			RuleIndex: idx,
+			Level:     level,
+			Message:   sarifMessage{Text: s.Description()},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: s.Position.Filename, URIBaseID: "%SRCROOT%"},
+					Region:           sarifRegion{StartLine: s.Position.Line, StartColumn: s.Position.Column},
+				},
+			}},
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+
+	return enc.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+}
+
+// newSARIFRule returns the rule for the reported site
+func newSARIFRule(s reportedSite) sarifRule {
+	desc := fmt.Sprintf("%s: call site is not instrumented (%s)", s.Edit.Recipe, s.Edit.Reason)
+	if s.Edit.Skipped {
+		desc = fmt.Sprintf("%s: call site cannot be instrumented automatically (%s)", s.Edit.Recipe, s.Edit.Reason)
+	}
+
+	return sarifRule{
+		ID:               s.RuleID(),
+		Name:             s.Edit.Reason,
+		ShortDescription: sarifMessage{Text: desc},
+		Properties:       sarifRuleProperties{Tags: []string{"instana", s.Edit.Recipe}},
+	}
+}
+
+// sarifLog is the top-level object of a SARIF file, see https://docs.oasis-open.org/sarif/sarif/v2.1.0/
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+// sarifRun is the result of a single go-instana run
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+// sarifTool describes the tool that produced the run
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+// sarifDriver describes go-instana along with the rules reported by it
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Version        string      `json:"version,omitempty"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+// sarifRule describes a rule, that is identified with the recipe name and the reason of the edit
+type sarifRule struct {
+	ID               string              `json:"id"`
+	Name             string              `json:"name"`
+	ShortDescription sarifMessage        `json:"shortDescription"`
+	Properties       sarifRuleProperties `json:"properties"`
+}
+
+// sarifRuleProperties are the additional rule properties
+type sarifRuleProperties struct {
+	Tags []string `json:"tags"`
+}
+
+// sarifResult is a reported call site
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+// sarifMessage is a plain text message
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+// sarifLocation is the location of the reported call site
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+// sarifPhysicalLocation is the location of the reported call site in a file
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+// sarifArtifactLocation is the file path relative to the module root
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId"`
+}
+
+// sarifRegion is the position of the reported call site in a file
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+}
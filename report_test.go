@@ -0,0 +1,126 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const reportedCode = `package main
+
+import "net/http"
+
+func main() {
+	http.HandleFunc("/", http.NotFound)
+
+	c := new(http.Client)
+	c.Get("https://example.com")
+
+	http.DefaultClient.Get("https://example.com")
+}
+`
+
+func TestReportPackage(t *testing.T) {
+	chdir(t, t.TempDir())
+
+	writeFile(t, "main.go", reportedCode)
+
+	report := newSiteReport()
+	require.NoError(t, reportPackage(log.Logger, ".", nil, report))
+
+	buf := bytes.NewBuffer(nil)
+	_, err := report.WriteTo(buf)
+	require.NoError(t, err)
+
+	assert.Equal(t, "main.go:6:2: not instrumented: the handler is wrapped with instana.TracingHandlerFunc(), run `go-instana instrument` to apply (net/http:server/wrap-handler)\n"+
+		"main.go:8:7: cannot be instrumented: http.Client created with new() cannot be instrumented, use &http.Client{} instead (net/http:client/client-created-with-new)\n"+
+		"main.go:11:2: cannot be instrumented: http.DefaultClient cannot be instrumented, use &http.Client{} instead (net/http:client/default-client)\n",
+		buf.String())
+
+	assert.NoFileExists(t, instanaGoFileName, "no files are expected to be changed by report")
+}
+
+func TestReportPackage_Instrumented(t *testing.T) {
+	chdir(t, t.TempDir())
+
+	writeFile(t, "main.go", `package main
+
+import "net/http"
+
+func main() {
+	http.HandleFunc("/", http.NotFound)
+}
+`)
+
+	require.NoError(t, addPackage(log.Logger, ".", nil))
+	require.NoError(t, instrumentCode(log.Logger, ".", nil, nil, nil))
+
+	report := newSiteReport()
+	require.NoError(t, reportPackage(log.Logger, ".", nil, report))
+
+	assert.Empty(t, report.Sites())
+}
+
+func TestSiteReport_WriteSARIF(t *testing.T) {
+	chdir(t, t.TempDir())
+
+	writeFile(t, "main.go", reportedCode)
+
+	report := newSiteReport()
+	require.NoError(t, reportPackage(log.Logger, ".", nil, report))
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, report.WriteSARIF(buf))
+
+	var sarif sarifLog
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &sarif))
+
+	assert.Equal(t, "2.1.0", sarif.Version)
+	require.Len(t, sarif.Runs, 1)
+
+	run := sarif.Runs[0]
+	assert.Equal(t, "go-instana", run.Tool.Driver.Name)
+
+	var ruleIDs []string
+	for _, rule := range run.Tool.Driver.Rules {
+		ruleIDs = append(ruleIDs, rule.ID)
+	}
+	assert.Equal(t, []string{
+		"net/http:server/wrap-handler",
+		"net/http:client/client-created-with-new",
+		"net/http:client/default-client",
+	}, ruleIDs)
+
+	require.Len(t, run.Results, 3)
+	for i, res := range run.Results {
+		assert.Equal(t, ruleIDs[i], res.RuleID)
+		assert.Equal(t, i, res.RuleIndex)
+		require.Len(t, res.Locations, 1)
+		assert.Equal(t, "main.go", res.Locations[0].PhysicalLocation.ArtifactLocation.URI)
+	}
+
+	assert.Equal(t, "note", run.Results[0].Level)
+	assert.Equal(t, "warning", run.Results[1].Level)
+	assert.Equal(t, "warning", run.Results[2].Level)
+
+	assert.Equal(t, sarifRegion{StartLine: 11, StartColumn: 2}, run.Results[2].Locations[0].PhysicalLocation.Region)
+}
+
+func TestSiteReport_WriteSARIF_Empty(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, newSiteReport().WriteSARIF(buf))
+
+	var sarif map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &sarif))
+
+	runs := sarif["runs"].([]interface{})
+	require.Len(t, runs, 1)
+
+	assert.Equal(t, []interface{}{}, runs[0].(map[string]interface{})["results"], "results are expected to be an empty array")
+}
@@ -0,0 +1,339 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/scanner"
+	"go/token"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// sourceToken is a token found in the source code along with its location
+type sourceToken struct {
+	Tok        token.Token
+	Lit        string
+	Start, End int
+}
+
+// Key returns the string identifying the token when comparing token sequences. Automatically inserted semicolons
+// are not distinguished from explicit ones.
+func (t sourceToken) Key() string {
+	if t.Tok == token.SEMICOLON {
+		return t.Tok.String()
+	}
+
+	return t.Tok.String() + " " + t.Lit
+}
+
+// scanSource splits the source code into tokens and comments
+func scanSource(src []byte) ([]sourceToken, []sourceToken, error) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+
+	var errs scanner.ErrorList
+
+	var s scanner.Scanner
+	s.Init(file, src, func(pos token.Position, msg string) { errs.Add(pos, msg) }, scanner.ScanComments)
+
+	var tokens, comments []sourceToken
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+
+		t := sourceToken{Tok: tok, Lit: lit, Start: file.Offset(pos)}
+		t.End = t.Start + tokenLength(src[t.Start:], tok, lit)
+
+		if tok == token.COMMENT {
+			comments = append(comments, t)
+			continue
+		}
+
+		tokens = append(tokens, t)
+	}
+
+	if errs.Len() > 0 {
+		return nil, nil, errs.Err()
+	}
+
+	return tokens, comments, nil
+}
+
+// tokenLength returns the length of the token text at the beginning of `src`. The literal value returned by
+// the scanner cannot be used for that, since carriage returns are removed from raw strings and comments.
+func tokenLength(src []byte, tok token.Token, lit string) int {
+	switch {
+	case tok == token.SEMICOLON && lit != ";":
+		// automatically inserted semicolons do not occupy any space
+		return 0
+	case tok == token.STRING && strings.HasPrefix(lit, "`"):
+		return bytes.IndexByte(src[1:], '`') + 2
+	case tok == token.COMMENT && strings.HasPrefix(lit, "/*"):
+		return bytes.Index(src, []byte("*/")) + 2
+	case tok == token.COMMENT:
+		if n := bytes.IndexByte(src, '\n'); n >= 0 {
+			return n
+		}
+
+		return len(src)
+	case lit != "":
+		return len(lit)
+	default:
+		return len(tok.String())
+	}
+}
+
+// sourceEdit replaces the source code between Start and End offsets with Text
+type sourceEdit struct {
+	Start, End int
+	Text       string
+}
+
+// rewriteFile applies the changes made to the file by instrumentation recipes to its original source code. The file
+// is printed without comments, so that the layout of the inserted code is not affected by the comments nearby.
+func rewriteFile(fset *token.FileSet, fName string, f *ast.File, original []byte) ([]byte, error) {
+	bare := *f
+	bare.Comments = nil
+
+	instrumented, err := renderNode(fset, fName, &bare)
+	if err != nil {
+		return nil, err
+	}
+
+	return rewriteSource(original, instrumented)
+}
+
+// rewriteSource transfers the changes made to the instrumented code back to the original source as a set of text
+// edits, so that the comments, blank lines and layout of the code that has not been changed by instrumentation
+// recipes are kept as is. The edits are calculated by comparing token sequences of both versions. Comments of the
+// instrumented code are ignored, since they might have been moved by the printer, so it's preferable to provide
+// the instrumented code printed without comments. An error is returned if the rewritten code does not consist of
+// the same tokens as the instrumented one.
+func rewriteSource(original, instrumented []byte) ([]byte, error) {
+	origTokens, origComments, err := scanSource(original)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan original code: %w", err)
+	}
+
+	instTokens, instComments, err := scanSource(instrumented)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan instrumented code: %w", err)
+	}
+
+	if len(origTokens) == 0 {
+		return nil, errors.New("original code is empty")
+	}
+
+	diffs := diffSequences(tokenKeys(origTokens), tokenKeys(instTokens))
+
+	var (
+		edits  []sourceEdit
+		oi, ii int // the number of processed original and instrumented tokens
+	)
+	for i := 0; i < len(diffs); {
+		if diffs[i].Type == diffmatchpatch.DiffEqual {
+			n := len([]rune(diffs[i].Text))
+			oi, ii = oi+n, ii+n
+			i++
+
+			continue
+		}
+
+		// a hunk consists of all consequent deletions and insertions
+		oa, ia := oi, ii
+		for ; i < len(diffs) && diffs[i].Type != diffmatchpatch.DiffEqual; i++ {
+			n := len([]rune(diffs[i].Text))
+			if diffs[i].Type == diffmatchpatch.DiffDelete {
+				oi += n
+			} else {
+				ii += n
+			}
+		}
+
+		edits = append(edits, hunkEdit(original, instrumented, origTokens, oa, oi, instTokens, ia, ii, origComments, instComments))
+	}
+
+	var buf bytes.Buffer
+
+	var offset int
+	for _, e := range edits {
+		buf.Write(original[offset:e.Start])
+		buf.WriteString(e.Text)
+		offset = e.End
+	}
+	buf.Write(original[offset:])
+
+	rewritten := buf.Bytes()
+
+	rewrittenTokens, _, err := scanSource(rewritten)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan rewritten code: %w", err)
+	}
+
+	if strings.Join(tokenKeys(rewrittenTokens), "\n") != strings.Join(tokenKeys(instTokens), "\n") {
+		return nil, errors.New("rewritten code does not match the instrumented one")
+	}
+
+	// the layout of the code is normalized only if the original code has been formatted
+	if formatted, err := format.Source(original); err == nil && bytes.Equal(formatted, original) {
+		if rewritten, err = format.Source(rewritten); err != nil {
+			return nil, fmt.Errorf("failed to format rewritten code: %w", err)
+		}
+	}
+
+	return rewritten, nil
+}
+
+// hunkEdit returns the edit that replaces original tokens from `oa` to `ob` with the instrumented tokens from `ia`
+// to `ib`. The comments found among replaced original tokens are kept in front of the inserted code, while the
+// comments of the instrumented code, if any, are dropped.
+func hunkEdit(original, instrumented []byte, origTokens []sourceToken, oa, ob int, instTokens []sourceToken, ia, ib int, origComments, instComments []sourceToken) sourceEdit {
+	if oa == ob {
+		// the code is inserted in front of the following token along with the whitespace that separates them,
+		// so that the line breaks preceding the insertion point keep their meaning
+		if ob == len(origTokens) {
+			pos := origTokens[oa-1].End
+
+			return sourceEdit{
+				Start: pos,
+				End:   pos,
+				Text:  stripComments(instrumented, instTokens[ia-1].End, instTokens[ib-1].End, instComments),
+			}
+		}
+
+		pos := origTokens[ob].Start
+
+		// an automatically inserted semicolon is placed at the line break or the comment that follows the preceding
+		// token, so the code is inserted right after that token instead
+		if ob > 0 && isAutoSemicolon(origTokens[ob]) {
+			pos = origTokens[ob-1].End
+		}
+
+		// the whitespace preceding the inserted code is kept if it breaks the line, e.g. to separate an added
+		// import spec, or if there is nothing else to separate the inserted code from the preceding token
+		var lead string
+		if ia > 0 {
+			lead = string(instrumented[instTokens[ia-1].End:instTokens[ia].Start])
+			if !strings.Contains(lead, "\n") && pos > origTokens[ob-1].End {
+				lead = ""
+			}
+		}
+
+		return sourceEdit{
+			Start: pos,
+			End:   pos,
+			Text:  lead + stripComments(instrumented, instTokens[ia].Start, instTokens[ib].Start, instComments),
+		}
+	}
+
+	e := sourceEdit{
+		Start: origTokens[oa].Start,
+		End:   origTokens[ob-1].End,
+	}
+	e.Text = keptComments(original, e.Start, e.End, origComments)
+
+	if ia < ib {
+		e.Text += stripComments(instrumented, instTokens[ia].Start, instTokens[ib-1].End, instComments)
+	}
+
+	return e
+}
+
+// isAutoSemicolon returns whether the token is a semicolon inserted automatically by the scanner
+func isAutoSemicolon(t sourceToken) bool {
+	return t.Tok == token.SEMICOLON && t.Lit != ";"
+}
+
+// stripComments returns the code between `start` and `end` offsets with all comments removed
+func stripComments(src []byte, start, end int, comments []sourceToken) string {
+	var buf strings.Builder
+
+	offset := start
+	for _, c := range comments {
+		if c.Start < start || c.End > end {
+			continue
+		}
+
+		buf.Write(src[offset:c.Start])
+		// a removed comment still separates the surrounding tokens
+		switch {
+		case strings.HasPrefix(c.Lit, "//"):
+		case bytes.ContainsRune(src[c.Start:c.End], '\n'):
+			buf.WriteByte('\n')
+		default:
+			buf.WriteByte(' ')
+		}
+
+		offset = c.End
+	}
+	buf.Write(src[offset:end])
+
+	return buf.String()
+}
+
+// keptComments returns the comments found between `start` and `end` offsets, each followed by a line break or a space
+func keptComments(src []byte, start, end int, comments []sourceToken) string {
+	var buf strings.Builder
+	for _, c := range comments {
+		if c.Start < start || c.End > end {
+			continue
+		}
+
+		buf.Write(src[c.Start:c.End])
+		if strings.HasPrefix(c.Lit, "//") {
+			buf.WriteByte('\n')
+		} else {
+			buf.WriteByte(' ')
+		}
+	}
+
+	return buf.String()
+}
+
+// tokenKeys returns the keys of provided tokens
+func tokenKeys(tokens []sourceToken) []string {
+	keys := make([]string, len(tokens))
+	for i, t := range tokens {
+		keys[i] = t.Key()
+	}
+
+	return keys
+}
+
+// diffSequences compares two sequences of strings element by element. Since each distinct element is encoded as
+// a single rune, the length of the returned diff texts in runes is equal to the number of elements they contain.
+// The diff is calculated without a time limit, since the coarser diff returned once the limit is reached depends
+// on timing and would make the output differ between runs.
+func diffSequences(a, b []string) []diffmatchpatch.Diff {
+	runes := make(map[string]rune)
+	encode := func(seq []string) []rune {
+		encoded := make([]rune, len(seq))
+		for i, s := range seq {
+			r, ok := runes[s]
+			if !ok {
+				r = rune(len(runes) + 1)
+				// surrogate halves are not valid runes and would be lost when converted to diff texts
+				if r >= 0xD800 {
+					r += 0x800
+				}
+				runes[s] = r
+			}
+			encoded[i] = r
+		}
+
+		return encoded
+	}
+
+	dmp := diffmatchpatch.New()
+	dmp.DiffTimeout = 0
+
+	return dmp.DiffMainRunes(encode(a), encode(b), false)
+}
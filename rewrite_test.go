@@ -0,0 +1,219 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrumentCode_PreservesComments(t *testing.T) {
+	srcDir := t.TempDir()
+
+	writeFile(t, filepath.Join(srcDir, instanaGoFileName), `// Code generated by go-instana, DO NOT EDIT.
+
+package main
+
+import instana "github.com/instana/go-sensor"
+
+var __instanaSensor = instana.NewSensor("")
+`)
+
+	writeFile(t, filepath.Join(srcDir, "main.go"), `package main
+
+import (
+	"net/http" // server and client
+
+	instana "github.com/instana/go-sensor"
+)
+
+var opts = instana.DefaultOptions()
+
+// handler serves requests
+func handler(w http.ResponseWriter, req *http.Request) {}
+
+func main() {
+	// register the handler
+	http.HandleFunc("/", handler) // root
+
+	client := &http.Client{
+		// no options
+	}
+
+	// send the request
+	client.Get("https://example.com")
+}
+`)
+
+	require.NoError(t, instrumentCode(log.Logger, srcDir, nil, nil, nil))
+
+	data, err := os.ReadFile(filepath.Join(srcDir, "main.go"))
+	require.NoError(t, err)
+
+	assert.Equal(t, `package main
+
+import (
+	"net/http" // server and client
+
+	instana "github.com/instana/go-sensor"
+)
+
+var opts = instana.DefaultOptions()
+
+// handler serves requests
+func handler(w http.ResponseWriter, req *http.Request) {}
+
+func main() {
+	// register the handler
+	http.HandleFunc("/", instana.TracingHandlerFunc(__instanaSensor, "/", handler)) // root
+
+	client := &http.Client{
+		// no options
+		Transport: instana.RoundTripper(__instanaSensor, nil)}
+
+	// send the request
+	client.Get("https://example.com")
+}
+`, string(data))
+}
+
+func TestRewriteSource(t *testing.T) {
+	examples := map[string]struct {
+		Original, Instrumented, Expected string
+	}{
+		"unformatted code is kept as is": {
+			Original: `package main
+
+func main() {
+  f(a,   /* b */ b) // f
+}
+`,
+			Instrumented: `package main
+
+func main() {
+	f(a, wrap(b))
+}
+`,
+			Expected: `package main
+
+func main() {
+  f(a,   /* b */ wrap(b)) // f
+}
+`,
+		},
+		"comments of replaced code are kept": {
+			Original: `package main
+
+func main() {
+	f(a.b /* b */ .c, d)
+}
+`,
+			Instrumented: `package main
+
+func main() {
+	g(e, d)
+}
+`,
+			Expected: `package main
+
+func main() {
+	g(/* b */ e, d)
+}
+`,
+		},
+		"line breaks are preserved": {
+			Original: `package main
+
+func main() {
+	f(
+		a, // a
+		b, // b
+	)
+}
+`,
+			Instrumented: `package main
+
+func main() {
+	f(a, b, c)
+}
+`,
+			Expected: `package main
+
+func main() {
+	f(
+		a, // a
+		b, // b
+		c)
+}
+`,
+		},
+		"code is inserted before a comment ending the line": {
+			Original: `package main
+
+func main() {
+  x := f(a)  // f
+}
+`,
+			Instrumented: `package main
+
+func main() {
+	x := f(a)(b)
+}
+`,
+			Expected: `package main
+
+func main() {
+  x := f(a)(b)  // f
+}
+`,
+		},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			rewritten, err := rewriteSource([]byte(example.Original), []byte(example.Instrumented))
+			require.NoError(t, err)
+
+			assert.Equal(t, example.Expected, string(rewritten))
+		})
+	}
+}
+
+func TestRewriteSource_AddedImport(t *testing.T) {
+	rewritten, err := rewriteSource([]byte(`package main
+
+import (
+	"fmt"
+	"net/http" // http
+)
+`), []byte(`package main
+
+import (
+	"fmt"
+	"net/http"
+
+	instana "github.com/instana/go-sensor"
+)
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, `package main
+
+import (
+	"fmt"
+	"net/http" // http
+
+	instana "github.com/instana/go-sensor"
+)
+`, string(rewritten))
+}
+
+func TestRewriteSource_Mismatch(t *testing.T) {
+	_, err := rewriteSource([]byte("package main\n"), []byte("package main\n\nvar x = `"))
+	assert.Error(t, err)
+}
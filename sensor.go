@@ -22,7 +22,7 @@ var headLineRegexp = regexp.MustCompile(`^// Code generated by go-instana.*, DO
 // lookupInstanaSensorInPackage searches for the first instana.Sensor instance available in the package
 // scope and returns its name
 func lookupInstanaSensorInPackage(pkg *ast.Package) string {
-	if n := lookupInstanaSensor(pkg.Scope); n != "" {
+	if n := lookupInstanaSensor(pkg.Scope, "instana"); n != "" {
 		return n
 	}
 
@@ -31,7 +31,7 @@ func lookupInstanaSensorInPackage(pkg *ast.Package) string {
 			continue
 		}
 
-		if n := lookupInstanaSensor(f.Scope); n != "" {
+		if n := lookupInstanaSensor(f.Scope, sensorPackageName(f)); n != "" {
 			return n
 		}
 	}
@@ -39,7 +39,24 @@ func lookupInstanaSensorInPackage(pkg *ast.Package) string {
 	return ""
 }
 
-func lookupInstanaSensor(sc *ast.Scope) string {
+// sensorPackageName returns the local name of the Instana sensor package imported by the file
+func sensorPackageName(f *ast.File) string {
+	for _, imp := range f.Imports {
+		if imp.Path == nil || strings.Trim(imp.Path.Value, `"`) != SensorPackage {
+			continue
+		}
+
+		if imp.Name != nil {
+			return imp.Name.Name
+		}
+	}
+
+	return "instana"
+}
+
+// lookupInstanaSensor searches for the instana.Sensor instance in the scope, where `instanaPkg` is the local
+// name of the Instana sensor package
+func lookupInstanaSensor(sc *ast.Scope, instanaPkg string) string {
 	if sc == nil {
 		return ""
 	}
@@ -58,7 +75,7 @@ func lookupInstanaSensor(sc *ast.Scope) string {
 		// Does it have type specified? If so, this might be a global sensor
 		// variable initialized later. We need to check whether it's an instana.Sensor
 		if valSpec.Type != nil {
-			if pkg, typ := extractSelectorPackageAndName(valSpec.Type); pkg == "instana" && typ == "Sensor" {
+			if pkg, typ := extractSelectorPackageAndName(valSpec.Type); pkg == instanaPkg && typ == "Sensor" {
 				return obj.Name
 			}
 		}
@@ -67,7 +84,7 @@ func lookupInstanaSensor(sc *ast.Scope) string {
 		for i, val := range valSpec.Values {
 			if fnCall, ok := val.(*ast.CallExpr); ok {
 				pkg, fnName := extractSelectorPackageAndName(fnCall.Fun)
-				if pkg == "instana" && strings.HasPrefix(fnName, "NewSensor") {
+				if pkg == instanaPkg && strings.HasPrefix(fnName, "NewSensor") {
 					return valSpec.Names[i].Name
 				}
 			}
@@ -4,8 +4,17 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"github.com/instana/go-instana/internal/registry"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime/debug"
 	"strings"
 )
 
@@ -24,9 +33,11 @@ func parseToolchainCmd(args []string) *exec.Cmd {
 }
 
 type toolchainCompileArgs struct {
-	Output  string
-	Package string
-	Files   []string
+	Output    string
+	Package   string
+	ImportCfg string
+	TrimPath  string
+	Files     []string
 }
 
 // Complete returns whether $GOTOOLDIR/compile has been called to compile a single package
@@ -53,6 +64,18 @@ func parseToolchainCompileArgs(args []string) (toolchainCompileArgs, error) {
 			}
 
 			flags.Package = args[i+1]
+		case "-importcfg":
+			if i+1 >= len(args) || strings.HasPrefix(args[i+1], "-") {
+				return flags, fmt.Errorf("compile tool -importcfg flag missing mandatory value")
+			}
+
+			flags.ImportCfg = args[i+1]
+		case "-trimpath":
+			if i+1 >= len(args) || strings.HasPrefix(args[i+1], "-") {
+				return flags, fmt.Errorf("compile tool -trimpath flag missing mandatory value")
+			}
+
+			flags.TrimPath = args[i+1]
 		}
 	}
 
@@ -66,3 +89,111 @@ func parseToolchainCompileArgs(args []string) (toolchainCompileArgs, error) {
 
 	return flags, nil
 }
+
+// replaceCompileFiles returns a copy of $GOTOOLDIR/compile args with source files substituted according to
+// the replacements map. Files that have no replacement are left intact.
+func replaceCompileFiles(args []string, replacements map[string]string) []string {
+	res := make([]string, len(args))
+	copy(res, args)
+
+	for i := len(res) - 1; i >= 0; i-- {
+		if !strings.HasSuffix(res[i], ".go") {
+			break
+		}
+
+		if dst, ok := replacements[res[i]]; ok {
+			res[i] = dst
+		}
+	}
+
+	return res
+}
+
+// isToolVersionCmd returns whether the command is the `$GOTOOLDIR/compile -V=full` call, that is used by
+// the go command to compute the compiler ID for build caching
+func isToolVersionCmd(cmd *exec.Cmd) bool {
+	return filepath.Base(cmd.Path) == "compile" && len(cmd.Args) == 2 && cmd.Args[1] == "-V=full"
+}
+
+// forwardToolVersionCmd runs the `$GOTOOLDIR/compile -V=full` command and amends its output with the
+// go-instana configuration hash, so that any change in go-instana version or its settings invalidates
+// previously cached package objects
+func forwardToolVersionCmd(cmd *exec.Cmd) error {
+	buf := bytes.NewBuffer(nil)
+
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = buf
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintln(os.Stdout, toolIDWithConfig(buf.String(), configHash()))
+
+	return err
+}
+
+// toolIDWithConfig adds go-instana config hash to the output of the `$GOTOOLDIR/compile -V=full` command.
+// For release versions of Go the whole line is used as a tool ID, so the hash is appended to the end of it.
+// Development versions report the build ID of the compiler as the last field, which content ID part is used
+// instead and needs to be replaced with a hash derived from both the original value and go-instana config.
+func toolIDWithConfig(line, hash string) string {
+	line = strings.TrimSpace(line)
+
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.Contains(fields[2], "devel") || !strings.HasPrefix(fields[len(fields)-1], "buildID=") {
+		return line + " go-instana:" + hash
+	}
+
+	buildID := fields[len(fields)-1]
+
+	actionID, contentID := "", buildID
+	if i := strings.LastIndex(buildID, "/"); i >= 0 {
+		actionID, contentID = buildID[:i+1], buildID[i+1:]
+	}
+
+	h := sha256.Sum256([]byte(contentID + " go-instana:" + hash))
+	fields[len(fields)-1] = actionID + base64.RawURLEncoding.EncodeToString(h[:15])
+
+	return strings.Join(fields, " ")
+}
+
+// configHash returns a hash of go-instana version, the set of enabled instrumentation recipes and the
+// configuration flags affecting the instrumentation
+func configHash() string {
+	names := registry.Default.ListNames()
+
+	h := sha256.New()
+	fmt.Fprintf(h, "version=%s\n", goInstanaVersion())
+	fmt.Fprintf(h, "recipes=%s\n", strings.Join(names, ","))
+	fmt.Fprintf(h, "excluded=%s\n", args.ExcludedPackages.String())
+	fmt.Fprintf(h, "tags=%s\n", args.BuildTags)
+	fmt.Fprintf(h, "deps=%s\n", args.Dependencies.String())
+	fmt.Fprintf(h, "vendor=%s\n", args.VendoredModules.String())
+	fmt.Fprintf(h, "strict=%t\n", args.Strict)
+
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// goInstanaVersion returns the module version of go-instana binary. For development builds, where no version
+// is available, the hash of the executable is returned instead.
+func goInstanaVersion() string {
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		return bi.Main.Version
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return "(devel)"
+	}
+
+	data, err := ioutil.ReadFile(exe)
+	if err != nil {
+		return "(devel)"
+	}
+
+	h := sha256.Sum256(data)
+
+	return "(devel)-" + hex.EncodeToString(h[:8])
+}
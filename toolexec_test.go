@@ -5,6 +5,7 @@ package main
 
 import (
 	"os/exec"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -21,3 +22,65 @@ func TestParseToolchainCmd(t *testing.T) {
 	assert.Equal(t, binPath, cmd.Path)
 	assert.Equal(t, args, cmd.Args)
 }
+
+func TestParseToolchainCompileArgs(t *testing.T) {
+	args := []string{"-o", "/tmp/b001/_pkg_.a", "-trimpath", "/tmp/b001=>", "-p", "main", "-pack", "/src/app/main.go", "/src/app/handlers.go"}
+
+	flags, err := parseToolchainCompileArgs(args)
+	require.NoError(t, err)
+
+	assert.True(t, flags.Complete())
+	assert.Equal(t, "/tmp/b001/_pkg_.a", flags.Output)
+	assert.Equal(t, "main", flags.Package)
+	assert.Equal(t, "/tmp/b001=>", flags.TrimPath)
+	assert.ElementsMatch(t, []string{"/src/app/main.go", "/src/app/handlers.go"}, flags.Files)
+}
+
+func TestReplaceCompileFiles(t *testing.T) {
+	args := []string{"-o", "/tmp/b001/_pkg_.a", "-p", "main", "/src/app/main.go", "/src/app/handlers.go"}
+
+	replaced := replaceCompileFiles(args, map[string]string{
+		"/src/app/main.go": "/tmp/b001/go-instana/main.go",
+	})
+
+	assert.Equal(t, []string{"-o", "/tmp/b001/_pkg_.a", "-p", "main", "/tmp/b001/go-instana/main.go", "/src/app/handlers.go"}, replaced)
+	assert.Equal(t, "/src/app/main.go", args[4], "original args must not be modified")
+}
+
+func TestToolIDWithConfig(t *testing.T) {
+	t.Run("release", func(t *testing.T) {
+		assert.Equal(t,
+			"compile version go1.18.3 go-instana:abcdef",
+			toolIDWithConfig("compile version go1.18.3\n", "abcdef"),
+		)
+	})
+
+	t.Run("devel", func(t *testing.T) {
+		line := "compile version devel go1.19-4a1e8a5 Tue Jun 7 12:43:10 2022 +0000 buildID=vV4ZHXKrs6GM5pmNQ8jK/Tp1UKL5yzE4bSqNJFh9E"
+
+		id := toolIDWithConfig(line, "abcdef")
+		assert.True(t, strings.HasPrefix(id, "compile version devel go1.19-4a1e8a5 Tue Jun 7 12:43:10 2022 +0000 buildID=vV4ZHXKrs6GM5pmNQ8jK/"))
+		assert.NotEqual(t, line, id)
+		assert.NotEqual(t, id, toolIDWithConfig(line, "123456"), "tool ID must depend on go-instana config")
+	})
+}
+
+func TestConfigHash(t *testing.T) {
+	defer func(excluded, vendored arrayFlags, strict bool) {
+		args.ExcludedPackages, args.VendoredModules, args.Strict = excluded, vendored, strict
+	}(args.ExcludedPackages, args.VendoredModules, args.Strict)
+
+	h := configHash()
+	assert.Equal(t, h, configHash())
+
+	args.ExcludedPackages = arrayFlags{"net/http"}
+	assert.NotEqual(t, h, configHash())
+	h = configHash()
+
+	args.VendoredModules = arrayFlags{"github.com/gin-gonic/gin"}
+	assert.NotEqual(t, h, configHash())
+	h = configHash()
+
+	args.Strict = true
+	assert.NotEqual(t, h, configHash())
+}
@@ -0,0 +1,255 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/instana/go-instana/internal/recipes"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/tools/go/packages"
+)
+
+// exportData maps package import paths to the files containing their compiled export data
+type exportData map[string]string
+
+// loadExportData returns the export data of the packages matching provided patterns along with all their
+// dependencies. The packages are loaded in `dir` using the go command, that compiles them if necessary.
+// If the packages cannot be loaded, the error is logged and nil is returned, so that the code is type-checked
+// without dependencies.
+func loadExportData(dir string, patterns ...string) exportData {
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedImports | packages.NeedDeps | packages.NeedExportsFile,
+		Dir:  dir,
+	}
+
+	if args.BuildTags != "" {
+		cfg.BuildFlags = []string{"-tags=" + args.BuildTags}
+	}
+
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		log.Debug().Msgf("failed to load export data for %s: %s", strings.Join(patterns, " "), err)
+		return nil
+	}
+
+	data := make(exportData)
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		if pkg.ExportFile != "" {
+			data[pkg.PkgPath] = pkg.ExportFile
+		}
+
+		// the import path might differ from the package path, e.g. for vendored packages
+		for path, imp := range pkg.Imports {
+			if imp.ExportFile != "" {
+				data[path] = imp.ExportFile
+			}
+		}
+	})
+
+	return data
+}
+
+// readImportCfg reads the export data locations from the import config file passed to the compiler with
+// the -importcfg flag
+func readImportCfg(fName string) (exportData, error) {
+	fd, err := os.Open(fName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open import config: %w", err)
+	}
+	defer fd.Close()
+
+	var (
+		data      = make(exportData)
+		importMap = make(map[string]string)
+	)
+
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		verb, rest, _ := strings.Cut(line, " ")
+		before, after, ok := strings.Cut(rest, "=")
+		if !ok {
+			continue
+		}
+
+		switch verb {
+		case "packagefile":
+			data[before] = after
+		case "importmap":
+			importMap[before] = after
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read import config: %w", err)
+	}
+
+	for importPath, pkgPath := range importMap {
+		if fName, ok := data[pkgPath]; ok {
+			data[importPath] = fName
+		}
+	}
+
+	return data, nil
+}
+
+// Importer returns the importer that uses the export data to import packages. Packages without export data
+// are substituted with empty fake packages, so that the type checker is still able to resolve package names
+// and the types declared in the checked package.
+func (data exportData) Importer(logger zerolog.Logger, fset *token.FileSet) types.Importer {
+	imp := &fallbackImporter{
+		fakes:  make(map[string]*types.Package),
+		logger: logger,
+	}
+
+	if len(data) > 0 {
+		imp.exportData = importer.ForCompiler(fset, "gc", func(path string) (io.ReadCloser, error) {
+			fName, ok := data[path]
+			if !ok {
+				return nil, fmt.Errorf("no export data for %s", path)
+			}
+
+			return os.Open(fName)
+		})
+	}
+
+	return imp
+}
+
+// fallbackImporter imports packages using export data if available and falls back to fake packages otherwise
+type fallbackImporter struct {
+	exportData types.Importer
+	fakes      map[string]*types.Package
+	logger     zerolog.Logger
+}
+
+// isFake returns whether the package has been substituted with a fake one
+func (imp *fallbackImporter) isFake(pkg *types.Package) bool {
+	return imp.fakes[pkg.Path()] == pkg
+}
+
+// Import implements types.Importer
+func (imp *fallbackImporter) Import(path string) (*types.Package, error) {
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
+
+	if imp.exportData != nil {
+		pkg, err := imp.exportData.Import(path)
+		if err == nil {
+			return pkg, nil
+		}
+
+		imp.logger.Debug().Msgf("failed to import %s, using fake package instead: %s", path, err)
+	}
+
+	if pkg, ok := imp.fakes[path]; ok {
+		return pkg, nil
+	}
+
+	pkg := types.NewPackage(path, recipes.ExtractLocalImportName(path))
+	pkg.MarkComplete()
+	imp.fakes[path] = pkg
+
+	return pkg, nil
+}
+
+// typeCheckPackage type-checks the package files and returns the collected type information along with type errors.
+// Type errors, e.g. caused by missing dependencies, are logged and ignored, so that the returned info is populated
+// as much as possible. The errors caused by missing export data of imported packages are not returned.
+func typeCheckPackage(logger zerolog.Logger, fset *token.FileSet, pkg *ast.Package, imp types.Importer) (*types.Info, []types.Error) {
+	fNames := make([]string, 0, len(pkg.Files))
+	for fName := range pkg.Files {
+		fNames = append(fNames, fName)
+	}
+	sort.Strings(fNames)
+
+	files := make([]*ast.File, 0, len(fNames))
+	for _, fName := range fNames {
+		files = append(files, pkg.Files[fName])
+	}
+
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		Implicits:  make(map[ast.Node]types.Object),
+	}
+
+	var errs []types.Error
+	conf := types.Config{
+		Importer:    imp,
+		FakeImportC: true,
+		Error: func(err error) {
+			if len(errs) == 0 {
+				logger.Debug().Msgf("%s: type checking error: %s", pkg.Name, err)
+			}
+
+			if typeErr, ok := err.(types.Error); ok {
+				errs = append(errs, typeErr)
+			}
+		},
+	}
+
+	// the returned error is reported to the Error callback as well
+	conf.Check(pkg.Name, fset, files, info)
+
+	if len(errs) > 0 {
+		logger.Debug().Msgf("%s: type checking finished with %d error(s)", pkg.Name, len(errs))
+	}
+
+	return info, withoutFakeImportErrors(files, info, imp, errs)
+}
+
+// withoutFakeImportErrors removes the errors about undefined names of packages substituted with fake ones from
+// the list, since these names are only missing due to the lack of export data
+func withoutFakeImportErrors(files []*ast.File, info *types.Info, imp types.Importer, errs []types.Error) []types.Error {
+	fallback, ok := imp.(*fallbackImporter)
+	if !ok || len(fallback.fakes) == 0 || len(errs) == 0 {
+		return errs
+	}
+
+	// undefined qualified identifiers are reported at the position of the selected name
+	fakeSelectors := make(map[token.Pos]bool)
+	for _, f := range files {
+		ast.Inspect(f, func(node ast.Node) bool {
+			sel, ok := node.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+
+			if ident, ok := sel.X.(*ast.Ident); ok {
+				if pkgName, ok := info.Uses[ident].(*types.PkgName); ok && fallback.isFake(pkgName.Imported()) {
+					fakeSelectors[sel.Sel.Pos()] = true
+				}
+			}
+
+			return true
+		})
+	}
+
+	var res []types.Error
+	for _, err := range errs {
+		if !fakeSelectors[err.Pos] {
+			res = append(res, err)
+		}
+	}
+
+	return res
+}
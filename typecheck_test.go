@@ -0,0 +1,72 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadImportCfg(t *testing.T) {
+	fName := filepath.Join(t.TempDir(), "importcfg")
+	writeFile(t, fName, `# import config
+packagefile fmt=/cache/fmt.a
+packagefile example.com/app/vendor/example.com/lib=/cache/lib.a
+importmap example.com/lib=example.com/app/vendor/example.com/lib
+importmap example.com/missing=example.com/app/vendor/example.com/missing
+`)
+
+	data, err := readImportCfg(fName)
+	require.NoError(t, err)
+
+	assert.Equal(t, exportData{
+		"fmt":                                    "/cache/fmt.a",
+		"example.com/app/vendor/example.com/lib": "/cache/lib.a",
+		"example.com/lib":                        "/cache/lib.a",
+	}, data)
+}
+
+func TestTypeCheckPackage_FakeImports(t *testing.T) {
+	fset := token.NewFileSet()
+
+	f, err := parser.ParseFile(fset, "main.go", `package main
+
+import (
+	web "net/http"
+	"github.com/example/lib"
+)
+
+func main() {
+	web.HandleFunc("/", nil)
+	lib.Do()
+}
+`, 0)
+	require.NoError(t, err)
+
+	pkg := &ast.Package{
+		Name:  "main",
+		Files: map[string]*ast.File{"main.go": f},
+	}
+
+	info, _ := typeCheckPackage(log.Logger, fset, pkg, exportData(nil).Importer(log.Logger, fset))
+
+	imported := make(map[string]string)
+	for id, obj := range info.Uses {
+		if pkgName, ok := obj.(*types.PkgName); ok {
+			imported[id.Name] = pkgName.Imported().Path()
+		}
+	}
+
+	assert.Equal(t, map[string]string{
+		"web": "net/http",
+		"lib": "github.com/example/lib",
+	}, imported)
+}
@@ -0,0 +1,423 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	vendorModulesFileName = "modules.txt"
+	// vendorAnnotationPrefix starts the line of vendor/modules.txt recording the changes made by go-instana to
+	// a vendored module. The go command ignores unknown annotations, so the file stays consistent with go.mod.
+	vendorAnnotationPrefix = "## go-instana "
+	vendorHashPrefix       = "sha256:"
+	vendorChangedPrefix    = "changed "
+)
+
+// vendoredModule is a module listed in vendor/modules.txt
+type vendoredModule struct {
+	Path     string
+	Version  string
+	Packages []string
+	// Instrumentation is recorded by go-instana when the module is instrumented, nil otherwise
+	Instrumentation *vendorInstrumentation
+}
+
+// vendorInstrumentation describes the changes made by go-instana to a vendored module
+type vendorInstrumentation struct {
+	// Hash is the hash of the vendored module files taken after the instrumentation
+	Hash string
+	// Changed is the sorted list of files changed or added by go-instana, relative to the module dir
+	Changed []string
+}
+
+// String returns the annotation recorded in vendor/modules.txt
+func (vi vendorInstrumentation) String() string {
+	return vendorAnnotationPrefix + vendorHashPrefix + vi.Hash + "; " + vendorChangedPrefix + strings.Join(vi.Changed, ",")
+}
+
+// parseVendorInstrumentation parses the go-instana annotation line of vendor/modules.txt
+func parseVendorInstrumentation(line string) (*vendorInstrumentation, bool) {
+	if !strings.HasPrefix(line, vendorAnnotationPrefix) {
+		return nil, false
+	}
+
+	vi := &vendorInstrumentation{}
+	for _, entry := range strings.Split(strings.TrimPrefix(line, vendorAnnotationPrefix), ";") {
+		entry = strings.TrimSpace(entry)
+
+		switch {
+		case strings.HasPrefix(entry, vendorHashPrefix):
+			vi.Hash = strings.TrimPrefix(entry, vendorHashPrefix)
+		case strings.HasPrefix(entry, vendorChangedPrefix):
+			for _, fName := range strings.Split(strings.TrimPrefix(entry, vendorChangedPrefix), ",") {
+				if fName != "" {
+					vi.Changed = append(vi.Changed, fName)
+				}
+			}
+		}
+	}
+
+	return vi, true
+}
+
+// readVendoredModules parses the vendor/modules.txt file located in `vendorDir`
+func readVendoredModules(vendorDir string) ([]*vendoredModule, error) {
+	data, err := ioutil.ReadFile(filepath.Join(vendorDir, vendorModulesFileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vendored modules list: %w", err)
+	}
+
+	var (
+		modules []*vendoredModule
+		mod     *vendoredModule
+	)
+	for _, line := range strings.Split(string(data), "\n") {
+		switch {
+		case strings.HasPrefix(line, "# "):
+			f := strings.Fields(line)
+			if len(f) < 3 || f[2] == "=>" {
+				// replacement-only lines do not provide any packages
+				mod = nil
+				continue
+			}
+
+			mod = &vendoredModule{Path: f[1], Version: f[2]}
+			modules = append(modules, mod)
+		case mod == nil:
+			continue
+		case strings.HasPrefix(line, "## "):
+			if vi, ok := parseVendorInstrumentation(line); ok {
+				mod.Instrumentation = vi
+			}
+		case strings.TrimSpace(line) != "":
+			mod.Packages = append(mod.Packages, strings.TrimSpace(line))
+		}
+	}
+
+	return modules, nil
+}
+
+// findVendoredModule returns the vendored module with provided path
+func findVendoredModule(modules []*vendoredModule, modPath string) (*vendoredModule, bool) {
+	for _, mod := range modules {
+		if mod.Path == modPath {
+			return mod, true
+		}
+	}
+
+	return nil, false
+}
+
+// writeVendorInstrumentation records the instrumentation of the vendored module in vendor/modules.txt replacing
+// the previously recorded one
+func writeVendorInstrumentation(vendorDir, modPath string, vi vendorInstrumentation) error {
+	fName := filepath.Join(vendorDir, vendorModulesFileName)
+
+	data, err := ioutil.ReadFile(fName)
+	if err != nil {
+		return fmt.Errorf("failed to read vendored modules list: %w", err)
+	}
+
+	var (
+		lines    []string
+		inModule bool
+		written  bool
+	)
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "# ") {
+			f := strings.Fields(line)
+			inModule = len(f) >= 3 && f[1] == modPath && f[2] != "=>"
+		}
+
+		if inModule && strings.HasPrefix(line, vendorAnnotationPrefix) {
+			continue
+		}
+
+		// the annotation follows the metadata lines written by the go command
+		if inModule && !written && !strings.HasPrefix(line, "#") {
+			lines = append(lines, vi.String())
+			written = true
+		}
+
+		lines = append(lines, line)
+	}
+
+	if !written {
+		return fmt.Errorf("module %s is not found in %s", modPath, fName)
+	}
+
+	return writeNodeToFile(log.Logger, fName, []byte(strings.Join(lines, "\n")))
+}
+
+// vendoredModuleHash returns the hash of files located in package dirs of the vendored module
+func vendoredModuleHash(vendorDir string, mod *vendoredModule) (string, error) {
+	var files []string
+	for _, pkgPath := range mod.Packages {
+		entries, err := ioutil.ReadDir(filepath.Join(vendorDir, filepath.FromSlash(pkgPath)))
+		if err != nil {
+			return "", fmt.Errorf("failed to read vendored package %s: %w", pkgPath, err)
+		}
+
+		for _, entry := range entries {
+			if entry.Mode().IsRegular() {
+				files = append(files, pkgPath+"/"+entry.Name())
+			}
+		}
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, fName := range files {
+		data, err := ioutil.ReadFile(filepath.Join(vendorDir, filepath.FromSlash(fName)))
+		if err != nil {
+			return "", fmt.Errorf("failed to read vendored file %s: %w", fName, err)
+		}
+
+		fmt.Fprintf(h, "%x  %s\n", sha256.Sum256(data), fName)
+	}
+
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// vendoredModuleChanged returns whether the files of the vendored module have been changed since
+// the last instrumentation, e.g. by re-vendoring. The modules that have never been instrumented
+// are reported as unchanged.
+func vendoredModuleChanged(vendorDir string, mod *vendoredModule) (bool, error) {
+	if mod.Instrumentation == nil {
+		return false, nil
+	}
+
+	hash, err := vendoredModuleHash(vendorDir, mod)
+	if err != nil {
+		return false, err
+	}
+
+	return hash != mod.Instrumentation.Hash, nil
+}
+
+// vendorPackageProcessor applies changes to the vendored package located in `dir` and returns the list of
+// changed files
+type vendorPackageProcessor func(dir, sensorPkgPath string, deps exportData) ([]string, error)
+
+// addVendoredModules handles the `go-instana -vendor <module> add` execution. It adds the instrumentation
+// imports along with the sensor provided by a package generated inside the main module to the packages of
+// selected vendored modules.
+func addVendoredModules(cwd string, modPaths []string) error {
+	return processVendoredModules(cwd, modPaths, addVendoredPackage)
+}
+
+// instrumentVendoredModules handles the `go-instana -vendor <module> instrument` execution. It applies
+// instrumentation recipes to the packages of selected vendored modules.
+func instrumentVendoredModules(cwd string, modPaths []string) error {
+	return processVendoredModules(cwd, modPaths, instrumentVendoredPackage)
+}
+
+// processVendoredModules applies changes to all packages of the selected vendored modules and records
+// them in vendor/modules.txt
+func processVendoredModules(cwd string, modPaths []string, process vendorPackageProcessor) error {
+	mainModule, err := mainModuleForDir(cwd)
+	if err != nil {
+		return err
+	}
+
+	vendorDir := filepath.Join(mainModule.Dir, "vendor")
+
+	modules, err := readVendoredModules(vendorDir)
+	if err != nil {
+		return err
+	}
+
+	sensorPkgPath := mainModule.Path + "/" + depsSensorPackageDir
+
+	var instrumented bool
+	for _, modPath := range modPaths {
+		if isInstanaModule(modPath) {
+			log.Warn().Msgf("%s: instrumentation of Instana modules is not supported, skipping", modPath)
+			continue
+		}
+
+		mod, ok := findVendoredModule(modules, modPath)
+		if !ok {
+			log.Warn().Msgf("%s: module is not vendored, skipping", modPath)
+			continue
+		}
+
+		log.Info().Msgf("processing vendored module %s@%s", mod.Path, mod.Version)
+
+		changed, err := vendoredModuleChanged(vendorDir, mod)
+		if err != nil {
+			return err
+		}
+
+		// changes recorded before re-vendoring are not there anymore
+		changedFiles := make(map[string]struct{})
+		if mod.Instrumentation == nil {
+			log.Debug().Msgf("%s: vendored module has not been instrumented yet", mod.Path)
+		} else if changed {
+			log.Warn().Msgf("%s: vendored files have been changed since the last instrumentation, probably re-vendored", mod.Path)
+		} else if mod.Instrumentation != nil {
+			for _, fName := range mod.Instrumentation.Changed {
+				changedFiles[fName] = struct{}{}
+			}
+		}
+
+		deps := loadExportData(mainModule.Dir, mod.Packages...)
+
+		modDir := filepath.Join(vendorDir, filepath.FromSlash(mod.Path))
+		for _, pkgPath := range mod.Packages {
+			files, err := process(filepath.Join(vendorDir, filepath.FromSlash(pkgPath)), sensorPkgPath, deps)
+			if err != nil {
+				log.Warn().Msgf("%s: skipping package: %s", pkgPath, err)
+				continue
+			}
+
+			for _, fName := range files {
+				rel, err := filepath.Rel(modDir, fName)
+				if err != nil {
+					return fmt.Errorf("failed to find relative path of %s: %w", fName, err)
+				}
+
+				changedFiles[filepath.ToSlash(rel)] = struct{}{}
+			}
+		}
+
+		if len(changedFiles) == 0 {
+			continue
+		}
+
+		instrumented = true
+
+		hash, err := vendoredModuleHash(vendorDir, mod)
+		if err != nil {
+			return err
+		}
+
+		vi := vendorInstrumentation{Hash: hash}
+		for fName := range changedFiles {
+			vi.Changed = append(vi.Changed, fName)
+		}
+		sort.Strings(vi.Changed)
+
+		if err := writeVendorInstrumentation(vendorDir, mod.Path, vi); err != nil {
+			return err
+		}
+	}
+
+	if !instrumented {
+		return nil
+	}
+
+	// instrumented vendored packages use the sensor provided by the generated package of the main module
+	content, err := depsSensorPackageContent()
+	if err != nil {
+		return err
+	}
+
+	fName := filepath.Join(mainModule.Dir, depsSensorPackageDir, "sensor.go")
+	if data, err := ioutil.ReadFile(fName); err == nil && bytes.Equal(data, content) {
+		return nil
+	}
+
+	if err := writeNodeToFile(log.Logger, fName, content); err != nil {
+		return fmt.Errorf("failed to write %s: %w", fName, err)
+	}
+	log.Info().Msgf("created %s", fName)
+
+	return nil
+}
+
+// addVendoredPackage (re-)generates the `instanaGoFileName` file for the vendored package located in `dir`
+func addVendoredPackage(dir, sensorPkgPath string, _ exportData) ([]string, error) {
+	filePath := filepath.Join(dir, instanaGoFileName)
+
+	var oldContent []byte
+	if data, err := ioutil.ReadFile(filePath); err == nil {
+		if !isGeneratedByGoInstana(bytes.NewBuffer(data)) {
+			return nil, fmt.Errorf("%s has not been generated by go-instana", filePath)
+		}
+
+		oldContent = data
+	}
+
+	fset := token.NewFileSet()
+
+	pkg, err := loadPackage(log.Logger, fset, dir)
+	if isNoGoError(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	delete(pkg.Files, filePath)
+
+	content, err := depsInstanaGoFileContent(filePath, pkg, sensorPkgPath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case content == nil && oldContent == nil, bytes.Equal(content, oldContent):
+		return nil, nil
+	case content == nil:
+		if err := os.Remove(filePath); err != nil {
+			return nil, fmt.Errorf("failed to remove %s: %w", filePath, err)
+		}
+
+		return []string{filePath}, nil
+	}
+
+	if err := writeNodeToFile(log.Logger, filePath, content); err != nil {
+		return nil, fmt.Errorf("failed to create file %s: %w", filePath, err)
+	}
+	log.Info().Msgf("created %s", filePath)
+
+	return []string{filePath}, nil
+}
+
+// instrumentVendoredPackage applies instrumentation recipes to the vendored package located in `dir`, that
+// has the `instanaGoFileName` file generated by `go-instana -vendor <module> add`
+func instrumentVendoredPackage(dir, _ string, deps exportData) ([]string, error) {
+	fset := token.NewFileSet()
+
+	pkg, err := loadPackage(log.Logger, fset, dir)
+	if isNoGoError(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	filePath := filepath.Join(dir, instanaGoFileName)
+	if _, ok := pkg.Files[filePath]; !ok {
+		log.Debug().Msgf("skip vendored package %s without %s", dir, instanaGoFileName)
+		return nil, nil
+	}
+
+	changes, err := instrumentPackageWithSensor(log.Logger, fset, pkg, deps, "__instanaSensor", instanaPackageImports(fset, pkg.Files))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for fName := range writeInstrumentedFiles(log.Logger, changes, "") {
+		files = append(files, fName)
+	}
+
+	return files, nil
+}
@@ -0,0 +1,157 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testVendorModulesTxt = `# example.com/dep v1.0.0
+## explicit; go 1.18
+example.com/dep
+example.com/dep/client
+# example.com/other v0.1.0
+## explicit
+example.com/other
+# example.com/replaced => ../replaced
+`
+
+func TestVendorInstrumentation_String(t *testing.T) {
+	vi := vendorInstrumentation{
+		Hash:    "abc=",
+		Changed: []string{"client/client.go", "instana_go_dependency.go"},
+	}
+
+	line := vi.String()
+	assert.Equal(t, "## go-instana sha256:abc=; changed client/client.go,instana_go_dependency.go", line)
+
+	parsed, ok := parseVendorInstrumentation(line)
+	require.True(t, ok)
+	assert.Equal(t, vi, *parsed)
+
+	_, ok = parseVendorInstrumentation("## explicit; go 1.18")
+	assert.False(t, ok)
+}
+
+func TestReadVendoredModules(t *testing.T) {
+	vendorDir := t.TempDir()
+	writeFile(t, filepath.Join(vendorDir, "modules.txt"), testVendorModulesTxt)
+
+	modules, err := readVendoredModules(vendorDir)
+	require.NoError(t, err)
+
+	assert.Equal(t, []*vendoredModule{
+		{Path: "example.com/dep", Version: "v1.0.0", Packages: []string{"example.com/dep", "example.com/dep/client"}},
+		{Path: "example.com/other", Version: "v0.1.0", Packages: []string{"example.com/other"}},
+	}, modules)
+}
+
+func TestWriteVendorInstrumentation(t *testing.T) {
+	vendorDir := t.TempDir()
+	writeFile(t, filepath.Join(vendorDir, "modules.txt"), testVendorModulesTxt)
+
+	require.NoError(t, writeVendorInstrumentation(vendorDir, "example.com/dep", vendorInstrumentation{
+		Hash:    "first",
+		Changed: []string{"dep.go"},
+	}))
+
+	// the previously recorded instrumentation is replaced
+	require.NoError(t, writeVendorInstrumentation(vendorDir, "example.com/dep", vendorInstrumentation{
+		Hash:    "second",
+		Changed: []string{"dep.go", "instana_go_dependency.go"},
+	}))
+
+	data, err := os.ReadFile(filepath.Join(vendorDir, "modules.txt"))
+	require.NoError(t, err)
+
+	assert.Equal(t, `# example.com/dep v1.0.0
+## explicit; go 1.18
+## go-instana sha256:second; changed dep.go,instana_go_dependency.go
+example.com/dep
+example.com/dep/client
+# example.com/other v0.1.0
+## explicit
+example.com/other
+# example.com/replaced => ../replaced
+`, string(data))
+
+	assert.Error(t, writeVendorInstrumentation(vendorDir, "example.com/unknown", vendorInstrumentation{Hash: "x"}))
+}
+
+func TestVendoredModuleChanged(t *testing.T) {
+	vendorDir := t.TempDir()
+	writeFile(t, filepath.Join(vendorDir, "example.com", "dep", "dep.go"), "package dep\n")
+	writeFile(t, filepath.Join(vendorDir, "example.com", "dep", "client", "client.go"), "package client\n")
+
+	mod := &vendoredModule{
+		Path:     "example.com/dep",
+		Version:  "v1.0.0",
+		Packages: []string{"example.com/dep", "example.com/dep/client"},
+	}
+
+	changed, err := vendoredModuleChanged(vendorDir, mod)
+	require.NoError(t, err)
+	assert.False(t, changed, "modules that have never been instrumented are reported as unchanged")
+
+	hash, err := vendoredModuleHash(vendorDir, mod)
+	require.NoError(t, err)
+	mod.Instrumentation = &vendorInstrumentation{Hash: hash}
+
+	changed, err = vendoredModuleChanged(vendorDir, mod)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// re-vendoring restores the original files
+	writeFile(t, filepath.Join(vendorDir, "example.com", "dep", "client", "client.go"), "package client\n\n// original\n")
+
+	changed, err = vendoredModuleChanged(vendorDir, mod)
+	require.NoError(t, err)
+	assert.True(t, changed)
+}
+
+func TestProcessVendoredModules(t *testing.T) {
+	root := t.TempDir()
+
+	writeFile(t, filepath.Join(root, "go.mod"), "module example.com/app\n\ngo 1.18\n\nrequire example.com/dep v1.0.0\n")
+	writeFile(t, filepath.Join(root, "main.go"), "package main\n\nimport _ \"example.com/dep\"\n\nfunc main() {}\n")
+	writeFile(t, filepath.Join(root, "vendor", "modules.txt"), "# example.com/dep v1.0.0\n## explicit; go 1.18\nexample.com/dep\n")
+	writeFile(t, filepath.Join(root, "vendor", "example.com", "dep", "dep.go"), `package dep
+
+import "net/http"
+
+func Get(url string) (*http.Response, error) {
+	client := &http.Client{}
+
+	return client.Get(url)
+}
+`)
+
+	t.Setenv("GOWORK", "off")
+	t.Setenv("GOFLAGS", "")
+
+	require.NoError(t, addVendoredModules(root, []string{"example.com/dep", "example.com/missing"}))
+	require.NoError(t, instrumentVendoredModules(root, []string{"example.com/dep"}))
+
+	data, err := os.ReadFile(filepath.Join(root, "vendor", "example.com", "dep", "dep.go"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "instana.RoundTripper(__instanaSensor, nil)")
+
+	assert.FileExists(t, filepath.Join(root, "vendor", "example.com", "dep", instanaGoFileName))
+	assert.FileExists(t, filepath.Join(root, depsSensorPackageDir, "sensor.go"))
+
+	modules, err := readVendoredModules(filepath.Join(root, "vendor"))
+	require.NoError(t, err)
+	require.Len(t, modules, 1)
+
+	require.NotNil(t, modules[0].Instrumentation)
+	assert.Equal(t, []string{"dep.go", instanaGoFileName}, modules[0].Instrumentation.Changed)
+
+	changed, err := vendoredModuleChanged(filepath.Join(root, "vendor"), modules[0])
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
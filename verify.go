@@ -0,0 +1,173 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"sort"
+
+	"github.com/instana/go-instana/internal/registry"
+	"github.com/rs/zerolog"
+)
+
+// instrumentedFile is a file changed by instrumentation recipes
+type instrumentedFile struct {
+	Original, Instrumented []byte
+	Edits                  registry.Edits
+}
+
+// verifyInstrumentedPackage type-checks the package with instrumented files and rolls back the changes that introduce
+// new type errors, so that the code produced by a faulty recipe never reaches the compiler. The errors already present
+// in the original code, provided with `origErrs`, are ignored. It returns the instrumented code of files that have been
+// kept.
+func verifyInstrumentedPackage(logger zerolog.Logger, fset *token.FileSet, pkg *ast.Package, imp types.Importer, origErrs []types.Error, changed map[string]instrumentedFile) map[string][]byte {
+	// the recipes change the AST in place, so the original code of changed files is parsed again to check them separately
+	orig := &ast.Package{Name: pkg.Name, Files: make(map[string]*ast.File, len(pkg.Files))}
+	for fName, f := range pkg.Files {
+		orig.Files[fName] = f
+
+		if data := changed[fName].Original; len(data) > 0 {
+			if f, err := parser.ParseFile(fset, fName, data, parser.ParseComments); err == nil {
+				orig.Files[fName] = f
+			}
+		}
+	}
+	pkg = orig
+
+	files := make(map[string]*ast.File, len(changed))
+	for _, fName := range sortedKeys(changed) {
+		f, err := parser.ParseFile(fset, fName, changed[fName].Instrumented, parser.ParseComments)
+		if err != nil {
+			logger.Warn().Msg(rollbackDiagnostic(fset, fName, changed[fName], types.Error{Fset: fset, Msg: err.Error()}))
+			continue
+		}
+
+		files[fName] = f
+	}
+
+	for len(files) > 0 {
+		errs := newTypeErrors(origErrs, checkInstrumentedPackage(logger, fset, pkg, imp, files))
+		if len(errs) == 0 {
+			break
+		}
+
+		failed := make(map[string]types.Error)
+		for _, err := range errs {
+			fName := err.Fset.Position(err.Pos).Filename
+			if _, ok := files[fName]; !ok {
+				continue
+			}
+
+			if _, ok := failed[fName]; !ok {
+				failed[fName] = err
+			}
+		}
+
+		// the errors found in an unchanged file are caused by the changes made to another one, e.g. when the type
+		// of a variable has been replaced, so each changed file is checked separately to find the culprit
+		if len(failed) == 0 {
+			for _, fName := range sortedKeys(files) {
+				errs := newTypeErrors(origErrs, checkInstrumentedPackage(logger, fset, pkg, imp, map[string]*ast.File{fName: files[fName]}))
+				if len(errs) > 0 {
+					failed[fName] = errs[0]
+				}
+			}
+		}
+
+		// the changes only fail in combination, so none of them can be kept
+		if len(failed) == 0 {
+			for fName := range files {
+				failed[fName] = errs[0]
+			}
+		}
+
+		for _, fName := range sortedKeys(failed) {
+			logger.Warn().Msg(rollbackDiagnostic(fset, fName, changed[fName], failed[fName]))
+			delete(files, fName)
+		}
+	}
+
+	changes := make(map[string][]byte, len(files))
+	for fName := range files {
+		changes[fName] = changed[fName].Instrumented
+	}
+
+	return changes
+}
+
+// checkInstrumentedPackage type-checks the package, where the files listed in `instrumented` replace the original ones
+func checkInstrumentedPackage(logger zerolog.Logger, fset *token.FileSet, pkg *ast.Package, imp types.Importer, instrumented map[string]*ast.File) []types.Error {
+	files := make(map[string]*ast.File, len(pkg.Files))
+	for fName, f := range pkg.Files {
+		files[fName] = f
+	}
+
+	for fName, f := range instrumented {
+		files[fName] = f
+	}
+
+	_, errs := typeCheckPackage(logger, fset, &ast.Package{Name: pkg.Name, Files: files}, imp)
+
+	return errs
+}
+
+// newTypeErrors returns the errors from `errs` that are not found among `origErrs`. The errors are compared
+// by their messages, since the positions change after instrumentation.
+func newTypeErrors(origErrs, errs []types.Error) []types.Error {
+	known := make(map[string]int, len(origErrs))
+	for _, err := range origErrs {
+		known[err.Msg]++
+	}
+
+	var res []types.Error
+	for _, err := range errs {
+		if known[err.Msg] > 0 {
+			known[err.Msg]--
+			continue
+		}
+
+		res = append(res, err)
+	}
+
+	return res
+}
+
+// rollbackDiagnostic returns the message describing the type error that caused the rollback of the file along with
+// the recipe edit that is the most likely cause of it, i.e. the last edit made before the erroneous line of the original
+// code
+func rollbackDiagnostic(fset *token.FileSet, fName string, f instrumentedFile, typeErr types.Error) string {
+	edits := f.Edits.Applied()
+	if len(edits) == 0 {
+		return fmt.Sprintf("[ROLLED BACK] %s: instrumented code does not compile: %s", fName, typeErr.Msg)
+	}
+
+	culprit := edits[0]
+	if errPos := typeErr.Fset.Position(typeErr.Pos); errPos.Filename == fName && len(f.Original) > 0 {
+		if lines, _ := sourceLines(string(f.Original), string(f.Instrumented)); errPos.Line <= len(lines) {
+			var culpritLine int
+			for _, e := range edits {
+				if line := fset.Position(e.Pos).Line; line <= lines[errPos.Line-1] && line > culpritLine {
+					culprit, culpritLine = e, line
+				}
+			}
+		}
+	}
+
+	return fmt.Sprintf("[ROLLED BACK] %s: %s: instrumented code does not compile: %s, the changes of %s are discarded",
+		fset.Position(culprit.Pos), culprit.Recipe, typeErr.Msg, fName)
+}
+
+// sortedKeys returns the keys of the map in alphabetical order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
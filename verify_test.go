@@ -0,0 +1,169 @@
+// (c) Copyright IBM Corp. 2022
+
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+
+	"github.com/instana/go-instana/internal/registry"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyInstrumentedPackage(t *testing.T) {
+	examples := map[string]struct {
+		Original     map[string]string
+		Instrumented map[string]string
+		Expected     []string
+	}{
+		"valid changes are kept": {
+			Original: map[string]string{
+				"main.go": "package main\n\nimport \"strings\"\n\nfunc main() { _ = strings.ToUpper(\"a\") }\n",
+			},
+			Instrumented: map[string]string{
+				"main.go": "package main\n\nimport \"strings\"\n\nfunc main() { _ = strings.ToLower(\"a\") }\n",
+			},
+			Expected: []string{"main.go"},
+		},
+		"missing export data": {
+			Original: map[string]string{
+				"main.go": "package main\n\nimport \"github.com/gin-gonic/gin\"\n\nvar __instanaSensor int\n\nvar engine *gin.Engine = gin.New()\n",
+			},
+			Instrumented: map[string]string{
+				"main.go": "package main\n\nimport (\n\t\"github.com/gin-gonic/gin\"\n\tinstagin \"github.com/instana/go-sensor/instrumentation/instagin\"\n)\n\nvar __instanaSensor int\n\nvar engine *gin.Engine = instagin.New(__instanaSensor)\n",
+			},
+			Expected: []string{"main.go"},
+		},
+		"error in changed file": {
+			Original: map[string]string{
+				"main.go":  "package main\n\nfunc main() { _ = value() }\n",
+				"value.go": "package main\n\nfunc value() int { return 1 }\n",
+			},
+			Instrumented: map[string]string{
+				"main.go":  "package main\n\nfunc main() { _ = value(\"extra\") }\n",
+				"value.go": "package main\n\nfunc value() int { return 2 }\n",
+			},
+			Expected: []string{"value.go"},
+		},
+		"error in unchanged file": {
+			Original: map[string]string{
+				"main.go":  "package main\n\nfunc main() { value().Do() }\n",
+				"value.go": "package main\n\ntype T struct{}\n\nfunc (T) Do() {}\n\nfunc value() T { return T{} }\n",
+			},
+			Instrumented: map[string]string{
+				"value.go": "package main\n\ntype T struct{}\n\nfunc value() T { return T{} }\n",
+			},
+		},
+		"syntax error": {
+			Original: map[string]string{
+				"main.go": "package main\n\nfunc main() {}\n",
+			},
+			Instrumented: map[string]string{
+				"main.go": "package main\n\nfunc main() {\n",
+			},
+		},
+		"existing errors are ignored": {
+			Original: map[string]string{
+				"main.go": "package main\n\nfunc main() { undefinedFunc() }\n",
+			},
+			Instrumented: map[string]string{
+				"main.go": "package main\n\nfunc main() { undefinedFunc(); println() }\n",
+			},
+			Expected: []string{"main.go"},
+		},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			fset := token.NewFileSet()
+
+			pkg := &ast.Package{Name: "main", Files: make(map[string]*ast.File)}
+			for fName, code := range example.Original {
+				f, err := parser.ParseFile(fset, fName, code, parser.ParseComments)
+				require.NoError(t, err)
+
+				pkg.Files[fName] = f
+			}
+
+			imp := exportData(nil).Importer(log.Logger, fset)
+			_, origErrs := typeCheckPackage(log.Logger, fset, pkg, imp)
+
+			changed := make(map[string]instrumentedFile)
+			for fName, code := range example.Instrumented {
+				changed[fName] = instrumentedFile{
+					Original:     []byte(example.Original[fName]),
+					Instrumented: []byte(code),
+					Edits:        registry.Edits{{Recipe: "test", Pos: pkg.Files[fName].Pos()}},
+				}
+			}
+
+			changes := verifyInstrumentedPackage(log.Logger, fset, pkg, imp, origErrs, changed)
+
+			var fNames []string
+			for fName, data := range changes {
+				assert.Equal(t, example.Instrumented[fName], string(data))
+				fNames = append(fNames, fName)
+			}
+
+			assert.ElementsMatch(t, example.Expected, fNames)
+		})
+	}
+}
+
+func TestRollbackDiagnostic(t *testing.T) {
+	original := `package main
+
+func main() {
+	first()
+
+	second()
+}
+`
+	instrumented := `package main
+
+func main() {
+	wrapped(first())
+
+	// the second call is wrapped
+	wrapped(second())
+}
+`
+
+	fset := token.NewFileSet()
+
+	f, err := parser.ParseFile(fset, "main.go", original, 0)
+	require.NoError(t, err)
+
+	calls := f.Decls[0].(*ast.FuncDecl).Body.List
+	edits := registry.Edits{
+		{Recipe: "first", Pos: calls[0].Pos()},
+		{Recipe: "second", Pos: calls[1].Pos()},
+		{Recipe: "skipped", Pos: calls[1].Pos(), Skipped: true},
+	}
+
+	instFile := fset.AddFile("main.go", -1, len(instrumented))
+	instFile.SetLinesForContent([]byte(instrumented))
+
+	assert.Equal(t,
+		"[ROLLED BACK] main.go:6:2: second: instrumented code does not compile: undefined: wrapped, the changes of main.go are discarded",
+		rollbackDiagnostic(fset, "main.go", instrumentedFile{
+			Original:     []byte(original),
+			Instrumented: []byte(instrumented),
+			Edits:        edits,
+		}, types.Error{Fset: fset, Pos: instFile.LineStart(7) + 1, Msg: "undefined: wrapped"}),
+	)
+
+	assert.Equal(t,
+		"[ROLLED BACK] main.go:4:2: first: instrumented code does not compile: undefined: wrapped, the changes of main.go are discarded",
+		rollbackDiagnostic(fset, "main.go", instrumentedFile{
+			Original:     []byte(original),
+			Instrumented: []byte(instrumented),
+			Edits:        edits,
+		}, types.Error{Fset: fset, Pos: instFile.LineStart(4) + 1, Msg: "undefined: wrapped"}),
+	)
+}